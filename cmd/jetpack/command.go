@@ -229,11 +229,14 @@ func getPod(name string) (*jetpack.Pod, error) {
 }
 
 func getPodManifest(args []string) (*schema.PodManifest, error) {
-	if err := acutil.ParseApps(thePodManifest, args); err != nil {
+	if len(flStaticIPs) > 0 {
+		thePodManifest.Annotations.Set(jetpack.StaticIPAnnotation, strings.Join(flStaticIPs, ","))
+	}
+	if overrides, err := acutil.ParseApps(thePodManifest, args); err != nil {
 		return nil, errors.Trace(err)
 	} else if acutil.IsPodManifestEmpty(thePodManifest) {
 		return nil, ErrUsage
-	} else if pm, err := Host.ReifyPodManifest(thePodManifest); err != nil {
+	} else if pm, err := Host.ReifyPodManifest(thePodManifest, overrides); err != nil {
 		return nil, errors.Trace(err)
 	} else {
 		return pm, nil
@@ -37,7 +37,9 @@ func QuietFlag(fl *flag.FlagSet, desc string) {
 }
 
 var thePodManifest = schema.BlankPodManifest()
+var flStaticIPs sliceFlag
 
 func flPodManifest(fl *flag.FlagSet) {
 	acutil.PodManifestFlags(fl, thePodManifest)
+	fl.Var(&flStaticIPs, "ip", "Use static IP address (at most one for each address family)")
 }
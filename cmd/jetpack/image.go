@@ -24,6 +24,7 @@ func init() {
 	AddCommand("destroy-image IMAGE", "Destroy an image", cmdWrapImage0(cmdDestroyImage, true), nil)
 	AddCommand("export IMAGE [FILE]", "Export image to an ACI file", cmdWrapImage(cmdExportImage, true), flExport)
 	AddCommand("build IMAGE COMMAND ARGS...", "Build a new image", cmdWrapImage(cmdBuild, false), flBuild)
+	AddCommand("commit -manifest=FILE POD[:APP]", "Commit app's rootfs into a new image", cmdWrapApp0(cmdCommit), flCommit)
 }
 
 var flExportFlat bool
@@ -182,3 +183,32 @@ func cmdBuild(img *jetpack.Image, args []string) error {
 		return nil
 	}
 }
+
+var flCommitManifest string
+
+func flCommit(fl *flag.FlagSet) {
+	SaveIDFlag(fl)
+	fl.StringVar(&flCommitManifest, "manifest", "", "New image's manifest (required)")
+}
+
+func cmdCommit(app *jetpack.App) error {
+	if flCommitManifest == "" {
+		return ErrUsage
+	}
+	manifestBytes, err := ioutil.ReadFile(flCommitManifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if nimg, err := app.Pod.Commit(app.Name, manifestBytes); err != nil {
+		return errors.Trace(err)
+	} else {
+		if err := cmdShowImage(nimg); err != nil {
+			return errors.Trace(err)
+		}
+		if SaveID != "" {
+			return errors.Trace(ioutil.WriteFile(SaveID,
+				[]byte(nimg.Hash.String()+"\n"), 0644))
+		}
+		return nil
+	}
+}
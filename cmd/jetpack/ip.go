@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+)
+
+func init() {
+	AddCommand("ip list", "List IP addresses leased to pods", cmdIP, flList)
+}
+
+func cmdIP(args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return ErrUsage
+	}
+	leases, err := Host.IPLeases()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	items := make([][]string, len(leases))
+	for i, lease := range leases {
+		name := "-"
+		if id := uuid.Parse(lease.Pod); id != nil {
+			if pod, err := Host.GetPod(id); err == nil {
+				name = pod.Name()
+			}
+		}
+		static := ""
+		if lease.Static {
+			static = "static"
+		}
+		items[i] = []string{lease.IP, lease.Pod, name, static}
+	}
+	return doList("IP\tPOD\tNAME\tTYPE", items)
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/appc/spec/schema/types"
 	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/jetpack"
 )
 
 func init() {
@@ -49,16 +51,27 @@ func cmdListPods([]string) error {
 		apps := make([]string, len(pod.Manifest.Apps))
 		for j, app := range pod.Manifest.Apps {
 			apps[j] = app.Name.String()
+			if st, err := pod.AppState(app.Name); err == nil && st.Status != jetpack.AppStatusInvalid {
+				apps[j] += ":" + st.String()
+			}
+		}
+		ports := "?"
+		if mappings, err := pod.PortMappings(); err == nil {
+			portStrs := make([]string, len(mappings))
+			for j, mapping := range mappings {
+				portStrs[j] = mapping.String()
+			}
+			ports = strings.Join(portStrs, ", ")
 		}
-		ipAddress, _ := pod.Manifest.Annotations.Get("ip-address")
 		items[i] = []string{
 			pod.ID(),
 			pod.Status().String(),
-			ipAddress,
+			strings.Join(pod.IPAddresses(), ", "),
 			strings.Join(apps, ", "),
+			ports,
 		}
 	}
-	return doList("ID\tSTATUS\tIP\tAPPS\t", items)
+	return doList("ID\tSTATUS\tIP\tAPPS\tPORTS", items)
 }
 
 func doList(header string, items [][]string) error {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/applog"
+	"github.com/3ofcoins/jetpack/lib/jetpack"
+)
+
+func init() {
+	AddCommand("logs POD[:APP]", "Show apps' logged output", cmdWrapPodMaybeApp(cmdLogs), flLogs)
+}
+
+var flLogsFollow bool
+var flLogsSince, flLogsStream string
+var flLogsTail int
+
+func flLogs(fl *flag.FlagSet) {
+	fl.BoolVar(&flLogsFollow, "f", false, "Follow the logs")
+	fl.StringVar(&flLogsSince, "since", "", "Show entries since timestamp (RFC3339) or duration ago (e.g. 1h)")
+	fl.IntVar(&flLogsTail, "tail", 0, "Show only last N entries (0 for all)")
+	fl.StringVar(&flLogsStream, "stream", "", "Show only one stream (out or err)")
+}
+
+type logEntry struct {
+	applog.Entry
+	source jetpack.LogSource
+}
+
+type logEntries []logEntry
+
+func (le logEntries) Len() int           { return len(le) }
+func (le logEntries) Less(i, j int) bool { return le[i].Timestamp.Before(le[j].Timestamp) }
+func (le logEntries) Swap(i, j int)      { le[i], le[j] = le[j], le[i] }
+
+func parseSince(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func printLogEntry(e logEntry) {
+	fmt.Printf("%v %v %v\n", e.Timestamp.Format(time.RFC3339Nano), e.source, e.Text)
+}
+
+func cmdLogs(pod *jetpack.Pod, app *jetpack.App, args []string) error {
+	if len(args) > 0 {
+		return ErrUsage
+	}
+
+	var since time.Time
+	if flLogsSince != "" {
+		if t, err := parseSince(flLogsSince); err != nil {
+			return errors.Errorf("Invalid -since value %#v", flLogsSince)
+		} else {
+			since = t
+		}
+	}
+
+	streams := jetpack.LogStreams
+	switch flLogsStream {
+	case "":
+	case "out", "err":
+		streams = []string{flLogsStream}
+	default:
+		return errors.Errorf("Invalid -stream value %#v (allowed values: out, err)", flLogsStream)
+	}
+
+	var apps []*jetpack.App
+	if app != nil {
+		apps = []*jetpack.App{app}
+	} else {
+		apps = pod.Apps()
+	}
+
+	var sources []jetpack.LogSource
+	for _, app := range apps {
+		for _, stream := range streams {
+			sources = append(sources, jetpack.LogSource{App: app.Name, Stream: stream})
+		}
+	}
+
+	var entries logEntries
+	offsets := make([]int64, len(sources))
+	for i, src := range sources {
+		ee, offset, err := pod.ReadLog(src.App, src.Stream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, e := range ee {
+			if !e.Timestamp.Before(since) {
+				entries = append(entries, logEntry{e, src})
+			}
+		}
+		offsets[i] = offset
+	}
+
+	sort.Stable(entries)
+	if flLogsTail > 0 && len(entries) > flLogsTail {
+		entries = entries[len(entries)-flLogsTail:]
+	}
+	for _, e := range entries {
+		printLogEntry(e)
+	}
+
+	if !flLogsFollow {
+		return nil
+	}
+
+	// Follow until interrupted
+	stop := make(chan struct{})
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigch)
+	go func() {
+		<-sigch
+		close(stop)
+	}()
+
+	var mx sync.Mutex
+	errs := make(chan error, len(sources))
+	for i, src := range sources {
+		go func(src jetpack.LogSource, offset int64) {
+			errs <- pod.FollowLog(src.App, src.Stream, offset, stop, func(e applog.Entry) {
+				mx.Lock()
+				defer mx.Unlock()
+				printLogEntry(logEntry{e, src})
+			})
+		}(src, offsets[i])
+	}
+
+	var erv error
+	for range sources {
+		if err := <-errs; err != nil && erv == nil {
+			erv = err
+		}
+	}
+	return errors.Trace(erv)
+}
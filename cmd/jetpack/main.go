@@ -12,8 +12,20 @@ import (
 
 const AppName = "jetpack"
 
+// ExitStatus is an error that makes jetpack exit with a specific
+// status, e.g. to pass on exit status of an app. The app has already
+// reported what went wrong, so nothing is printed.
+type ExitStatus int
+
+func (es ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(es))
+}
+
 // Die on error
 func Die(err error) {
+	if es, ok := errors.Cause(err).(ExitStatus); ok {
+		os.Exit(int(es))
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, errors.ErrorStack(err))
 		os.Exit(1)
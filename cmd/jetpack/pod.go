@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/juju/errors"
@@ -24,11 +26,18 @@ func init() {
 	AddCommand("manifest POD", "Show pod manifest", cmdWrapPod0(cmdPodManifest), nil)
 	AddCommand("destroy POD", "Destroy a pod", cmdWrapPod0(cmdDestroyPod), nil)
 	AddCommand("kill POD", "Kill a running pod", cmdWrapPod0(cmdKillPod), nil)
-	AddCommand("ps POD [ARGS...]", "Show pod's process list (ps)", cmdWrapPod(cmdPodCmd("/bin/ps", "-J")), nil)
+	AddCommand("stop POD", "Stop a running pod gracefully", cmdWrapPod0(cmdStopPod), flStop)
+	AddCommand("health POD", "Show health of pod's apps", cmdWrapPod0(cmdPodHealth), flList)
+	AddCommand("snapshot POD [NAME]", "Snapshot pod's filesystems", cmdWrapPod(cmdPodSnapshot), nil)
+	AddCommand("snapshots POD", "List pod's snapshots", cmdWrapPod0(cmdPodSnapshots), flList)
+	AddCommand("rollback POD NAME", "Roll pod's filesystems back to a snapshot", cmdWrapPod(cmdPodRollback), flRollback)
+	AddCommand("pod-export POD FILE|-", "Export pod with its filesystems to a file", cmdWrapPod(cmdPodExport), nil)
+	AddCommand("pod-import FILE|-", "Import pod exported with pod-export", cmdPodImport, flPodImport)
+	AddCommand("ps POD [ARGS...] | ps -project", "Show pod's process list (ps), or status of project's pods", cmdPs, flPs)
 	AddCommand("top POD [ARGS...]", "Show pod's process list (top)", cmdWrapPod(cmdPodCmd("/usr/bin/top", "-J")), nil)
 	AddCommand("killall POD [ARGS...]", "Kill pod's processes", cmdWrapPod(cmdPodCmd("/usr/bin/killall", "-j")), nil)
 	AddCommand("console POD[:APP]", "Open a console in app", cmdWrapApp0(cmdConsole), flConsole)
-	AddCommand("exec POD[:APP] COMMAND...", "Run a command in app", cmdWrapApp(cmdExec), nil)
+	AddCommand("exec [FLAGS] POD[:APP] COMMAND...", "Run a command in app", cmdWrapApp(cmdExec), flExec)
 	AddCommand("cp [FLAGS] ARGS...", "Copy files to/from pod (use POD:[APP|@VOL]:PATH for pod paths)", cmdCp, nil)
 }
 
@@ -68,7 +77,9 @@ func cmdPrepare(args []string) error {
 }
 
 var flAppName types.ACName
-var flDestroy, flTerminal bool
+var flDestroy, flTerminal, flNoTTY, flFailFast bool
+var flRestart, flLogFormat string
+var flRestartPolicy = jetpack.DefaultRestartPolicy
 
 func flRun(fl *flag.FlagSet) {
 	flPodManifest(fl)
@@ -76,9 +87,23 @@ func flRun(fl *flag.FlagSet) {
 	fl.Var(&flAppName, "app", "Specify app to run for a multi-app pod")
 	fl.BoolVar(&flDestroy, "destroy", false, "Destroy pod when done")
 	fl.BoolVar(&flTerminal, "t", false, "Attach app to the terminal (single-app containers only)")
+	fl.BoolVar(&flNoTTY, "T", false, "Don't allocate a pseudo-terminal for app attached to the terminal")
+	fl.StringVar(&flRestart, "restart", "", "Restart apps when they exit: no, on-failure, always (overrides jetpack/restart annotations)")
+	fl.IntVar(&flRestartPolicy.MaxRetries, "restart-max-retries", 0, "Maximum number of restarts (0 is unlimited)")
+	fl.DurationVar(&flRestartPolicy.Backoff, "restart-backoff", flRestartPolicy.Backoff, "Delay before first restart, doubled after each restart")
+	fl.BoolVar(&flFailFast, "fail-fast", false, "Kill remaining apps when an app that is not optional fails")
+	fl.StringVar(&flLogFormat, "log-format", jetpack.LogFormatText, "Format of apps' output: text or json")
 }
 
 func cmdRun(pod *jetpack.Pod) (erv error) {
+	if flRestart != "" {
+		if err := flRestartPolicy.Mode.Set(flRestart); err != nil {
+			return errors.Trace(err)
+		}
+		pod.RestartPolicy = &flRestartPolicy
+	}
+	pod.LogFormat = flLogFormat
+	pod.FailFast = flFailFast
 	if flAppName.Empty() && flTerminal {
 		if len(pod.Manifest.Apps) != 1 {
 			return errors.New("Multi-app pod! Please use -app=NAME to choose")
@@ -100,14 +125,29 @@ func cmdRun(pod *jetpack.Pod) (erv error) {
 	}
 	if !flAppName.Empty() {
 		// Run one app on terminal
-		if app := pod.App(flAppName); app == nil {
+		app := pod.App(flAppName)
+		if app == nil {
 			return jetpack.ErrNotFound
-		} else {
-			return errors.Trace(app.Run(os.Stdin, os.Stdout, os.Stderr))
 		}
-	} else {
-		return errors.Trace(pod.Run())
+		app.NoTTY = flNoTTY
+		if err := app.RunAttached(os.Stdin, os.Stdout, os.Stderr); err != nil {
+			if st, err2 := app.State(); err2 == nil && st.Failed() {
+				return ExitStatus(st.ExitStatus())
+			}
+			return errors.Trace(err)
+		}
+		return nil
+	} else if err := pod.Run(); err != nil {
+		if perr, ok := errors.Cause(err).(*jetpack.PodRunError); ok {
+			// Pod.Run has already reported apps' results
+			return ExitStatus(perr.ExitStatus())
+		}
+		if status, err2 := pod.ExitStatus(); err2 == nil && status != 0 {
+			return ExitStatus(status)
+		}
+		return errors.Trace(err)
 	}
+	return nil
 }
 
 func cmdPodManifest(pod *jetpack.Pod) error {
@@ -127,6 +167,157 @@ func cmdKillPod(pod *jetpack.Pod) error {
 	return errors.Trace(pod.Kill())
 }
 
+var flStopTimeout int
+
+func flStop(fl *flag.FlagSet) {
+	fl.IntVar(&flStopTimeout, "t", -1, "Seconds to wait for apps to exit before killing them (default: stop.timeout setting)")
+}
+
+func cmdStopPod(pod *jetpack.Pod) error {
+	timeout := jetpack.DefaultStopTimeout()
+	if flStopTimeout >= 0 {
+		timeout = time.Duration(flStopTimeout) * time.Second
+	}
+	return errors.Trace(pod.Stop(timeout))
+}
+
+func cmdPodHealth(pod *jetpack.Pod) error {
+	var items [][]string
+	unhealthy := false
+	for _, rtapp := range pod.Manifest.Apps {
+		st, err := pod.AppState(rtapp.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if st.Health == nil {
+			continue
+		}
+		items = append(items, []string{
+			rtapp.Name.String(),
+			string(st.Health.Status),
+			strconv.Itoa(st.Health.Failures),
+			st.Health.Checked.Format(time.RFC3339),
+			strings.SplitN(st.Health.Output, "\n", 2)[0],
+		})
+		if st.Health.Status == jetpack.HealthUnhealthy {
+			unhealthy = true
+		}
+	}
+	if err := doList("APP\tHEALTH\tFAILURES\tCHECKED\tOUTPUT", items); err != nil {
+		return errors.Trace(err)
+	}
+	if unhealthy {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+func cmdPodSnapshot(pod *jetpack.Pod, args []string) error {
+	if len(args) > 1 {
+		return ErrUsage
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if name, err := pod.Snapshot(name); err != nil {
+		return errors.Trace(err)
+	} else {
+		fmt.Println(name)
+		return nil
+	}
+}
+
+func cmdPodSnapshots(pod *jetpack.Pod) error {
+	snaps, err := pod.Snapshots()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	items := make([][]string, len(snaps))
+	for i, snap := range snaps {
+		items[i] = []string{snap.Name, snap.Created.Format(time.RFC3339)}
+	}
+	return doList("NAME\tCREATED", items)
+}
+
+var flForce bool
+
+func flRollback(fl *flag.FlagSet) {
+	fl.BoolVar(&flForce, "force", false, "Stop the pod if it is running")
+}
+
+func cmdPodRollback(pod *jetpack.Pod, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	if flForce && pod.Jid() != 0 {
+		if err := pod.Stop(jetpack.DefaultStopTimeout()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := pod.Rollback(args[0]); err == jetpack.ErrPodRunning {
+		return errors.New("Pod is running; stop it first, or use -force")
+	} else {
+		return errors.Trace(err)
+	}
+}
+
+func cmdPodExport(pod *jetpack.Pod, args []string) (erv error) {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	if args[0] == "-" {
+		return errors.Trace(pod.Export(os.Stdout))
+	}
+	output, err := os.Create(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err := output.Close(); err != nil && erv == nil {
+			erv = errors.Trace(err)
+		}
+		if erv != nil {
+			os.Remove(args[0])
+		}
+	}()
+	return errors.Trace(pod.Export(output))
+}
+
+var flKeepUUID, flKeepIP bool
+
+func flPodImport(fl *flag.FlagSet) {
+	SaveIDFlag(fl)
+	fl.BoolVar(&flKeepUUID, "keep-uuid", false, "Keep pod's original UUID")
+	fl.BoolVar(&flKeepIP, "keep-ip", false, "Request pod's original IP addresses as static ones")
+}
+
+func cmdPodImport(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	input := os.Stdin
+	if args[0] != "-" {
+		if f, err := os.Open(args[0]); err != nil {
+			return errors.Trace(err)
+		} else {
+			input = f
+			defer input.Close()
+		}
+	}
+	pod, err := Host.ImportPod(input, flKeepUUID, flKeepIP)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if SaveID != "" {
+		if err := ioutil.WriteFile(SaveID, []byte(pod.UUID.String()), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	fmt.Println(pod.UUID)
+	return nil
+}
+
 func cmdPodCmd(cmd string, baseArgs ...string) func(*jetpack.Pod, []string) error {
 	return func(pod *jetpack.Pod, args []string) error {
 		jid := pod.Jid()
@@ -138,18 +329,64 @@ func cmdPodCmd(cmd string, baseArgs ...string) func(*jetpack.Pod, []string) erro
 	}
 }
 
+var flPsProject bool
+
+func flPs(fl *flag.FlagSet) {
+	flList(fl)
+	flProject(fl)
+	fl.BoolVar(&flPsProject, "project", false, "Show status of pods of the project file")
+}
+
+func cmdPs(args []string) error {
+	if flPsProject {
+		if len(args) > 0 {
+			return ErrUsage
+		}
+		return errors.Trace(cmdProjectPs())
+	}
+	return cmdWrapPod(cmdPodCmd("/bin/ps", "-J"))(args)
+}
+
 var flConsoleUsername string
 
 func flConsole(fl *flag.FlagSet) {
 	fl.StringVar(&flConsoleUsername, "u", "root", "Username to run console as")
+	fl.BoolVar(&flNoTTY, "T", false, "Don't allocate a pseudo-terminal, even if attached to a terminal")
 }
 
 func cmdConsole(app *jetpack.App) error {
+	app.NoTTY = flNoTTY
 	return errors.Trace(app.Console(flConsoleUsername))
 }
 
+var flExecOptions jetpack.ExecOptions
+var flExecEnv sliceFlag
+
+func flExec(fl *flag.FlagSet) {
+	fl.StringVar(&flExecOptions.User, "u", "", "User to run command as (default: app's user)")
+	fl.StringVar(&flExecOptions.Group, "g", "", "Group to run command as (default: app's group)")
+	fl.StringVar(&flExecOptions.Cwd, "w", "", "Working directory (default: app's working directory)")
+	fl.Var(&flExecEnv, "e", "Set environment variable (NAME=VALUE)")
+	fl.BoolVar(&flExecOptions.CleanEnv, "clean-env", false, "Don't pass app's environment to command")
+	fl.BoolVar(&flExecOptions.Stop, "stop", false, "Stop pod's jail afterwards if it has been started for the command")
+	fl.BoolVar(&flNoTTY, "T", false, "Don't allocate a pseudo-terminal, even if attached to a terminal")
+}
+
 func cmdExec(app *jetpack.App, args []string) error {
-	return errors.Trace(app.Stage2(os.Stdin, os.Stdout, os.Stderr, "", "", "", args...))
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	if flExecOptions.Cwd != "" && !path.IsAbs(flExecOptions.Cwd) {
+		return errors.Errorf("Working directory %#v is not absolute", flExecOptions.Cwd)
+	}
+	for _, ev := range flExecEnv {
+		if strings.Index(ev, "=") < 1 {
+			return errors.Errorf("Invalid environment variable %#v, expected NAME=VALUE", ev)
+		}
+	}
+	flExecOptions.Env = flExecEnv
+	app.NoTTY = flNoTTY
+	return errors.Trace(app.Exec(&flExecOptions, os.Stdin, os.Stdout, os.Stderr, args...))
 }
 
 // Arguments for cp to leave unprocessed (switches and local paths):
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/jetpack"
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+func init() {
+	AddCommand("up", "Prepare and start pods of a project", cmdUp, flUp)
+	AddCommand("down", "Stop and destroy pods of a project", cmdDown, flProject)
+}
+
+var flProjectFile string
+var flUpTimeout time.Duration
+
+func flProject(fl *flag.FlagSet) {
+	fl.StringVar(&flProjectFile, "f", "jetpack.json", "Project file")
+}
+
+func flUp(fl *flag.FlagSet) {
+	flProject(fl)
+	fl.DurationVar(&flUpTimeout, "timeout", time.Minute, "Time to wait for each pod to start")
+}
+
+func projectStop(pod *jetpack.Pod, name, action string) error {
+	fmt.Printf("%v: %v\n", name, action)
+	if err := pod.Stop(jetpack.DefaultStopTimeout()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(pod.Destroy())
+}
+
+// Starts pod in background, running `jetpack run` with daemon(8).
+// Output of the run is saved in pod's run.log.
+func projectStart(pod *jetpack.Pod, name string) error {
+	fmt.Printf("%v: starting\n", name)
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	args := []string{"-f", "-o", pod.Path("run.log"), self, "-config", jetpack.ConfigPath}
+	for k, v := range jetpack.ConfigOverrides {
+		args = append(args, "-o", k+"="+v)
+	}
+	args = append(args, "run", pod.UUID.String())
+	since := time.Now()
+	if err := run.Command("/usr/sbin/daemon", args...).Run(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(pod.WaitRunning(since, flUpTimeout))
+}
+
+// Returns names of pods in map, sorted
+func projectPodNames(pods map[string]*jetpack.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for name := range pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cmdUp(args []string) error {
+	if len(args) > 0 {
+		return ErrUsage
+	}
+	project, err := jetpack.LoadProject(flProjectFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	order, err := project.StartOrder()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	existing := Host.ProjectPods(project.Name)
+	for _, name := range projectPodNames(existing) {
+		if project.Pod(name) == nil {
+			if err := projectStop(existing[name], name, "removing"); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	for _, pp := range order {
+		pod := existing[pp.Name]
+		if pod != nil {
+			if hash, _ := pod.Manifest.Annotations.Get(jetpack.ProjectHashAnnotation); hash != pp.Hash() {
+				if err := projectStop(pod, pp.Name, "recreating"); err != nil {
+					return errors.Trace(err)
+				}
+				pod = nil
+			}
+		}
+		if pod == nil {
+			fmt.Printf("%v: preparing\n", pp.Name)
+			if pm, err := pp.PodManifest(project.Name); err != nil {
+				return errors.Trace(err)
+			} else if pm, err := Host.ReifyPodManifest(pm, nil); err != nil {
+				return errors.Annotate(err, pp.Name)
+			} else if pod, err = Host.CreatePod(pm); err != nil {
+				return errors.Annotate(err, pp.Name)
+			}
+		}
+		if pod.Status() != jetpack.PodStatusRunning {
+			if err := projectStart(pod, pp.Name); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+func cmdDown(args []string) error {
+	if len(args) > 0 {
+		return ErrUsage
+	}
+	project, err := jetpack.LoadProject(flProjectFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	order, err := project.StartOrder()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Pods that are no longer in the project go first, then the rest in
+	// reverse start order
+	existing := Host.ProjectPods(project.Name)
+	var names []string
+	for _, name := range projectPodNames(existing) {
+		if project.Pod(name) == nil {
+			names = append(names, name)
+		}
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		names = append(names, order[i].Name)
+	}
+
+	for _, name := range names {
+		if pod := existing[name]; pod != nil {
+			if err := projectStop(pod, name, "destroying"); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Lists project's pods: created ones with their status, and ones that
+// are missing or whose definition has changed.
+func cmdProjectPs() error {
+	project, err := jetpack.LoadProject(flProjectFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	existing := Host.ProjectPods(project.Name)
+	var items [][]string
+	for _, pp := range project.Pods {
+		pod := existing[pp.Name]
+		if pod == nil {
+			items = append(items, []string{pp.Name, "-", "missing", "", ""})
+			continue
+		}
+		status := pod.Status().String()
+		if hash, _ := pod.Manifest.Annotations.Get(jetpack.ProjectHashAnnotation); hash != pp.Hash() {
+			status += ",changed"
+		}
+		items = append(items, projectPsItem(pp.Name, pod, status))
+	}
+	for _, name := range projectPodNames(existing) {
+		if project.Pod(name) == nil {
+			pod := existing[name]
+			items = append(items, projectPsItem(name, pod, pod.Status().String()+",removed"))
+		}
+	}
+	return doList("NAME\tID\tSTATUS\tIP\tAPPS", items)
+}
+
+func projectPsItem(name string, pod *jetpack.Pod, status string) []string {
+	apps := make([]string, len(pod.Manifest.Apps))
+	for i, app := range pod.Manifest.Apps {
+		apps[i] = app.Name.String()
+		if st, err := pod.AppState(app.Name); err == nil && st.Status != jetpack.AppStatusInvalid {
+			apps[i] += ":" + st.String()
+		}
+	}
+	return []string{name, pod.ID(), status, strings.Join(pod.IPAddresses(), ", "), strings.Join(apps, ", ")}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/jetpack"
+)
+
+func init() {
+	AddCommand("volume create|list|inspect|destroy ...", "Manage named volumes (use -v NAME:@VOLUME to mount them in pods)", cmdVolume, nil)
+}
+
+var volumeCommands = map[string]struct {
+	usage   string
+	handler func([]string) error
+	flags   func(*flag.FlagSet)
+}{
+	"create":  {"[-o PROPERTY=VALUE...] NAME", cmdVolumeCreate, flVolumeCreate},
+	"list":    {"", cmdVolumeList, flList},
+	"inspect": {"NAME", cmdVolumeInspect, nil},
+	"destroy": {"NAME", cmdVolumeDestroy, nil},
+}
+
+func cmdVolume(args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	sub, ok := volumeCommands[args[0]]
+	if !ok {
+		return ErrUsage
+	}
+	fl := flag.NewFlagSet("volume "+args[0], flag.ExitOnError)
+	if sub.flags != nil {
+		sub.flags(fl)
+	}
+	fl.Parse(args[1:])
+	if err := sub.handler(fl.Args()); err == ErrUsage {
+		return fmt.Errorf("Usage: %v volume %v %v", AppName, args[0], sub.usage)
+	} else {
+		return errors.Trace(err)
+	}
+}
+
+var flVolumeProperties sliceFlag
+
+func flVolumeCreate(fl *flag.FlagSet) {
+	fl.Var(&flVolumeProperties, "o", "Set ZFS property of the volume (e.g. quota=10G, compression=lz4)")
+}
+
+func getVolume(name string) (*jetpack.Volume, error) {
+	acn, err := types.NewACName(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if vol, err := Host.GetVolume(*acn); err == jetpack.ErrNotFound {
+		return nil, errors.Errorf("Volume %v not found", name)
+	} else {
+		return vol, errors.Trace(err)
+	}
+}
+
+func cmdVolumeCreate(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	name, err := types.NewACName(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	props := make(map[string]string)
+	for _, prop := range flVolumeProperties {
+		pieces := strings.SplitN(prop, "=", 2)
+		if len(pieces) != 2 {
+			return errors.Errorf("Invalid property %#v (expected PROPERTY=VALUE)", prop)
+		}
+		props[pieces[0]] = pieces[1]
+	}
+	if _, err := Host.CreateVolume(*name, props); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func cmdVolumeList(args []string) error {
+	if len(args) > 0 {
+		return ErrUsage
+	}
+	vols, err := Host.Volumes()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	items := make([][]string, len(vols))
+	for i, vol := range vols {
+		props, err := vol.Properties()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		items[i] = []string{vol.Name.String(), props["used"], props["quota"], fmt.Sprint(len(vol.Pods()))}
+	}
+	return doList("NAME\tUSED\tQUOTA\tPODS", items)
+}
+
+func cmdVolumeInspect(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	vol, err := getVolume(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	props, err := vol.Properties()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name\t%v\nDataset\t%v\nPath\t%v\n", vol.Name, vol.Dataset.Name, vol.Path())
+	for _, prop := range jetpack.VolumeProperties {
+		fmt.Fprintf(tw, "%v\t%v\n", strings.Title(prop), props[prop])
+	}
+	if pods := vol.Pods(); len(pods) > 0 {
+		fmt.Fprint(tw, "Pods")
+		for _, pod := range pods {
+			fmt.Fprintf(tw, "\t%v %v\n", pod.ID(), pod.Name())
+		}
+	}
+	return tw.Flush()
+}
+
+func cmdVolumeDestroy(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	vol, err := getVolume(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(vol.Destroy())
+}
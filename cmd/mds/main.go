@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,16 +28,25 @@ import (
 var Host *jetpack.Host
 
 func getPod(ip string) *jetpack.Pod {
-	for _, pod := range Host.Pods() {
-		if podIp, _ := pod.Manifest.Annotations.Get("ip-address"); podIp == ip {
-			return pod
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return nil
+	}
+	pod, err := Host.PodByIP(clientIP)
+	if err != nil {
+		if err != jetpack.ErrNotFound {
+			log.Printf("Cannot find pod for %v: %v", ip, err)
 		}
+		return nil
 	}
-	return nil
+	return pod
 }
 
 func clientIP(r *http.Request) string {
-	return strings.SplitN(r.RemoteAddr, ":", 2)[0]
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
 }
 
 func resp200(v interface{}, ct string) (int, []byte, string) {
@@ -242,6 +252,23 @@ func ServeMetadata(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ServeDNS answers DNS queries for pods' names in mds.dns.domain
+func ServeDNS(conn net.PacketConn) error {
+	domain := jetpack.Config().GetString("mds.dns.domain", "jetpack")
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if resp := jetpack.DNSResponse(buf[:n], domain, Host.LookupPod); resp != nil {
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				log.Printf("Cannot send DNS response to %v: %v", addr, err)
+			}
+		}
+	}
+}
+
 var Info jetpack.MDSInfo
 var SigningKey []byte
 
@@ -259,10 +286,15 @@ func main() {
 		Host = host
 	}
 
-	if hostip, _, err := Host.HostIP(); err != nil {
+	if ip4, ip6, err := Host.HostIPs(); err != nil {
 		panic(err)
 	} else {
-		Info.IP = hostip.String()
+		if ip4 != nil {
+			Info.IP = ip4.String()
+		}
+		if ip6 != nil {
+			Info.IP6 = ip6.String()
+		}
 	}
 	Info.Port = jetpack.Config().MustGetInt("mds.port")
 
@@ -292,10 +324,33 @@ func main() {
 		}
 	}
 
-	addr := fmt.Sprintf("%v:%d", Info.IP, Info.Port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatalf("Cannot listen on %v: %v", addr, err)
+	var listeners []net.Listener
+	for _, ip := range []string{Info.IP, Info.IP6} {
+		if ip == "" {
+			continue
+		}
+		addr := net.JoinHostPort(ip, strconv.Itoa(Info.Port))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Cannot listen on %v: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	var dnsConns []net.PacketConn
+	if jetpack.Config().GetBool("mds.dns", false) {
+		dnsPort := jetpack.Config().GetInt("mds.dns.port", 53)
+		for _, ip := range []string{Info.IP, Info.IP6} {
+			if ip == "" {
+				continue
+			}
+			addr := net.JoinHostPort(ip, strconv.Itoa(dnsPort))
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				log.Fatalf("Cannot listen on %v/udp: %v", addr, err)
+			}
+			dnsConns = append(dnsConns, conn)
+		}
 	}
 
 	if !jetpack.Config().GetBool("mds.keep-uid", false) {
@@ -314,6 +369,18 @@ func main() {
 	Info.Uid = os.Getuid()
 	Info.Gid = os.Getgid()
 
-	log.Println("Listening on:", addr)
-	log.Fatal(http.Serve(listener, http.HandlerFunc(ServeMetadata)))
+	errs := make(chan error, len(listeners)+len(dnsConns))
+	for _, listener := range listeners {
+		log.Println("Listening on:", listener.Addr())
+		go func(l net.Listener) {
+			errs <- http.Serve(l, http.HandlerFunc(ServeMetadata))
+		}(listener)
+	}
+	for _, conn := range dnsConns {
+		log.Println("Serving DNS on:", conn.LocalAddr())
+		go func(c net.PacketConn) {
+			errs <- ServeDNS(c)
+		}(conn)
+	}
+	log.Fatal(<-errs)
 }
@@ -84,6 +84,8 @@ func (vfl *VolumesFlag) Set(val string) error {
 	if !strings.ContainsRune(val, ',') {
 		if pieces := strings.SplitN(val, ":", 2); len(pieces) == 1 {
 			val += ",kind=empty"
+		} else if strings.HasPrefix(pieces[1], "@") {
+			return vfl.setNamed(pieces[0], pieces[1])
 		} else {
 			val = fmt.Sprintf("%v,kind=host,source=%v", pieces[0], pieces[1])
 		}
@@ -101,6 +103,25 @@ func (vfl *VolumesFlag) Set(val string) error {
 	}
 }
 
+// Named volume reference (NAME:@VOLUME) is a host volume with source
+// "@VOLUME", which is not a valid path; it is replaced with the named
+// volume's path when the pod manifest is reified.
+func (vfl *VolumesFlag) setNamed(name, source string) error {
+	vol := types.Volume{Kind: "host", Source: source}
+	if name[0] == '-' {
+		readOnly := true
+		vol.ReadOnly = &readOnly
+		name = name[1:]
+	}
+	if acn, err := types.NewACName(name); err != nil {
+		return err
+	} else {
+		vol.Name = *acn
+	}
+	*vfl = append(*vfl, vol)
+	return nil
+}
+
 type PodManifestJSONFlag schema.PodManifest
 
 func (pmjf *PodManifestJSONFlag) String() string {
@@ -147,6 +168,126 @@ func (mf *MountsFlag) Set(val string) error {
 	return nil
 }
 
+// Parses a command: JSON array of arguments, or whitespace-separated
+// arguments
+func parseExec(val string) (types.Exec, error) {
+	var exec types.Exec
+	if strings.HasPrefix(strings.TrimSpace(val), "[") {
+		if err := json.Unmarshal([]byte(val), &exec); err != nil {
+			return nil, err
+		}
+	} else {
+		exec = strings.Fields(val)
+	}
+	if len(exec) == 0 {
+		return nil, errors.New("Command cannot be empty")
+	}
+	return exec, nil
+}
+
+type ExecFlag types.Exec
+
+func (ef *ExecFlag) String() string {
+	return fmt.Sprint(*ef)
+}
+
+func (ef *ExecFlag) Set(val string) error {
+	if exec, err := parseExec(val); err != nil {
+		return err
+	} else {
+		*ef = ExecFlag(exec)
+		return nil
+	}
+}
+
+type EnvironmentFlag types.Environment
+
+func (ef *EnvironmentFlag) String() string {
+	vv := make([]string, len(*ef))
+	for i, ev := range *ef {
+		vv[i] = fmt.Sprintf("%v=%#v", ev.Name, ev.Value)
+	}
+	return fmt.Sprintf("[%v]", strings.Join(vv, ","))
+}
+
+func (ef *EnvironmentFlag) Set(val string) error {
+	pieces := strings.SplitN(val, "=", 2)
+	if len(pieces) != 2 || pieces[0] == "" {
+		return errors.New("Environment variables must be provided in NAME=VALUE format")
+	}
+	(*types.Environment)(ef).Set(pieces[0], pieces[1])
+	return nil
+}
+
+// EnvFileFlag reads environment variables from a file with NAME=VALUE
+// lines. Empty lines and lines starting with '#' are skipped.
+type EnvFileFlag types.Environment
+
+func (eff *EnvFileFlag) String() string {
+	return "[PATH]"
+}
+
+func (eff *EnvFileFlag) Set(val string) error {
+	bb, err := readFileOrStdin(val)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(bb), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := (*EnvironmentFlag)(eff).Set(line); err != nil {
+			return fmt.Errorf("%v:%d: %v", val, i+1, err)
+		}
+	}
+	return nil
+}
+
+type EventHandlersFlag []types.EventHandler
+
+func (ehf *EventHandlersFlag) String() string {
+	vv := make([]string, len(*ehf))
+	for i, eh := range *ehf {
+		vv[i] = fmt.Sprintf("%v=%v", eh.Name, eh.Exec)
+	}
+	return fmt.Sprintf("[%v]", strings.Join(vv, ","))
+}
+
+func (ehf *EventHandlersFlag) Set(val string) error {
+	pieces := strings.SplitN(val, "=", 2)
+	if len(pieces) != 2 {
+		return errors.New("Event handlers must be provided in NAME=COMMAND format")
+	}
+	if pieces[0] != "pre-start" && pieces[0] != "post-stop" {
+		return fmt.Errorf("Invalid event handler %#v (must be pre-start or post-stop)", pieces[0])
+	}
+	exec, err := parseExec(pieces[1])
+	if err != nil {
+		return err
+	}
+	for i := range *ehf {
+		if (*ehf)[i].Name == pieces[0] {
+			(*ehf)[i].Exec = exec
+			return nil
+		}
+	}
+	*ehf = append(*ehf, types.EventHandler{Name: pieces[0], Exec: exec})
+	return nil
+}
+
+// Flags that override app's fields. Fields that are not set are taken
+// from the image when the pod manifest is reified.
+func AppOverrideFlags(fl *flag.FlagSet, app *types.App) {
+	fl.Var((*ExecFlag)(&app.Exec), "exec", "Override command (JSON array, or whitespace-separated arguments)")
+	fl.Var((*EnvironmentFlag)(&app.Environment), "e", "Set environment variable (NAME=VALUE)")
+	fl.Var((*EnvFileFlag)(&app.Environment), "env-file", "Read environment variables from a file (NAME=VALUE lines)")
+	fl.StringVar(&app.User, "user", "", "Override user")
+	fl.StringVar(&app.Group, "group", "", "Override group")
+	fl.StringVar(&app.WorkingDirectory, "cwd", "", "Override working directory")
+	fl.Var((*EventHandlersFlag)(&app.EventHandlers), "event-handler", "Override event handler (pre-start=COMMAND or post-stop=COMMAND)")
+}
+
 func PodManifestFlags(fl *flag.FlagSet, pm *schema.PodManifest) {
 	fl.Var((*PodManifestJSONFlag)(pm), "f", "Read JSON pod manifest file")
 	fl.Var((*AnnotationsFlag)(&pm.Annotations), "a", "Add annotation (NAME=VALUE)")
@@ -175,9 +316,14 @@ func ParseImageName(name string) (types.ACIdentifier, types.Labels, error) {
 	return app.Name, labels, nil
 }
 
-func parseApp(args []string) ([]string, *schema.RuntimeApp, error) {
+// AppOverrides are apps built from command line flags, by runtime
+// app's name. Unlike app in a pod manifest, which replaces image's
+// app, an override is merged on top of image's app.
+type AppOverrides map[types.ACName]*types.App
+
+func parseApp(args []string) ([]string, *schema.RuntimeApp, *types.App, error) {
 	if len(args) == 0 {
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 
 	rtapp := schema.RuntimeApp{}
@@ -191,26 +337,39 @@ func parseApp(args []string) ([]string, *schema.RuntimeApp, error) {
 		rtapp.Name.Set(path.Base(name.String())) // won't err here
 		rtapp.Image.Labels = labels
 	} else {
-		return args, nil, err
+		return args, nil, nil, err
 	}
 
 	fl := flag.NewFlagSet(args[0], flag.ExitOnError)
 	fl.Var(&rtapp.Name, "name", "App name")
 	fl.Var((*AnnotationsFlag)(&rtapp.Annotations), "a", "Add annotation (NAME=VALUE)")
 	fl.Var((*MountsFlag)(&rtapp.Mounts), "m", "Mount volume (VOLUME[:MOUNTPOINT])")
-	// TODO: app override
+	override := &types.App{}
+	AppOverrideFlags(fl, override)
 	fl.Parse(args[1:])
-	return fl.Args(), &rtapp, nil
+	if IsAppEmpty(override) {
+		return fl.Args(), &rtapp, nil, nil
+	}
+	if override.WorkingDirectory != "" && !path.IsAbs(override.WorkingDirectory) {
+		return args, nil, nil, fmt.Errorf("Working directory %#v is not absolute", override.WorkingDirectory)
+	}
+	return fl.Args(), &rtapp, override, nil
 }
 
-func ParseApps(pm *schema.PodManifest, args []string) error {
+// ParseApps adds apps given as command line arguments to pod
+// manifest, and returns app overrides given with their flags.
+func ParseApps(pm *schema.PodManifest, args []string) (AppOverrides, error) {
+	overrides := make(AppOverrides)
 	for len(args) > 0 {
-		if rest, rtapp, err := parseApp(args); err != nil {
-			return err
+		if rest, rtapp, override, err := parseApp(args); err != nil {
+			return nil, err
 		} else {
 			pm.Apps = append(pm.Apps, *rtapp)
+			if override != nil {
+				overrides[rtapp.Name] = override
+			}
 			args = rest
 		}
 	}
-	return nil
+	return overrides, nil
 }
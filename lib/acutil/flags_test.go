@@ -0,0 +1,95 @@
+package acutil
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestParseAppOverride(t *testing.T) {
+	envFile, err := ioutil.TempFile("", "jetpack-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(envFile.Name())
+	envFile.WriteString("# comment\n\nFOO=from-file\nBAR=baz\n")
+	envFile.Close()
+
+	rest, rtapp, override, err := parseApp([]string{
+		"example.com/app", "-name", "web",
+		"-exec", `["/bin/sh", "-c", "echo hi"]`,
+		"-env-file", envFile.Name(), "-e", "FOO=bar",
+		"-user", "www", "-cwd", "/srv",
+		"-event-handler", "pre-start=/usr/local/bin/migrate --all",
+		"example.com/other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rest, []string{"example.com/other"}) {
+		t.Errorf("Unexpected rest of arguments: %v", rest)
+	}
+	expected := &types.App{
+		Exec: types.Exec{"/bin/sh", "-c", "echo hi"},
+		EventHandlers: []types.EventHandler{
+			{Name: "pre-start", Exec: types.Exec{"/usr/local/bin/migrate", "--all"}},
+		},
+		User:             "www",
+		WorkingDirectory: "/srv",
+		Environment: types.Environment{
+			{Name: "FOO", Value: "bar"},
+			{Name: "BAR", Value: "baz"},
+		},
+	}
+	if !reflect.DeepEqual(override, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, override)
+	}
+	if rtapp.Name != "web" || rtapp.App != nil || len(rtapp.Annotations) != 0 {
+		t.Errorf("Override leaked into runtime app: %#v", rtapp)
+	}
+
+	if _, rtapp, override, err := parseApp([]string{"example.com/app"}); err != nil {
+		t.Error(err)
+	} else if override != nil || rtapp.App != nil {
+		t.Errorf("Expected no app override, got %#v", override)
+	}
+
+	if _, _, _, err := parseApp([]string{"example.com/app", "-cwd", "srv"}); err == nil {
+		t.Error("Expected error for relative working directory")
+	}
+}
+
+func TestEventHandlersFlag(t *testing.T) {
+	var ehf EventHandlersFlag
+	if err := ehf.Set("pre-stop=/bin/true"); err == nil {
+		t.Error("Expected error for invalid event handler name")
+	}
+	if err := ehf.Set("post-stop="); err == nil {
+		t.Error("Expected error for empty command")
+	}
+}
+
+func TestVolumesFlagNamed(t *testing.T) {
+	var vfl VolumesFlag
+	for _, val := range []string{"data:@blog-db", "-logs:@blog-logs", "src:/usr/src"} {
+		if err := vfl.Set(val); err != nil {
+			t.Fatalf("%v: %v", val, err)
+		}
+	}
+	tru := true
+	expected := VolumesFlag{
+		{Name: *types.MustACName("data"), Kind: "host", Source: "@blog-db"},
+		{Name: *types.MustACName("logs"), Kind: "host", Source: "@blog-logs", ReadOnly: &tru},
+		{Name: *types.MustACName("src"), Kind: "host", Source: "/usr/src"},
+	}
+	if !reflect.DeepEqual(vfl, expected) {
+		t.Errorf("Expected %v, got %v", expected, vfl)
+	}
+
+	if err := vfl.Set("Not_A_Name:@blog-db"); err == nil {
+		t.Error("Expected error for invalid volume name")
+	}
+}
@@ -21,3 +21,17 @@ func IsPodManifestEmpty(pm *schema.PodManifest) bool {
 			len(pm.Annotations) == 0 &&
 			len(pm.Ports) == 0)
 }
+
+func IsAppEmpty(app *types.App) bool {
+	return app == nil ||
+		(len(app.Exec) == 0 &&
+			len(app.EventHandlers) == 0 &&
+			app.User == "" &&
+			app.Group == "" &&
+			len(app.SupplementaryGIDs) == 0 &&
+			app.WorkingDirectory == "" &&
+			len(app.Environment) == 0 &&
+			len(app.MountPoints) == 0 &&
+			len(app.Ports) == 0 &&
+			len(app.Isolators) == 0)
+}
@@ -0,0 +1,262 @@
+// Package applog stores apps' output lines in size-rotated log files,
+// and reads them back.
+//
+// Each line of a log file is a RFC3339Nano timestamp, a space, and
+// the logged text. Rotated files have a numeric suffix; higher
+// numbers are older.
+package applog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("Closed")
+var ErrInvalidLine = errors.New("Invalid log line")
+
+// Entry is a single logged line
+type Entry struct {
+	Timestamp time.Time
+	Text      string
+}
+
+func (e Entry) String() string {
+	return e.Timestamp.Format(time.RFC3339Nano) + " " + e.Text
+}
+
+func parseEntry(line string) (Entry, error) {
+	pieces := strings.SplitN(line, " ", 2)
+	if len(pieces) != 2 {
+		return Entry{}, ErrInvalidLine
+	}
+	ts, err := time.Parse(time.RFC3339Nano, pieces[0])
+	if err != nil {
+		return Entry{}, ErrInvalidLine
+	}
+	return Entry{ts, pieces[1]}, nil
+}
+
+// Log is a log file open for writing
+type Log struct {
+	Path    string
+	MaxSize int64 // Rotate when file would grow larger than this; 0 to never rotate
+	Keep    int   // Number of rotated files to keep
+
+	f    *os.File
+	size int64
+	mx   sync.Mutex
+}
+
+// Open opens log file for appending, creating it if needed.
+func Open(path string, maxSize int64, keep int) (*Log, error) {
+	l := &Log{Path: path, MaxSize: maxSize, Keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func rotatedPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return fmt.Sprintf("%v.%d", path, i)
+}
+
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(rotatedPath(l.Path, l.Keep)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.Keep - 1; i >= 0; i-- {
+		if err := os.Rename(rotatedPath(l.Path, i), rotatedPath(l.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// Write appends an entry to the log, rotating the file if needed.
+func (l *Log) Write(e Entry) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	line := e.String() + "\n"
+	if l.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(l.f, line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Reads complete lines from rd, calling fn for each entry. Returns
+// number of bytes consumed, which doesn't include a trailing
+// incomplete line.
+func readEntries(rd io.Reader, fn func(Entry)) (int64, error) {
+	var consumed int64
+	brd := bufio.NewReader(rd)
+	for {
+		line, err := brd.ReadString('\n')
+		if err == io.EOF {
+			return consumed, nil
+		} else if err != nil {
+			return consumed, err
+		}
+		consumed += int64(len(line))
+		if e, err := parseEntry(line[:len(line)-1]); err == nil {
+			fn(e)
+		}
+	}
+}
+
+// Read returns all entries of log at path, including rotated files,
+// oldest first. It also returns size of the current log file that
+// has been read, to pass on to Follow.
+func Read(path string) ([]Entry, int64, error) {
+	var entries []Entry
+	collect := func(e Entry) { entries = append(entries, e) }
+
+	rotated := 0
+	for {
+		if _, err := os.Stat(rotatedPath(path, rotated+1)); err != nil {
+			break
+		}
+		rotated++
+	}
+
+	var offset int64
+	for i := rotated; i >= 0; i-- {
+		f, err := os.Open(rotatedPath(path, i))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+		n, err := readEntries(f, collect)
+		f.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		if i == 0 {
+			offset = n
+		}
+	}
+	return entries, offset, nil
+}
+
+// Follow calls fn for each entry written to the log at path, starting
+// at offset, until stop is closed. Log rotation is detected and
+// handled.
+func Follow(path string, offset int64, stop <-chan struct{}, fn func(Entry)) error {
+	var f *os.File
+	name := path
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for {
+		if f == nil {
+			if f_, err := os.Open(name); os.IsNotExist(err) {
+				// Not created yet, or in the middle of rotation
+			} else if err != nil {
+				return err
+			} else if _, err := f_.Seek(offset, os.SEEK_SET); err != nil {
+				f_.Close()
+				return err
+			} else {
+				f = f_
+			}
+		}
+
+		if f != nil {
+			n, err := readEntries(f, fn)
+			if err != nil {
+				return err
+			}
+			offset += n
+			// Leave any incomplete line for the next read
+			if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+				return err
+			}
+
+			// If file has been rotated away, read what has been written
+			// to it before rotation, and start reading the next one from
+			// the beginning.
+			if cur, err := f.Stat(); err != nil {
+				return err
+			} else if fi, err := os.Stat(path); err == nil && !os.SameFile(cur, fi) {
+				if _, err := readEntries(f, fn); err != nil {
+					return err
+				}
+				f.Close()
+				f = nil
+				name = nextFile(path, cur)
+				offset = 0
+				continue
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// Returns name of the file that has been written after the rotated
+// file cur. If the log has been rotated more than once since cur was
+// opened, this is one of the rotated files rather than the current
+// one.
+func nextFile(path string, cur os.FileInfo) string {
+	for i := 1; ; i++ {
+		fi, err := os.Stat(rotatedPath(path, i))
+		if err != nil {
+			return path
+		}
+		if os.SameFile(cur, fi) {
+			return rotatedPath(path, i-1)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package applog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempLogPath() string {
+	dir, err := ioutil.TempDir("", "applog.test.")
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(dir, "out.log")
+}
+
+func testEntry(i int) Entry {
+	return Entry{
+		Timestamp: time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Second),
+		Text:      fmt.Sprintf("line %03d", i),
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	path := tempLogPath()
+	defer os.RemoveAll(filepath.Dir(path))
+
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := l.Write(testEntry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	if err := l.Write(testEntry(10)); err != ErrClosed {
+		t.Error("Expected ErrClosed, got", err)
+	}
+
+	entries, offset, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("Expected 10 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if expected := testEntry(i); !e.Timestamp.Equal(expected.Timestamp) || e.Text != expected.Text {
+			t.Errorf("Expected %v, got %v", expected, e)
+		}
+	}
+	if fi, _ := os.Stat(path); fi.Size() != offset {
+		t.Errorf("Expected offset %d, got %d", fi.Size(), offset)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := tempLogPath()
+	defer os.RemoveAll(filepath.Dir(path))
+
+	lineLen := int64(len(testEntry(0).String()) + 1)
+	l, err := Open(path, 10*lineLen, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 45; i++ {
+		if err := l.Write(testEntry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	for i, expected := range []bool{true, true, true, false} {
+		if _, err := os.Stat(rotatedPath(path, i)); (err == nil) != expected {
+			t.Errorf("Expected existence of %v to be %v, got %v", rotatedPath(path, i), expected, err)
+		}
+	}
+
+	entries, _, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 25 {
+		t.Fatalf("Expected 25 entries, got %d", len(entries))
+	}
+	if entries[0].Text != "line 020" || entries[24].Text != "line 044" {
+		t.Errorf("Unexpected entries: %v ... %v", entries[0], entries[24])
+	}
+}
+
+func TestFollow(t *testing.T) {
+	path := tempLogPath()
+	defer os.RemoveAll(filepath.Dir(path))
+
+	lineLen := int64(len(testEntry(0).String()) + 1)
+	l, err := Open(path, 3*lineLen, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(testEntry(0))
+
+	_, offset, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var followed []Entry
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := Follow(path, offset, stop, func(e Entry) { followed = append(followed, e) }); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for i := 1; i < 10; i++ {
+		l.Write(testEntry(i))
+		time.Sleep(100 * time.Millisecond)
+	}
+	l.Close()
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if len(followed) != 9 {
+		t.Fatalf("Expected 9 entries, got %d: %v", len(followed), followed)
+	}
+	for i, e := range followed {
+		if expected := testEntry(i + 1); e.Text != expected.Text {
+			t.Errorf("Expected %v, got %v", expected, e)
+		}
+	}
+}
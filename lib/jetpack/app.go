@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/juju/errors"
@@ -15,13 +18,26 @@ import (
 	"github.com/3ofcoins/jetpack/lib/run"
 )
 
+// PATH of apps that don't set their own
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 type App struct {
-	Name   types.ACName
-	Pod    *Pod
-	app    *types.App
+	Name types.ACName
+	Pod  *Pod
+	app  *types.App
+
+	// Guards cmd and killed
+	mx     sync.Mutex
 	cmd    *run.Cmd
 	killed bool
 
+	// Don't attach commands to a pseudo-terminal, even in an
+	// interactive session
+	NoTTY bool
+
+	// If set, output of stage2 commands is copied here as well
+	logOut, logErr io.Writer
+
 	// cache
 	_env []string
 }
@@ -36,53 +52,121 @@ func (app *App) env() []string {
 	if app._env == nil {
 		env := make([]string, len(app.app.Environment))
 		hasPath := false
-		hasTerm := false
 		for i, ev := range app.app.Environment {
 			env[i] = ev.Name + "=" + ev.Value
 			if ev.Name == "PATH" {
 				hasPath = true
 			}
-			if ev.Name == "TERM" {
-				hasTerm = true
-			}
 		}
 		if !hasPath {
-			env = append(env, "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+			env = append(env, "PATH="+defaultPath)
 		}
+		app._env = env
+	}
+	return app._env
+}
 
-		if !hasTerm {
-			// TODO: TERM= only if we're attached to a terminal
-			term := os.Getenv("TERM")
-			if term == "" {
-				term = "vt100"
-			}
-			env = append(env, "TERM="+term)
+// Runs the app, restarting it according to its restart policy.
+func (app *App) Run(stdin io.Reader, stdout, stderr io.Writer) error {
+	app.mx.Lock()
+	app.killed = false
+	app.mx.Unlock()
+	return app.run(stdin, stdout, stderr)
+}
+
+// Runs the app like Run, but keeps a kill that has happened before
+func (app *App) run(stdin io.Reader, stdout, stderr io.Writer) error {
+	policy, err := app.RestartPolicy()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+		st.Restarts = 0
+		st.Supervisor = os.Getpid()
+		st.StopRequested = false
+		st.StopSignal = ""
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	defer app.Pod.updateAppState(app.Name, func(st *AppState) {
+		st.Supervisor = 0
+	})
+
+	for restarts := 0; ; restarts++ {
+		err := app.runOnce(stdin, stdout, stderr)
+		if st, serr := app.State(); err != nil && serr == nil && st.Stopped() {
+			// Exit on the stop signal is a clean stop
+			return nil
+		}
+		if app.isKilled() || app.stopRequested() || !policy.shouldRestart(err, restarts) {
+			return err
 		}
 
-		app._env = env
+		delay := policy.delay(restarts)
+		app.Pod.ui.Printf("%v: exited (%v), restarting in %v", app.Name, err, delay)
+		if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+			st.Restarts++
+		}); err != nil {
+			return errors.Trace(err)
+		}
+
+		for deadline := time.Now().Add(delay); time.Now().Before(deadline); {
+			if app.isKilled() || app.stopRequested() {
+				return err
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
 	}
-	return app._env
 }
 
-func (app *App) Run(stdin io.Reader, stdout, stderr io.Writer) (re error) {
+// Returns true if Pod.Stop has asked for the app to be stopped
+func (app *App) stopRequested() bool {
+	st, err := app.State()
+	return err == nil && st.StopRequested
+}
+
+func (app *App) runOnce(stdin io.Reader, stdout, stderr io.Writer) (re error) {
 	if _, err := app.Pod.Host.CheckMDS(); err != nil {
 		return errors.Trace(err)
 	}
 
+	if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+		st.Status = AppStatusRunning
+		st.Started = time.Now()
+		st.Finished = time.Time{}
+		st.Health = nil
+	}); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Error of the main command (or of the failed pre-start handler),
+	// recorded as app's final state
+	var exitErr error
+	killed := false
+	defer func() {
+		if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+			st.finish(exitErr, killed)
+		}); err != nil && re == nil {
+			re = errors.Trace(err)
+		}
+	}()
+
 	for _, eh := range app.app.EventHandlers {
 		switch eh.Name {
 		case "pre-start":
 			// TODO: log
 			if err := app.Stage2(stdin, stdout, stderr, "0", "0", "", eh.Exec...); err != nil {
+				exitErr, killed = err, app.isKilled()
 				return errors.Trace(err)
 			}
-			if app.killed {
+			if app.isKilled() {
 				return errors.New("CAN'T HAPPEN: app killed, and Stage2 succeeded")
 			}
 		case "post-stop":
 			defer func(exec []string) {
 				// TODO: log
-				if !app.killed {
+				if !app.isKilled() {
 					if err := app.Stage2(stdin, stdout, stderr, "0", "0", "", exec...); err != nil {
 						if re != nil {
 							re = errors.Trace(err)
@@ -95,7 +179,15 @@ func (app *App) Run(stdin io.Reader, stdout, stderr io.Writer) (re error) {
 		}
 	}
 
-	return errors.Trace(app.Stage2(stdin, stdout, stderr, "", "", "", app.app.Exec...))
+	exitErr = app.stage2(func(pid int) {
+		if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+			st.Pid = pid
+		}); err != nil {
+			app.Pod.ui.Printf("%v: cannot save pid: %v", app.Name, err)
+		}
+	}, stdin, stdout, stderr, "", "", "", nil, app.app.Exec...)
+	killed = app.isKilled()
+	return errors.Trace(exitErr)
 }
 
 func (app *App) Console(username string) error {
@@ -105,12 +197,30 @@ func (app *App) Console(username string) error {
 	return errors.Trace(app.Stage2(os.Stdin, os.Stdout, os.Stderr, "0", "0", "", "/usr/bin/login", "-p", "-f", username))
 }
 
+// State returns app's persisted lifecycle state.
+func (app *App) State() (*AppState, error) {
+	return app.Pod.AppState(app.Name)
+}
+
 // IsRunning returns true if the app currently executes a stage2 command.
 func (app *App) IsRunning() bool {
+	app.mx.Lock()
+	defer app.mx.Unlock()
 	return app.cmd != nil
 }
 
+func (app *App) isKilled() bool {
+	app.mx.Lock()
+	defer app.mx.Unlock()
+	return app.killed
+}
+
 func (app *App) Kill() error {
+	app.mx.Lock()
+	defer app.mx.Unlock()
+	// Also stops the app from being restarted, and its commands that
+	// haven't started yet from starting
+	app.killed = true
 	if app.cmd != nil && app.cmd.Cmd.Process != nil {
 		return app.cmd.Cmd.Process.Kill()
 	}
@@ -118,16 +228,111 @@ func (app *App) Kill() error {
 	return nil
 }
 
-func (app *App) Stage2(stdin io.Reader, stdout, stderr io.Writer, user, group string, cwd string, exec ...string) error {
-	if app.IsRunning() {
-		// One Jetpack process won't need to run multiple commands in the
-		// same app at the same time. It's either sequential
+// Kills app's running stage2 command without marking app as killed,
+// so that its restart policy applies.
+func (app *App) killCmd() error {
+	app.mx.Lock()
+	defer app.mx.Unlock()
+	return app.cmd.Kill()
+}
+
+// Starts cmd as app's stage2 command, unless app has been killed
+func (app *App) startCmd(cmd *run.Cmd) error {
+	app.mx.Lock()
+	defer app.mx.Unlock()
+	if app.killed {
+		return errors.New("Killed")
+	}
+	if app.cmd != nil {
+		// One Jetpack process won't need to run multiple commands in
+		// the same app at the same time. It's either sequential
 		// hook-exec-hook, or an individual command, but not both in the
 		// same binary. This assumption may change in the future.
-		// FIXME: race condition between this place and setting app.cmd
 		return errors.New("A stage2 command is already running for this app")
 	}
-	app.killed = false
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	app.cmd = cmd
+	return nil
+}
+
+func (app *App) Stage2(stdin io.Reader, stdout, stderr io.Writer, user, group string, cwd string, exec ...string) error {
+	return app.stage2(nil, stdin, stdout, stderr, user, group, cwd, nil, exec...)
+}
+
+// ExecOptions customize a command run with App.Exec
+type ExecOptions struct {
+	// User, group, and working directory; app's own by default
+	User, Group, Cwd string
+	// Environment variables (NAME=VALUE) set in addition to app's
+	// environment, overriding its variables of the same name
+	Env []string
+	// Don't pass app's environment, only the standard variables, PATH,
+	// and Env
+	CleanEnv bool
+	// If the jail is created for the command, remove it afterwards,
+	// unless any of the apps has been started in the meantime
+	Stop bool
+}
+
+// Returns environment of a command run with opts
+func (app *App) execEnv(opts *ExecOptions) []string {
+	env := app.env()
+	if opts.CleanEnv {
+		env = []string{"PATH=" + defaultPath}
+	}
+	return mergeEnv(env, opts.Env)
+}
+
+// Returns env with variables in extra added, or replacing the ones of
+// the same name
+func mergeEnv(env, extra []string) []string {
+	merged := append([]string(nil), env...)
+extra:
+	for _, ev := range extra {
+		name := ev[:strings.Index(ev+"=", "=")+1]
+		for i, mev := range merged {
+			if strings.HasPrefix(mev, name) {
+				merged[i] = ev
+				continue extra
+			}
+		}
+		merged = append(merged, ev)
+	}
+	return merged
+}
+
+// Exec runs a command in the app, customized with opts. The jail is
+// started if it isn't running yet.
+func (app *App) Exec(opts *ExecOptions, stdin io.Reader, stdout, stderr io.Writer, exec ...string) error {
+	wasRunning := app.Pod.Jid() != 0
+	err := app.stage2(nil, stdin, stdout, stderr, opts.User, opts.Group, opts.Cwd, app.execEnv(opts), exec...)
+	if opts.Stop && !wasRunning {
+		if states, serr := app.Pod.AppStates(); serr != nil {
+			app.Pod.ui.Printf("WARNING: %v", serr)
+		} else {
+			for _, st := range states {
+				if st.Status == AppStatusRunning {
+					app.Pod.ui.Println("Apps have been started, leaving the jail running")
+					return err
+				}
+			}
+			if kerr := app.Pod.Kill(); kerr != nil && err == nil {
+				err = errors.Trace(kerr)
+			}
+		}
+	}
+	return err
+}
+
+// Runs stage2 command; if started is not nil, it is called with the
+// command's pid after it has been started. If env is nil, app's
+// environment is used.
+func (app *App) stage2(started func(pid int), stdin io.Reader, stdout, stderr io.Writer, user, group string, cwd string, env []string, exec ...string) error {
+	if app.IsRunning() {
+		return errors.New("A stage2 command is already running for this app")
+	}
 
 	if strings.HasPrefix(user, "/") || strings.HasPrefix(group, "/") {
 		return errors.New("Path-based user/group not supported yet, sorry")
@@ -150,7 +355,10 @@ func (app *App) Stage2(stdin io.Reader, stdout, stderr io.Writer, user, group st
 	}
 
 	// Ensure jail is created
-	jid := app.Pod.ensureJid()
+	jid, err := app.Pod.ensureJid()
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	mds, err := app.Pod.MetadataURL()
 	if err != nil {
@@ -201,14 +409,88 @@ func (app *App) Stage2(stdin io.Reader, stdout, stderr io.Writer, user, group st
 		"HOME=" + pwent.Home,
 		"SHELL=" + pwent.Shell,
 	}
-	// TODO: move TERM= here if stdin (or stdout?) is a terminal
-	args = append(args, app.env()...)
+	if env == nil {
+		env = app.env()
+	}
+
+	var tty *pty
+	if local := interactiveTerminal(stdin, stdout); local != nil && !app.NoTTY {
+		if tty, err = newPTY(local); err != nil {
+			return errors.Trace(err)
+		}
+		defer tty.Close()
+		env = termEnv(env)
+	}
+	if app.logOut != nil {
+		stdout = teeWriter(stdout, app.logOut)
+		stderr = teeWriter(stderr, app.logErr)
+	}
+
+	args = append(args, env...)
 	args = append(args, exec...)
-	app.cmd = run.Command(stage2, args...)
-	app.cmd.Cmd.Stdin = stdin
-	app.cmd.Cmd.Stdout = stdout
-	app.cmd.Cmd.Stderr = stderr
-	defer func() { app.cmd = nil }()
+	cmd := run.Command(stage2, args...)
+	if tty != nil {
+		cmd.Cmd.Stdin = tty.slave
+		cmd.Cmd.Stdout = tty.slave
+		cmd.Cmd.Stderr = tty.slave
+		// New session with the pseudo-terminal (stdin) as its
+		// controlling terminal
+		cmd.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	} else {
+		cmd.Cmd.Stdin = stdin
+		cmd.Cmd.Stdout = stdout
+		cmd.Cmd.Stderr = stderr
+	}
+
+	if err := app.startCmd(cmd); err != nil {
+		return err
+	}
+	defer func() {
+		app.mx.Lock()
+		app.cmd = nil
+		app.mx.Unlock()
+	}()
+	if started != nil {
+		started(cmd.Cmd.Process.Pid)
+	}
+	if tty == nil {
+		return cmd.Wait()
+	}
+
+	tty.slave.Close()
+	copied := make(chan struct{})
+	go func() {
+		tty.copy(stdin, stdout)
+		close(copied)
+	}()
+	err = cmd.Wait()
+	// Command's children may still hold the pseudo-terminal open; don't
+	// wait for them longer than it takes to flush the output.
+	select {
+	case <-copied:
+	case <-time.After(time.Second):
+	}
+	return err
+}
 
-	return app.cmd.Run()
+// Returns writer that writes to w, if it's not nil, and to tee
+func teeWriter(w, tee io.Writer) io.Writer {
+	if w == nil {
+		return tee
+	}
+	return io.MultiWriter(w, tee)
+}
+
+// Returns env with TERM set, for a command attached to a terminal
+func termEnv(env []string) []string {
+	for _, ev := range env {
+		if strings.HasPrefix(ev, "TERM=") {
+			return env
+		}
+	}
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "vt100"
+	}
+	return append(append([]string(nil), env...), "TERM="+term)
 }
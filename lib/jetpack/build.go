@@ -19,6 +19,7 @@ import (
 
 	"github.com/3ofcoins/jetpack/lib/run"
 	"github.com/3ofcoins/jetpack/lib/ui"
+	"github.com/3ofcoins/jetpack/lib/zfs"
 )
 
 //  Write ACI to `, return its hash. If packlist file is nil, writes
@@ -232,7 +233,7 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 		return nil, errors.Trace(err)
 	}
 
-	if err := os.Remove(ds.Path("etc/resolv.conf")); err != nil && !os.IsNotExist(err) {
+	if err := buildPod.restoreEtcFiles(buildPod.Manifest.Apps[0].Name, ds.Mountpoint); err != nil {
 		return nil, errors.Trace(err)
 	}
 
@@ -255,6 +256,27 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 	}
 	buildPod = nil
 
+	// Get changes out of `zfs diff`
+	ui.Debug("Generating incremental packing list")
+	diffs, err := zfs.ZfsFields("diff", ds.SnapshotName("parent"), ds.Name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := img.finishChildImage(childImage, manifestBytes, diffs, ds.Mountpoint, ui); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return childImage, nil
+}
+
+// Completes childImage, derived from img, whose rootfs dataset is
+// already in place: parses and fills in its manifest, and writes an
+// incremental ACI containing changes listed by `zfs diff` output
+// diffs, with paths prefixed by diffPrefix.
+func (img *Image) finishChildImage(childImage *Image, manifestBytes []byte, diffs [][]string, diffPrefix string, ui *ui.UI) error {
+	rootfs := childImage.Path("rootfs")
+
 	// Construct the child image's manifest
 
 	ui.Debug("Constructing new image manifest")
@@ -262,7 +284,7 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 	if err := json.Unmarshal(manifestBytes, &childImage.Manifest); err != nil {
 		savePath := childImage.Path("manifest.err")
 		ioutil.WriteFile(savePath, manifestBytes, 0400)
-		return nil, errors.Annotatef(err, "Parsing new image manifest; tried to save at %v", savePath)
+		return errors.Annotatef(err, "Parsing new image manifest; tried to save at %v", savePath)
 	}
 
 	if _, ok := childImage.Manifest.Annotations.Get("timestamp"); !ok {
@@ -299,11 +321,9 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 
 	// Get packing list out of `zfs diff`
 
-	ui.Debug("Generating incremental packing list")
-
 	packlist, err := ioutil.TempFile(childImage.Path(), "aci.packlist.")
 	if err != nil {
-		return nil, errors.Trace(err)
+		return errors.Trace(err)
 	}
 	os.Remove(packlist.Name())
 	defer packlist.Close()
@@ -315,31 +335,35 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 	// a deletion. False overwrites true, true never overwrites false.
 	deletionMap := make(map[string]bool)
 
-	if snap, err := ds.GetSnapshot("parent"); err != nil {
-		return nil, errors.Trace(err)
-	} else if diffs, err := snap.ZfsFields("diff"); err != nil {
-		return nil, errors.Trace(err)
-	} else {
-		for _, diff := range diffs {
-			path1 := diff[1][len(ds.Mountpoint):]
-			switch diff[0] {
-			case "+", "M":
-				io.WriteString(packlist, filepath.Join("\000rootfs", path1))
-				deletionMap[path1] = false
-			case "R":
-				path2 := diff[2][len(ds.Mountpoint):]
-				deletionMap[path2] = false
-				io.WriteString(packlist, filepath.Join("\000rootfs", path2))
-				fallthrough
-			case "-":
-				if _, ok := deletionMap[path1]; !ok {
-					// if found in map, either already true (no need to set
-					// again), or false (which should stay)
-					deletionMap[path1] = true
-				}
-			default:
-				return nil, errors.Errorf("Unknown `zfs diff` line: %v", diff)
+	// Paths that were added or modified, but are not in child's rootfs
+	// (e.g. removed after the diff has been taken), are deletions.
+	addition := func(path string) {
+		if _, err := os.Lstat(filepath.Join(rootfs, path)); err != nil {
+			if _, ok := deletionMap[path]; !ok {
+				deletionMap[path] = true
 			}
+			return
+		}
+		io.WriteString(packlist, filepath.Join("\000rootfs", path))
+		deletionMap[path] = false
+	}
+
+	for _, diff := range diffs {
+		path1 := diff[1][len(diffPrefix):]
+		switch diff[0] {
+		case "+", "M":
+			addition(path1)
+		case "R":
+			addition(diff[2][len(diffPrefix):])
+			fallthrough
+		case "-":
+			if _, ok := deletionMap[path1]; !ok {
+				// if found in map, either already true (no need to set
+				// again), or false (which should stay)
+				deletionMap[path1] = true
+			}
+		default:
+			return errors.Errorf("Unknown `zfs diff` line: %v", diff)
 		}
 	}
 	packlist.Seek(0, os.SEEK_SET)
@@ -357,42 +381,38 @@ func (img *Image) Build(buildDir string, addFiles []string, buildExec []string)
 	// If any files from parent were deleted, fill in path whitelist
 	if haveDeletions {
 		ui.Debug("Some files were deleted, filling in path whitelist")
-		prefixLen := len(ds.Mountpoint)
-		if err := filepath.Walk(ds.Mountpoint, func(path string, _ os.FileInfo, err error) error {
+		prefixLen := len(rootfs)
+		if err := filepath.Walk(rootfs, func(path string, _ os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
 			if len(path) == prefixLen {
-				// All paths are prefixed with ds.Mountpoint. Cheaper to compare lengths than whole string.
+				// All paths are prefixed with rootfs. Cheaper to compare lengths than whole string.
 				return nil
 			}
 			childImage.Manifest.PathWhitelist = append(childImage.Manifest.PathWhitelist, path[prefixLen:])
 			return nil
 		}); err != nil {
-			return nil, errors.Trace(err)
+			return errors.Trace(err)
 		}
 		sort.Strings(childImage.Manifest.PathWhitelist)
 	}
 
 	if err := childImage.saveManifest(); err != nil {
-		return nil, errors.Trace(err)
+		return errors.Trace(err)
 	}
 
 	// Save the ACI
 	if f, err := os.OpenFile(childImage.Path("aci"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0440); err != nil {
-		return nil, errors.Trace(err)
+		return errors.Trace(err)
 	} else {
 		defer f.Close()
 		if hash, err := childImage.writeACI(f, packlist); err != nil {
-			return nil, errors.Trace(err)
+			return errors.Trace(err)
 		} else {
 			childImage.Hash = hash
 		}
 	}
 
-	if err := childImage.sealImage(); err != nil {
-		return nil, errors.Trace(err)
-	}
-
-	return childImage, nil
+	return errors.Trace(childImage.sealImage())
 }
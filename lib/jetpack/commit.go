@@ -0,0 +1,185 @@
+package jetpack
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/ui"
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+// Prefix of snapshots that committed images are cloned from
+const commitSnapshotPrefix = "commit-"
+
+// commitDatasets snapshots and clones app's rootfs for a committed
+// image
+type commitDatasets interface {
+	// Snapshots app's rootfs dataset
+	snapshot(rootds *zfs.Dataset, name string) (*zfs.Dataset, error)
+	// Returns changes between rootfs' "parent" snapshot and snap
+	diff(rootds, snap *zfs.Dataset) ([][]string, error)
+	// Clones snap as a new dataset, mounted at mountpoint
+	clone(snap *zfs.Dataset, name, mountpoint string) (*zfs.Dataset, error)
+	// Destroys dataset or snapshot
+	destroy(ds *zfs.Dataset) error
+}
+
+type zfsCommitDatasets struct{}
+
+func (zfsCommitDatasets) snapshot(rootds *zfs.Dataset, name string) (*zfs.Dataset, error) {
+	return rootds.Snapshot(name)
+}
+
+func (zfsCommitDatasets) diff(rootds, snap *zfs.Dataset) ([][]string, error) {
+	return zfs.ZfsFields("diff", rootds.SnapshotName("parent"), snap.Name)
+}
+
+func (zfsCommitDatasets) clone(snap *zfs.Dataset, name, mountpoint string) (*zfs.Dataset, error) {
+	return snap.Clone(name, "-o", "mountpoint="+mountpoint)
+}
+
+func (zfsCommitDatasets) destroy(ds *zfs.Dataset) error {
+	return ds.Destroy()
+}
+
+// Commit creates a new image out of current state of app's rootfs,
+// with given manifest. The image is incremental, and depends on the
+// image that the app has been created from. The pod is left intact:
+// its rootfs is snapshotted, and the new image's rootfs is a clone of
+// the snapshot.
+func (pod *Pod) Commit(appName types.ACName, manifestBytes []byte) (*Image, error) {
+	appIdx := -1
+	for i, rtapp := range pod.Manifest.Apps {
+		if rtapp.Name == appName {
+			appIdx = i
+			break
+		}
+	}
+	if appIdx < 0 {
+		return nil, ErrNotFound
+	}
+
+	parent, err := pod.Host.getRuntimeImage(pod.Manifest.Apps[appIdx].Image)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ds := pod.getDataset()
+	if ds == nil {
+		return nil, errors.Errorf("No dataset for pod %v", pod.UUID)
+	}
+	rootds, err := ds.GetDataset(fmt.Sprintf("rootfs.%d", appIdx))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return pod.commit(appName, parent, rootds, manifestBytes, zfsCommitDatasets{})
+}
+
+// Commits app's rootfs dataset as a child image of parent. If any
+// step fails, the snapshot, the clone, and the image's directory are
+// removed.
+func (pod *Pod) commit(appName types.ACName, parent *Image, rootds *zfs.Dataset, manifestBytes []byte, cds commitDatasets) (_ *Image, rErr error) {
+	childImage := NewImage(pod.Host, nil)
+	ui := ui.NewUI("cyan", "commit", childImage.UUID.String())
+	ui.Printf("Committing %v:%v", pod.UUID, appName)
+
+	var undo []func()
+	defer func() {
+		if rErr != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+		}
+	}()
+
+	snap, err := cds.snapshot(rootds, commitSnapshotPrefix+childImage.UUID.String())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	undo = append(undo, func() {
+		if err := cds.destroy(snap); err != nil {
+			ui.Printf("WARNING: cannot destroy %v: %v", snap.Name, err)
+		}
+	})
+
+	ui.Debug("Generating incremental packing list")
+	diffs, err := cds.diff(rootds, snap)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := os.MkdirAll(childImage.Path(), 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	undo = append(undo, func() { os.RemoveAll(childImage.Path()) })
+
+	clone, err := cds.clone(snap, pod.Host.Dataset.ChildName(path.Join("images", childImage.UUID.String())), childImage.Path("rootfs"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	undo = append(undo, func() {
+		if err := cds.destroy(clone); err != nil {
+			ui.Printf("WARNING: cannot destroy %v: %v", clone.Name, err)
+		}
+	})
+
+	if err := pod.restoreEtcFiles(appName, childImage.Path("rootfs")); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := parent.finishChildImage(childImage, manifestBytes, diffs, rootds.Mountpoint, ui); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return childImage, nil
+}
+
+// Promotes images committed from pod's snapshots, so that pod's
+// datasets can be destroyed. Returns names of promoted datasets.
+func (pod *Pod) promoteCommittedImages(ds *zfs.Dataset) ([]string, error) {
+	lines, err := ds.ZfsFields("get", "-r", "-t", "snapshot", "-o", "name,value", "clones")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var promoted []string
+	for _, fields := range lines {
+		if len(fields) < 2 || fields[1] == "" || fields[1] == "-" {
+			continue
+		}
+		for _, clone := range strings.Split(fields[1], ",") {
+			pod.ui.Debugf("Promoting %v, cloned from %v", clone, fields[0])
+			if err := zfs.Zfs("promote", clone); err != nil {
+				return promoted, errors.Trace(err)
+			}
+			promoted = append(promoted, clone)
+		}
+	}
+	return promoted, nil
+}
+
+// Removes snapshots that promoted images have taken over from a
+// destroyed pod.
+func (pod *Pod) cleanupPromotedImages(promoted []string) {
+	for _, name := range promoted {
+		snaps, err := zfs.ZfsLines("list", "-t", "snapshot", "-d", "1", "-o", "name", name)
+		if err != nil {
+			pod.ui.Printf("WARNING: cannot list snapshots of %v: %v", name, err)
+			continue
+		}
+		for _, snap := range snaps {
+			if strings.HasSuffix(snap, "@"+imageSnapshotName) {
+				continue
+			}
+			// Snapshot may have other clones; it's fine to keep it then.
+			if err := zfs.Zfs("@destroy", snap); err != nil {
+				pod.ui.Debugf("Keeping %v: %v", snap, err)
+			}
+		}
+	}
+}
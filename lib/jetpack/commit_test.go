@@ -0,0 +1,72 @@
+package jetpack
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+// Fake commit datasets log what they do
+type fakeCommitDatasets struct {
+	log []string
+}
+
+func (f *fakeCommitDatasets) snapshot(rootds *zfs.Dataset, name string) (*zfs.Dataset, error) {
+	f.log = append(f.log, "snapshot")
+	return &zfs.Dataset{Name: rootds.SnapshotName(name), Type: "snapshot"}, nil
+}
+
+func (f *fakeCommitDatasets) diff(rootds, snap *zfs.Dataset) ([][]string, error) {
+	f.log = append(f.log, "diff")
+	return nil, nil
+}
+
+func (f *fakeCommitDatasets) clone(snap *zfs.Dataset, name, mountpoint string) (*zfs.Dataset, error) {
+	f.log = append(f.log, "clone")
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return nil, err
+	}
+	return &zfs.Dataset{Name: name, Mountpoint: mountpoint}, nil
+}
+
+func (f *fakeCommitDatasets) destroy(ds *zfs.Dataset) error {
+	if ds.Type == "snapshot" {
+		f.log = append(f.log, "destroy snapshot")
+	} else {
+		f.log = append(f.log, "destroy clone")
+	}
+	return nil
+}
+
+func TestCommitCleansUpOnFailure(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "jetpack-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := &Host{Dataset: &zfs.Dataset{Name: "zroot/jetpack", Mountpoint: tmp}}
+	pod := &Pod{UUID: uuid.NewRandom(), Host: h}
+	rootds := &zfs.Dataset{Name: "zroot/jetpack/pods/x/rootfs.0", Mountpoint: tmp}
+	cds := &fakeCommitDatasets{}
+
+	// Invalid manifest fails the last step
+	if _, err := pod.commit(*types.MustACName("app"), NewImage(h, nil), rootds, []byte("not json"), cds); err == nil {
+		t.Fatal("Expected error for invalid manifest")
+	}
+	expected := []string{"snapshot", "diff", "clone", "destroy clone", "destroy snapshot"}
+	if !reflect.DeepEqual(cds.log, expected) {
+		t.Errorf("Expected %v, got %v", expected, cds.log)
+	}
+	if images, err := ioutil.ReadDir(h.Path("images")); err != nil {
+		t.Error(err)
+	} else if len(images) > 0 {
+		t.Errorf("Image directory left behind: %v", images[0].Name())
+	}
+}
@@ -24,18 +24,29 @@ allow.autodiscovery = on
 allow.http = off
 allow.no-signature = off
 debug = off
+hosts.all-pods = off
 images.aci.compression=xz
 images.zfs.atime=off
 images.zfs.compress=lz4
 jail.interface = lo1
+jail.ip-family = ipv4
 jail.namePrefix = jetpack/
+jail.network = alias
+logs.keep = 5
+logs.max-size = 10M
+mds.dns = off
+mds.dns.domain = jetpack
+mds.dns.port = 53
 mds.port = 1104
 mds.user = _jetpack
 path.libexec = ${path.prefix}/libexec/jetpack
 path.share = ${path.prefix}/share/jetpack
 path.prefix = %v
+pf.anchor = jetpack
 root.zfs = zroot/jetpack
 root.zfs.mountpoint = /var/jetpack
+stop.signal = TERM
+stop.timeout = 10
 `,
 	prefix))
 
@@ -0,0 +1,147 @@
+package jetpack
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/pborman/uuid"
+)
+
+// Minimal DNS responder for the metadata service, answering A and
+// AAAA queries for pods' names in the mds.dns.domain domain. Queries
+// outside of the domain are refused, so that resolvers ask the next
+// nameserver.
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+
+	dnsRcodeOK       = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+
+	dnsTTL = 5
+)
+
+// Parses single question of a query. Returns lowercased name without
+// the trailing dot, type, class, and offset of question's end.
+func parseDNSQuestion(msg []byte) (string, uint16, uint16, int, bool) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return "", 0, 0, 0, false
+	}
+	var labels []string
+	off := 12
+	for {
+		if off >= len(msg) {
+			return "", 0, 0, 0, false
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l > 63 || off+l > len(msg) {
+			// Compression pointers are not expected in questions
+			return "", 0, 0, 0, false
+		}
+		labels = append(labels, strings.ToLower(string(msg[off:off+l])))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return "", 0, 0, 0, false
+	}
+	return strings.Join(labels, "."), binary.BigEndian.Uint16(msg[off:]), binary.BigEndian.Uint16(msg[off+2:]), off + 4, true
+}
+
+// DNSResponse returns response to a DNS query. Names in domain are
+// resolved with lookup, which returns pod's addresses and whether the
+// pod exists. Returns nil if query can't be answered at all.
+func DNSResponse(query []byte, domain string, lookup func(name string) ([]net.IP, bool)) []byte {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		// Too short, or not a query
+		return nil
+	}
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])                 // ID
+	resp[2] = 0x80 | query[2]&0x79 | 0x04 // QR, opcode, AA, RD
+	rcode := byte(dnsRcodeOK)
+	var answers []net.IP
+
+	name, qtype, qclass, qend, ok := parseDNSQuestion(query)
+	switch {
+	case !ok:
+		rcode = dnsRcodeFormErr
+	case query[2]&0x78 != 0: // opcode other than QUERY
+		rcode = dnsRcodeNotImp
+	default:
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if qclass != dnsClassIN || !strings.HasSuffix(name, "."+domain) {
+			rcode = dnsRcodeRefused
+			break
+		}
+		ips, found := lookup(strings.TrimSuffix(name, "."+domain))
+		if !found {
+			rcode = dnsRcodeNXDomain
+			break
+		}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && (qtype == dnsTypeA || qtype == dnsTypeANY) {
+				answers = append(answers, ip4)
+			} else if ip4 == nil && (qtype == dnsTypeAAAA || qtype == dnsTypeANY) {
+				answers = append(answers, ip.To16())
+			}
+		}
+	}
+	resp[3] = rcode
+
+	if !ok {
+		return resp
+	}
+
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	resp = append(resp, query[12:qend]...)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	for _, ip := range answers {
+		rtype := uint16(dnsTypeA)
+		if len(ip) == net.IPv6len {
+			rtype = dnsTypeAAAA
+		}
+		rr := make([]byte, 12)
+		binary.BigEndian.PutUint16(rr[0:], 0xc00c) // pointer to question's name
+		binary.BigEndian.PutUint16(rr[2:], rtype)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:], dnsTTL)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(ip)))
+		resp = append(append(resp, rr...), ip...)
+	}
+	return resp
+}
+
+// LookupPod returns addresses of a pod with given UUID, or name (as
+// in its hostname annotation, case-insensitive, without the domain).
+func (h *Host) LookupPod(name string) ([]net.IP, bool) {
+	id := uuid.Parse(name)
+	for _, pod := range h.Pods() {
+		if id != nil {
+			if !uuid.Equal(id, pod.UUID) {
+				continue
+			}
+		} else if names := podHostNames(strings.ToLower(pod.Name()), ""); name != names[0] && name != names[len(names)-1] {
+			continue
+		}
+		var ips []net.IP
+		for _, s := range pod.IPAddresses() {
+			if ip := net.ParseIP(s); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, true
+	}
+	return nil, false
+}
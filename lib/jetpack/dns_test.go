@@ -0,0 +1,81 @@
+package jetpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func dnsTestQuery(name string, qtype uint16) []byte {
+	q := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		q = append(append(q, byte(len(label))), label...)
+	}
+	q = append(q, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(q[len(q)-4:], qtype)
+	binary.BigEndian.PutUint16(q[len(q)-2:], dnsClassIN)
+	return q
+}
+
+func dnsTestLookup(name string) ([]net.IP, bool) {
+	if name == "web" {
+		return []net.IP{net.ParseIP("172.23.0.2"), net.ParseIP("fd00:23::2")}, true
+	}
+	return nil, false
+}
+
+func TestDNSResponse(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		qtype   uint16
+		rcode   byte
+		answers []string
+	}{
+		{"web.jetpack", dnsTypeA, dnsRcodeOK, []string{"172.23.0.2"}},
+		{"WEB.Jetpack", dnsTypeAAAA, dnsRcodeOK, []string{"fd00:23::2"}},
+		{"web.jetpack", dnsTypeANY, dnsRcodeOK, []string{"172.23.0.2", "fd00:23::2"}},
+		{"web.jetpack", 16, dnsRcodeOK, nil}, // TXT: no data
+		{"db.jetpack", dnsTypeA, dnsRcodeNXDomain, nil},
+		{"example.com", dnsTypeA, dnsRcodeRefused, nil},
+	} {
+		query := dnsTestQuery(c.name, c.qtype)
+		resp := DNSResponse(query, "jetpack.", dnsTestLookup)
+		if len(resp) < len(query) {
+			t.Errorf("%v: response too short: %v", c.name, resp)
+			continue
+		}
+		if resp[0] != 0x12 || resp[1] != 0x34 || resp[2]&0x80 == 0 || resp[2]&0x01 == 0 {
+			t.Errorf("%v: invalid header: %v", c.name, resp[:4])
+		}
+		if rcode := resp[3] & 0x0f; rcode != c.rcode {
+			t.Errorf("%v: expected rcode %d, got %d", c.name, c.rcode, rcode)
+		}
+		if !bytes.Equal(resp[12:len(query)], query[12:]) {
+			t.Errorf("%v: question not copied", c.name)
+		}
+		if ancount := int(binary.BigEndian.Uint16(resp[6:])); ancount != len(c.answers) {
+			t.Errorf("%v: expected %d answers, got %d", c.name, len(c.answers), ancount)
+			continue
+		}
+		off := len(query)
+		for _, expected := range c.answers {
+			rdlen := int(binary.BigEndian.Uint16(resp[off+10:]))
+			if ip := net.IP(resp[off+12 : off+12+rdlen]); !ip.Equal(net.ParseIP(expected)) {
+				t.Errorf("%v: expected %v, got %v", c.name, expected, ip)
+			}
+			off += 12 + rdlen
+		}
+		if off != len(resp) {
+			t.Errorf("%v: trailing data in response", c.name)
+		}
+	}
+
+	if resp := DNSResponse([]byte{1, 2, 3}, "jetpack", dnsTestLookup); resp != nil {
+		t.Errorf("Expected no response to garbage, got %v", resp)
+	}
+	if resp := DNSResponse(dnsTestQuery("web.jetpack", dnsTypeA)[:15], "jetpack", dnsTestLookup); resp == nil || resp[3]&0x0f != dnsRcodeFormErr {
+		t.Errorf("Expected FORMERR for truncated query, got %v", resp)
+	}
+}
@@ -0,0 +1,380 @@
+package jetpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+// Prefix of snapshots that pod exports are sent from
+const exportSnapshotPrefix = "export-"
+
+// First line of a pod export stream
+const podExportMagic = "JETPACK-POD-EXPORT 1"
+
+// Max size of a chunk in pod export stream
+const podExportChunkSize = 1 << 20
+
+// Pod export stream consists of the magic line, followed by named
+// sections. Each section starts with a line holding its name, followed
+// by data chunks. A chunk is its length as a big-endian uint32,
+// followed by that many bytes of data. Zero-length chunk ends the
+// section.
+//
+// Sections are, in order:
+//  - "pod": JSON-encoded podExportHeader
+//  - "volume.N": full ZFS stream of each "empty" volume
+//  - "rootfs.N@parent": full ZFS stream of each app's rootfs as it was created
+//  - "rootfs.N": incremental ZFS stream of each app's rootfs since creation
+
+type podExportHeader struct {
+	UUID     string
+	Manifest schema.PodManifest
+	// Name of the snapshot that streams were sent from
+	Snapshot string
+}
+
+type exportWriter struct {
+	w *bufio.Writer
+}
+
+func newExportWriter(w io.Writer) (*exportWriter, error) {
+	ew := &exportWriter{bufio.NewWriter(w)}
+	if _, err := fmt.Fprintln(ew.w, podExportMagic); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return ew, nil
+}
+
+// Starts a new section. Section's data is written to the returned
+// writer, which needs to be closed before the next section is
+// started.
+func (ew *exportWriter) Section(name string) (io.WriteCloser, error) {
+	if _, err := fmt.Fprintln(ew.w, name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &chunkWriter{ew.w}, nil
+}
+
+func (ew *exportWriter) Flush() error {
+	return errors.Trace(ew.w.Flush())
+}
+
+type chunkWriter struct {
+	w *bufio.Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > podExportChunkSize {
+			chunk = chunk[:podExportChunkSize]
+		}
+		if err := binary.Write(cw.w, binary.BigEndian, uint32(len(chunk))); err != nil {
+			return written, err
+		}
+		n, err := cw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (cw *chunkWriter) Close() error {
+	return binary.Write(cw.w, binary.BigEndian, uint32(0))
+}
+
+type exportReader struct {
+	r *bufio.Reader
+}
+
+func newExportReader(r io.Reader) (*exportReader, error) {
+	er := &exportReader{bufio.NewReader(r)}
+	if magic, err := er.readLine(); err != nil {
+		return nil, errors.Trace(err)
+	} else if magic != podExportMagic {
+		return nil, errors.New("Not a pod export stream")
+	}
+	return er, nil
+}
+
+func (er *exportReader) readLine() (string, error) {
+	line, err := er.r.ReadString('\n')
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+// Starts reading the next section, which should be named name. The
+// returned reader returns io.EOF at the end of section.
+func (er *exportReader) Section(name string) (*chunkReader, error) {
+	if actual, err := er.readLine(); err != nil {
+		return nil, errors.Trace(err)
+	} else if actual != name {
+		return nil, errors.Errorf("Expected section %#v, got %#v", name, actual)
+	}
+	return &chunkReader{r: er.r}, nil
+}
+
+type chunkReader struct {
+	r    *bufio.Reader
+	left uint32
+	eof  bool
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.eof {
+		return 0, io.EOF
+	}
+	if cr.left == 0 {
+		if err := binary.Read(cr.r, binary.BigEndian, &cr.left); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if cr.left == 0 {
+			cr.eof = true
+			return 0, io.EOF
+		}
+	}
+	if uint32(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+	n, err := cr.r.Read(p)
+	cr.left -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Skips the rest of the section
+func (cr *chunkReader) Close() error {
+	_, err := io.Copy(ioutil.Discard, cr)
+	return err
+}
+
+// Export writes pod's manifest and ZFS streams of its app rootfs and
+// volume datasets to w, to be imported with ImportPod. Pod may be
+// running: the streams are sent from a consistent, recursive
+// snapshot. Pod's snapshots, app states and logs are not exported.
+func (pod *Pod) Export(w io.Writer) error {
+	ds, err := pod.mustGetDataset()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	snapName := exportSnapshotPrefix + uuid.NewRandom().String()
+	pod.ui.Debugf("Taking snapshot %v", snapName)
+	if _, err := ds.Snapshot(snapName, "-r"); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err := zfs.Zfs("destroy", "-r", ds.SnapshotName(snapName)); err != nil {
+			pod.ui.Printf("WARNING: cannot destroy snapshot %v: %v", snapName, err)
+		}
+	}()
+
+	ew, err := newExportWriter(w)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if sw, err := ew.Section("pod"); err != nil {
+		return errors.Trace(err)
+	} else if err := json.NewEncoder(sw).Encode(podExportHeader{
+		UUID:     pod.UUID.String(),
+		Manifest: pod.Manifest,
+		Snapshot: snapName,
+	}); err != nil {
+		return errors.Trace(err)
+	} else if err := sw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+
+	for i, vol := range pod.Manifest.Volumes {
+		if vol.Kind != "empty" {
+			continue
+		}
+		pod.ui.Printf("Exporting volume %v", vol.Name)
+		volume := fmt.Sprintf("volume.%d", i)
+		if err := sendSection(ew, volume, ds, volume, snapName); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	for i, rtapp := range pod.Manifest.Apps {
+		pod.ui.Printf("Exporting app %v", rtapp.Name)
+		rootfs := fmt.Sprintf("rootfs.%d", i)
+		if err := sendSection(ew, rootfs+"@parent", ds, rootfs, "parent"); err != nil {
+			return errors.Trace(err)
+		}
+		if err := sendSection(ew, rootfs, ds, rootfs, snapName, "-i", "@parent"); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return errors.Trace(ew.Flush())
+}
+
+// Writes section with ZFS stream of child dataset's snapshot
+func sendSection(ew *exportWriter, section string, ds *zfs.Dataset, child, snapName string, args ...string) error {
+	snap, err := zfs.GetDataset(ds.ChildName(child) + "@" + snapName)
+	if err != nil {
+		return errors.Annotatef(err, "%v@%v", child, snapName)
+	}
+	sw, err := ew.Section(section)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := snap.Send(sw, args...); err != nil {
+		return errors.Annotate(err, snap.Name)
+	}
+	return errors.Trace(sw.Close())
+}
+
+// Receives pod's datasets from an export stream
+type importedPodDatasets struct {
+	er       *exportReader
+	snapshot string
+}
+
+func (ipd *importedPodDatasets) receive(ds *zfs.Dataset, section, child string) (*zfs.Dataset, error) {
+	sr, err := ipd.er.Section(section)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer sr.Close()
+	childds, err := zfs.ReceiveDataset(sr, ds.ChildName(child), false)
+	if err != nil {
+		return nil, errors.Annotate(err, section)
+	}
+	return childds, nil
+}
+
+// Removes the export snapshot, and mounts received dataset
+func (ipd *importedPodDatasets) finish(childds *zfs.Dataset, mountpoint string) error {
+	if err := zfs.Zfs("destroy", childds.SnapshotName(ipd.snapshot)); err != nil {
+		return errors.Trace(err)
+	}
+	if err := childds.Set("mountpoint", mountpoint); err != nil {
+		return errors.Trace(err)
+	}
+	if !childds.Mounted {
+		return errors.Trace(childds.Mount())
+	}
+	return nil
+}
+
+func (ipd *importedPodDatasets) createVolume(ds *zfs.Dataset, i int, mountpoint string) (*zfs.Dataset, error) {
+	name := fmt.Sprintf("volume.%d", i)
+	volds, err := ipd.receive(ds, name, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return volds, errors.Trace(ipd.finish(volds, mountpoint))
+}
+
+func (ipd *importedPodDatasets) createRootfs(ds *zfs.Dataset, i int, img *Image, mountpoint string) (*zfs.Dataset, error) {
+	name := fmt.Sprintf("rootfs.%d", i)
+	if _, err := ipd.receive(ds, name+"@parent", name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	rootds, err := ipd.receive(ds, name, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return rootds, errors.Trace(ipd.finish(rootds, mountpoint))
+}
+
+// Removes source host's addresses from an imported pod's manifest:
+// pod's addresses, requested static addresses, and addresses returned
+// by network plugins.
+func stripPodAddresses(pm *schema.PodManifest) {
+	kept := pm.Annotations[:0]
+	for _, ann := range pm.Annotations {
+		switch name := ann.Name.String(); {
+		case name == IP4AddressAnnotation, name == IP6AddressAnnotation, name == StaticIPAnnotation:
+		case strings.HasPrefix(name, "jetpack/network/"):
+		default:
+			kept = append(kept, ann)
+		}
+	}
+	pm.Annotations = kept
+}
+
+// Prepares addresses of an imported pod's manifest: source host's
+// addresses are removed, and if keepIP is true, pod's addresses are
+// requested as static ones.
+func importPodAddresses(pm *schema.PodManifest, keepIP bool) {
+	var ips []string
+	if keepIP {
+		for _, name := range []string{IP4AddressAnnotation, IP6AddressAnnotation} {
+			if ip, ok := pm.Annotations.Get(name); ok {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	stripPodAddresses(pm)
+	if len(ips) > 0 {
+		pm.Annotations.Set(StaticIPAnnotation, strings.Join(ips, ","))
+	}
+}
+
+// ImportPod creates a pod from a stream written by Pod.Export. The
+// pod gets a new UUID, unless keepUUID is true, and newly allocated
+// IP addresses, unless keepIP is true (then pod's addresses are
+// requested as static ones). Images that pod's apps use are fetched
+// if they are not present locally.
+func ImportPod(h *Host, r io.Reader, keepUUID, keepIP bool) (*Pod, error) {
+	er, err := newExportReader(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var hdr podExportHeader
+	if sr, err := er.Section("pod"); err != nil {
+		return nil, errors.Trace(err)
+	} else if err := json.NewDecoder(sr).Decode(&hdr); err != nil {
+		return nil, errors.Trace(err)
+	} else if err := sr.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var id uuid.UUID
+	if keepUUID {
+		if id = uuid.Parse(hdr.UUID); id == nil {
+			return nil, errors.Errorf("Invalid pod UUID %#v", hdr.UUID)
+		}
+		if _, err := h.GetPod(id); err == nil {
+			return nil, errors.Errorf("Pod %v already exists", id)
+		}
+	}
+
+	importPodAddresses(&hdr.Manifest, keepIP)
+
+	for _, rtapp := range hdr.Manifest.Apps {
+		if _, err := h.getRuntimeImage(rtapp.Image); err != nil {
+			return nil, errors.Annotatef(err, "Image for app %v", rtapp.Name)
+		}
+	}
+
+	pod, err := createPod(h, &hdr.Manifest, id, &importedPodDatasets{er, hdr.Snapshot})
+	return pod, errors.Trace(err)
+}
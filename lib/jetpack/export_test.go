@@ -0,0 +1,142 @@
+package jetpack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema"
+)
+
+func TestExportStream(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), podExportChunkSize/8)
+	sections := []struct {
+		name string
+		data []byte
+	}{
+		{"pod", []byte(`{"UUID":"x"}`)},
+		{"volume.0", nil},
+		{"rootfs.0@parent", big},
+		{"rootfs.0", []byte("incremental")},
+	}
+
+	buf := new(bytes.Buffer)
+	ew, err := newExportWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sec := range sections {
+		sw, err := ew.Section(sec.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sw.Write(sec.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := sw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ew.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	er, err := newExportReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sec := range sections {
+		sr, err := er.Section(sec.name)
+		if err != nil {
+			t.Fatalf("%v: %v", sec.name, err)
+		}
+		if data, err := ioutil.ReadAll(sr); err != nil {
+			t.Fatalf("%v: %v", sec.name, err)
+		} else if !bytes.Equal(data, sec.data) {
+			t.Errorf("%v: read %d bytes, expected %d", sec.name, len(data), len(sec.data))
+		}
+	}
+}
+
+func TestExportStreamSkipSection(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ew, _ := newExportWriter(buf)
+	for _, name := range []string{"a", "b"} {
+		sw, _ := ew.Section(name)
+		sw.Write([]byte(strings.Repeat(name, 100)))
+		sw.Close()
+	}
+	ew.Flush()
+
+	er, err := newExportReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := er.Section("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr.Read(make([]byte, 10))
+	if err := sr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := er.Section("a"); err == nil {
+		t.Error("Expected error for out-of-order section")
+	}
+}
+
+func TestExportStreamInvalid(t *testing.T) {
+	if _, err := newExportReader(strings.NewReader("not an export\n")); err == nil {
+		t.Error("Expected error for invalid magic")
+	}
+
+	buf := new(bytes.Buffer)
+	ew, _ := newExportWriter(buf)
+	sw, _ := ew.Section("pod")
+	sw.Write([]byte("truncated"))
+	ew.Flush()
+	er, err := newExportReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, _ := er.Section("pod")
+	if _, err := ioutil.ReadAll(sr); err == nil {
+		t.Error("Expected error for truncated stream")
+	}
+}
+
+func TestImportPodAddresses(t *testing.T) {
+	exported := func() *schema.PodManifest {
+		pm := schema.BlankPodManifest()
+		pm.Annotations.Set("hostname", "db")
+		pm.Annotations.Set(IP4AddressAnnotation, "172.23.0.2")
+		pm.Annotations.Set(IP6AddressAnnotation, "fd00:23::2")
+		pm.Annotations.Set(StaticIPAnnotation, "172.23.0.2")
+		pm.Annotations.Set(NetworkModeAnnotation, NetworkModeAlias)
+		pm.Annotations.Set("jetpack/network/test", "em1|10.0.0.5")
+		return pm
+	}
+
+	pm := exported()
+	importPodAddresses(pm, false)
+	for _, name := range []string{IP4AddressAnnotation, IP6AddressAnnotation, StaticIPAnnotation, "jetpack/network/test"} {
+		if v, ok := pm.Annotations.Get(name); ok {
+			t.Errorf("Source host's address kept: %v=%v", name, v)
+		}
+	}
+	for _, name := range []string{"hostname", NetworkModeAnnotation} {
+		if _, ok := pm.Annotations.Get(name); !ok {
+			t.Errorf("Annotation %v removed", name)
+		}
+	}
+
+	pm = exported()
+	importPodAddresses(pm, true)
+	if v, _ := pm.Annotations.Get(StaticIPAnnotation); v != "172.23.0.2,fd00:23::2" {
+		t.Errorf("Unexpected static addresses: %#v", v)
+	}
+	if _, ok := pm.Annotations.Get(IP4AddressAnnotation); ok {
+		t.Error("Source host's address kept")
+	}
+}
@@ -0,0 +1,90 @@
+package jetpack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+)
+
+// Optional returns true if app is marked as optional with the
+// jetpack/optional annotation. Failure of an optional app doesn't
+// fail the pod.
+func (app *App) Optional() (bool, error) {
+	if rtapp := app.Pod.Manifest.Apps.Get(app.Name); rtapp != nil {
+		return appOptional(rtapp)
+	}
+	return false, nil
+}
+
+func appOptional(rtapp *schema.RuntimeApp) (bool, error) {
+	if v, ok := rtapp.Annotations.Get("jetpack/optional"); ok {
+		optional, err := strconv.ParseBool(v)
+		return optional, errors.Annotate(err, "jetpack/optional")
+	}
+	return false, nil
+}
+
+// AppResult is the outcome of running an app in Pod.Run
+type AppResult struct {
+	Name       types.ACName
+	Optional   bool
+	Status     string // Final state, as in AppState.String()
+	ExitStatus int
+	Err        error
+}
+
+func (ar *AppResult) Failed() bool {
+	return ar.Err != nil
+}
+
+func (ar *AppResult) String() string {
+	name := ar.Name.String()
+	if ar.Optional {
+		name += " (optional)"
+	}
+	if ar.Status == "" {
+		return fmt.Sprintf("%v: %v", name, ar.Err)
+	}
+	return fmt.Sprintf("%v: %v", name, ar.Status)
+}
+
+// Sets result of app that has finished running with err
+func (ar *AppResult) finish(app *App, err error) {
+	ar.Err = err
+	if st, serr := app.State(); serr == nil && st.Status != AppStatusInvalid {
+		ar.Status = st.String()
+		ar.ExitStatus = st.ExitStatus()
+	}
+	if err != nil && ar.ExitStatus == 0 {
+		// App failed without an exit status, e.g. it could not be
+		// started at all.
+		ar.ExitStatus = 1
+	}
+}
+
+// PodRunError is returned by Pod.Run when a required app has
+// failed. It lists results of all pod's apps.
+type PodRunError struct {
+	Apps []AppResult
+
+	// Index of the required app that has failed first
+	first int
+}
+
+func (e *PodRunError) Error() string {
+	results := make([]string, len(e.Apps))
+	for i := range e.Apps {
+		results[i] = e.Apps[i].String()
+	}
+	return fmt.Sprintf("Pod failed (%v)", strings.Join(results, ", "))
+}
+
+// ExitStatus returns exit status of the required app that has failed
+// first.
+func (e *PodRunError) ExitStatus() int {
+	return e.Apps[e.first].ExitStatus
+}
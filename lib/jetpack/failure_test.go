@@ -0,0 +1,83 @@
+package jetpack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/appc/spec/schema"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+func TestAppOptional(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	opt := schema.RuntimeApp{Name: "opt"}
+	opt.Annotations.Set("jetpack/optional", "true")
+	bad := schema.RuntimeApp{Name: "bad"}
+	bad.Annotations.Set("jetpack/optional", "maybe")
+	pod.Manifest.Apps = append(pod.Manifest.Apps, opt, bad, schema.RuntimeApp{Name: "req"})
+
+	if optional, err := (&App{Name: "opt", Pod: pod}).Optional(); err != nil || !optional {
+		t.Errorf("Expected app to be optional, got %v, %v", optional, err)
+	}
+	if optional, err := (&App{Name: "req", Pod: pod}).Optional(); err != nil || optional {
+		t.Errorf("Expected app not to be optional, got %v, %v", optional, err)
+	}
+	if _, err := (&App{Name: "bad", Pod: pod}).Optional(); err == nil {
+		t.Error("Expected error for invalid annotation value")
+	}
+}
+
+func TestPodRunError(t *testing.T) {
+	err := &PodRunError{
+		Apps: []AppResult{
+			{Name: "db", Status: "killed", ExitStatus: 137, Err: errors.New("killed")},
+			{Name: "web", Status: "exited(3)", ExitStatus: 3, Err: errors.New("exit status 3")},
+			{Name: "cron", Optional: true, Status: "exited(0)"},
+			{Name: "setup", ExitStatus: 1, Err: errors.New("cannot start")},
+		},
+		first: 1,
+	}
+	if expected := "Pod failed (db: killed, web: exited(3), cron (optional): exited(0), setup: cannot start)"; err.Error() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, err.Error())
+	}
+	if err.ExitStatus() != 3 {
+		t.Errorf("Expected exit status of first failed app, got %d", err.ExitStatus())
+	}
+}
+
+// Fail-fast kill that lands before an app's command starts needs to
+// keep the command from starting.
+func TestKilledAppDoesntStart(t *testing.T) {
+	app := &App{Name: "web"}
+	if err := app.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := run.Command("/bin/true")
+	if err := app.startCmd(cmd); err == nil {
+		t.Error("Killed app's command started")
+	}
+	if cmd.Cmd.Process != nil || app.IsRunning() {
+		t.Error("Killed app's command is running")
+	}
+}
+
+func TestKillCmdKeepsAppRestartable(t *testing.T) {
+	app := &App{Name: "web"}
+	if err := app.killCmd(); err != nil {
+		t.Errorf("Killing app that's not running: %v", err)
+	}
+	cmd := run.Command("/bin/sleep", "10")
+	if err := app.startCmd(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.killCmd(); err != nil {
+		t.Error(err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("Command has not been killed")
+	}
+	if app.isKilled() {
+		t.Error("App has been marked as killed")
+	}
+}
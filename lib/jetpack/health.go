@@ -0,0 +1,213 @@
+package jetpack
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type HealthStatus string
+
+const (
+	HealthStarting  HealthStatus = "starting"
+	HealthHealthy   HealthStatus = "healthy"
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// Output of failed checks is truncated to this many bytes
+const maxHealthOutput = 1024
+
+// HealthState is the result of app's latest health checks, persisted
+// in app's state.
+type HealthState struct {
+	Status HealthStatus
+	// Number of consecutive failed checks
+	Failures int `json:",omitempty"`
+	Checked  time.Time
+	// Output of the last failed check
+	Output string `json:",omitempty"`
+}
+
+// HealthCheck is a command that is run periodically inside a running
+// app to check whether the app is healthy.
+type HealthCheck struct {
+	// Shell command; nonzero exit status means failure
+	Command  string
+	Interval time.Duration
+	Timeout  time.Duration
+	// App is unhealthy after this many consecutive failures
+	Retries int
+	// Kill unhealthy app, so that its restart policy can restart it
+	Restart bool
+}
+
+var DefaultHealthCheck = HealthCheck{
+	Interval: 30 * time.Second,
+	Timeout:  10 * time.Second,
+	Retries:  3,
+}
+
+// Returns value of app's annotation, set in the pod manifest or in
+// app's image manifest.
+func (app *App) annotation(name string) (string, bool) {
+	rtapp := app.Pod.Manifest.Apps.Get(app.Name)
+	if rtapp == nil {
+		return "", false
+	}
+	if v, ok := rtapp.Annotations.Get(name); ok {
+		return v, true
+	}
+	if img, err := app.Pod.Host.getRuntimeImage(rtapp.Image); err == nil {
+		return img.Manifest.Annotations.Get(name)
+	}
+	return "", false
+}
+
+// HealthCheck returns app's health check, or nil if app has none. It
+// is configured with pod or image annotations:
+//  - jetpack/healthcheck (shell command)
+//  - jetpack/healthcheck-interval (e.g. "30s")
+//  - jetpack/healthcheck-timeout (e.g. "10s")
+//  - jetpack/healthcheck-retries
+//  - jetpack/healthcheck-restart (kill the app when it becomes unhealthy)
+func (app *App) HealthCheck() (*HealthCheck, error) {
+	cmd, ok := app.annotation("jetpack/healthcheck")
+	if !ok {
+		return nil, nil
+	}
+	hc := DefaultHealthCheck
+	hc.Command = cmd
+
+	for name, dst := range map[string]*time.Duration{
+		"jetpack/healthcheck-interval": &hc.Interval,
+		"jetpack/healthcheck-timeout":  &hc.Timeout,
+	} {
+		if v, ok := app.annotation(name); ok {
+			if d, err := time.ParseDuration(v); err != nil {
+				return nil, errors.Annotate(err, name)
+			} else if d <= 0 {
+				return nil, errors.Errorf("%v: must be positive", name)
+			} else {
+				*dst = d
+			}
+		}
+	}
+
+	if v, ok := app.annotation("jetpack/healthcheck-retries"); ok {
+		if n, err := strconv.Atoi(v); err != nil {
+			return nil, errors.Annotate(err, "jetpack/healthcheck-retries")
+		} else if n < 1 {
+			return nil, errors.New("jetpack/healthcheck-retries: must be positive")
+		} else {
+			hc.Retries = n
+		}
+	}
+
+	if v, ok := app.annotation("jetpack/healthcheck-restart"); ok {
+		if b, err := strconv.ParseBool(v); err != nil {
+			return nil, errors.Annotate(err, "jetpack/healthcheck-restart")
+		} else {
+			hc.Restart = b
+		}
+	}
+
+	return &hc, nil
+}
+
+// Runs health check once, and returns its output
+func (app *App) runHealthCheck(hc *HealthCheck) (string, error) {
+	return app.runCheck(hc.Command, hc.Timeout)
+}
+
+// Runs a shell command in a running app, killing it after timeout, and
+// returns its output
+func (app *App) runCheck(command string, timeout time.Duration) (string, error) {
+	// Checker needs its own App, as app is busy running its main command
+	checker := app.Pod.App(app.Name)
+	buf := new(bytes.Buffer)
+	done := make(chan error, 1)
+	go func() {
+		done <- checker.Stage2(nil, buf, buf, "", "", "", "/bin/sh", "-c", command)
+	}()
+	select {
+	case err := <-done:
+		return buf.String(), err
+	case <-time.After(timeout):
+		checker.Kill()
+		<-done
+		return buf.String(), errors.Errorf("Timed out after %v", timeout)
+	}
+}
+
+// Returns health state after a check that returned output and err,
+// given state after previous checks. Also returns true if app has
+// just become unhealthy.
+func nextHealthState(hc *HealthCheck, prev *HealthState, output string, err error) (*HealthState, bool) {
+	health := &HealthState{Status: prev.Status, Checked: time.Now()}
+	if err == nil {
+		health.Status = HealthHealthy
+		return health, false
+	}
+
+	health.Failures = prev.Failures + 1
+	output = strings.TrimSpace(output)
+	if output == "" {
+		output = err.Error()
+	}
+	if len(output) > maxHealthOutput {
+		output = output[len(output)-maxHealthOutput:]
+	}
+	health.Output = output
+
+	if health.Failures >= hc.Retries {
+		health.Status = HealthUnhealthy
+	}
+	return health, health.Status == HealthUnhealthy && prev.Status != HealthUnhealthy
+}
+
+// Runs app's health check periodically while app is running, until
+// stop is closed. Results are recorded in app's state.
+func (app *App) monitorHealth(hc *HealthCheck, stop <-chan struct{}) {
+	var started time.Time
+	health := &HealthState{Status: HealthStarting}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(hc.Interval):
+		}
+
+		st, err := app.State()
+		if err != nil || st.Status != AppStatusRunning {
+			continue
+		}
+		if !st.Started.Equal(started) {
+			// App has been (re)started
+			started = st.Started
+			health = &HealthState{Status: HealthStarting}
+		}
+
+		output, err := app.runHealthCheck(hc)
+		next, becameUnhealthy := nextHealthState(hc, health, output, err)
+		health = next
+		if err := app.Pod.updateAppState(app.Name, func(st *AppState) {
+			if st.Status == AppStatusRunning && st.Started.Equal(started) {
+				st.Health = health
+			}
+		}); err != nil {
+			app.Pod.ui.Printf("%v: cannot save health state: %v", app.Name, err)
+		}
+
+		if becameUnhealthy {
+			app.Pod.ui.Printf("%v: unhealthy after %d failed checks: %v", app.Name, health.Failures, health.Output)
+			if hc.Restart {
+				if err := app.killCmd(); err != nil {
+					app.Pod.ui.Printf("%v: cannot kill unhealthy app: %v", app.Name, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package jetpack
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema"
+)
+
+func TestAppHealthCheck(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	rtapp := schema.RuntimeApp{Name: "web"}
+	rtapp.Annotations.Set("jetpack/healthcheck", "fetch -qo /dev/null http://localhost/")
+	rtapp.Annotations.Set("jetpack/healthcheck-interval", "5s")
+	rtapp.Annotations.Set("jetpack/healthcheck-retries", "2")
+	rtapp.Annotations.Set("jetpack/healthcheck-restart", "true")
+	bad := schema.RuntimeApp{Name: "bad"}
+	bad.Annotations.Set("jetpack/healthcheck", "true")
+	bad.Annotations.Set("jetpack/healthcheck-timeout", "-1s")
+	pod.Manifest.Apps = append(pod.Manifest.Apps, rtapp, bad)
+
+	hc, err := (&App{Name: "web", Pod: pod}).HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := HealthCheck{
+		Command:  "fetch -qo /dev/null http://localhost/",
+		Interval: 5 * time.Second,
+		Timeout:  DefaultHealthCheck.Timeout,
+		Retries:  2,
+		Restart:  true,
+	}
+	if *hc != expected {
+		t.Errorf("Expected %#v, got %#v", expected, *hc)
+	}
+
+	if _, err := (&App{Name: "bad", Pod: pod}).HealthCheck(); err == nil {
+		t.Error("Expected error for negative timeout")
+	}
+}
+
+func TestNextHealthState(t *testing.T) {
+	hc := &HealthCheck{Retries: 2}
+	failure := errors.New("exit status 1")
+
+	h := &HealthState{Status: HealthStarting}
+	h, became := nextHealthState(hc, h, "", failure)
+	if h.Status != HealthStarting || h.Failures != 1 || h.Output != "exit status 1" || became {
+		t.Errorf("Unexpected state after first failure: %#v, %v", h, became)
+	}
+	h, became = nextHealthState(hc, h, "  connection refused\n", failure)
+	if h.Status != HealthUnhealthy || h.Failures != 2 || h.Output != "connection refused" || !became {
+		t.Errorf("Unexpected state after second failure: %#v, %v", h, became)
+	}
+	h, became = nextHealthState(hc, h, strings.Repeat("x", 2*maxHealthOutput), failure)
+	if h.Status != HealthUnhealthy || len(h.Output) != maxHealthOutput || became {
+		t.Errorf("Unexpected state after third failure: %v, %d, %v", h.Status, len(h.Output), became)
+	}
+	h, became = nextHealthState(hc, h, "", nil)
+	if h.Status != HealthHealthy || h.Failures != 0 || h.Output != "" || became {
+		t.Errorf("Unexpected state after success: %#v, %v", h, became)
+	}
+
+	st := &AppState{Status: AppStatusRunning, Health: h}
+	if st.String() != "running(healthy)" {
+		t.Errorf("Unexpected app state string: %v", st)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	stderrors "errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -42,6 +41,7 @@ var NoJailStatus = JailStatus{}
 
 type Host struct {
 	Dataset *zfs.Dataset
+	Rctl    Rctl
 
 	jailStatusTimestamp time.Time
 	jailStatusCache     map[string]JailStatus
@@ -50,7 +50,7 @@ type Host struct {
 }
 
 func NewHost() (*Host, error) {
-	h := Host{mdsUid: -1, mdsGid: -1}
+	h := Host{mdsUid: -1, mdsGid: -1, Rctl: sysRctl{}}
 
 	// FIXME: changing global switch based on struct instance
 	// variable. There should be only one instance created at a time
@@ -120,23 +120,13 @@ func (h *Host) Initialize() error {
 		return errors.Trace(err)
 	}
 
-	return nil
-}
-
-func (h *Host) HostIP() (net.IP, *net.IPNet, error) {
-	ifi, err := net.InterfaceByName(Config().MustGetString("jail.interface"))
-	if err != nil {
-		return nil, nil, errors.Trace(err)
-	}
-
-	addrs, err := ifi.Addrs()
-	if err != nil {
-		return nil, nil, errors.Trace(err)
+	if _, err := h.volumesDataset(); err != nil {
+		return errors.Trace(err)
 	}
 
-	ip, ipnet, err := net.ParseCIDR(addrs[0].String())
-	return ip, ipnet, errors.Trace(err)
+	return nil
 }
+
 func (h *Host) getJailStatus(name string, refresh bool) (JailStatus, error) {
 	if refresh || h.jailStatusCache == nil || time.Now().Sub(h.jailStatusTimestamp) > (2*time.Second) {
 		// FIXME: nicer cache/expiry implementation?
@@ -171,37 +161,17 @@ func (h *Host) getJailStatus(name string, refresh bool) (JailStatus, error) {
 	return h.jailStatusCache[name], nil
 }
 
-func (h *Host) nextIP() (net.IP, error) {
-	ip, ipnet, err := h.HostIP()
-	if err != nil {
-		return nil, errors.Trace(err)
-	}
-
-	ips := make(map[string]bool)
-	for _, c := range h.Pods() {
-		if ip, ok := c.Manifest.Annotations.Get("ip-address"); ok {
-			ips[ip] = true
-		}
-	}
-
-	for ip = nextIP(ip); ip != nil && ips[ip.String()]; ip = nextIP(ip) {
-	}
-
-	if ip == nil {
-		return nil, errors.New("Out of IPs")
-	}
-
-	if ipnet.Contains(ip) {
-		return ip, nil
-	} else {
-		return nil, errors.New("Out of IPs")
-	}
-}
-
 // Pods
 //////////////////////////////////////////////////////////////////////////////
 
-func (h *Host) ReifyPodManifest(pm *schema.PodManifest) (*schema.PodManifest, error) {
+// ReifyPodManifest resolves pod manifest's images, named volumes, and
+// apps. Overrides (built from command line flags) are merged on top
+// of their apps.
+func (h *Host) ReifyPodManifest(pm *schema.PodManifest, overrides acutil.AppOverrides) (*schema.PodManifest, error) {
+	if err := h.reifyNamedVolumes(pm); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	for i, rtapp := range pm.Apps {
 		img, err := h.getRuntimeImage(rtapp.Image)
 		if err != nil {
@@ -210,9 +180,9 @@ func (h *Host) ReifyPodManifest(pm *schema.PodManifest) (*schema.PodManifest, er
 
 		pm.Apps[i].Image.ID = *img.Hash
 
-		app := rtapp.App
-		if app == nil {
-			app = img.Manifest.App
+		app, err := reifyApp(img.Manifest.App, &pm.Apps[i], overrides[rtapp.Name])
+		if err != nil {
+			return nil, errors.Annotatef(err, "%v", rtapp.Name)
 		}
 		if app == nil {
 			continue
@@ -224,7 +194,7 @@ func (h *Host) ReifyPodManifest(pm *schema.PodManifest) (*schema.PodManifest, er
 			for _, mntc := range rtapp.Mounts {
 				if mntc.Path == mntpnt.Path || mntc.Path == mntpnt.Name.String() {
 					if mnt != nil {
-						fmt.Printf("WARNING: multiple mounts for %v:%v, using first one")
+						fmt.Printf("WARNING: multiple mounts for %v:%v, using first one\n", rtapp.Name, mntpnt.Name)
 					} else {
 						mnt = &mntc
 					}
@@ -255,6 +225,11 @@ func (h *Host) CreatePod(pm *schema.PodManifest) (*Pod, error) {
 	return CreatePod(h, pm)
 }
 
+// Create new pod from a stream written by Pod.Export
+func (h *Host) ImportPod(r io.Reader, keepUUID, keepIP bool) (*Pod, error) {
+	return ImportPod(h, r, keepUUID, keepIP)
+}
+
 func (h *Host) GetPod(id uuid.UUID) (*Pod, error) {
 	if c, err := LoadPod(h, id); err != nil {
 		return nil, errors.Trace(err)
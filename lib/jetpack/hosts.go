@@ -0,0 +1,227 @@
+package jetpack
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+)
+
+// Pod annotation that controls apps' /etc/hosts, one of Hosts* modes
+const HostsAnnotation = "jetpack/hosts"
+
+const (
+	// Generate the file when the pod starts (default)
+	HostsGenerate = "generate"
+	// Leave apps' own file alone
+	HostsNone = "none"
+)
+
+func podHostsMode(pm *schema.PodManifest) (string, error) {
+	mode := HostsGenerate
+	if v, ok := pm.Annotations.Get(HostsAnnotation); ok {
+		mode = v
+	}
+	switch mode {
+	case HostsGenerate, HostsNone:
+		return mode, nil
+	default:
+		return "", errors.Errorf("Invalid %v annotation: %#v", HostsAnnotation, mode)
+	}
+}
+
+type hostsEntry struct {
+	IP    string
+	Names []string
+}
+
+// Returns contents of /etc/hosts with localhost and entries
+func hostsFile(entries []hostsEntry) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# Generated by jetpack")
+	fmt.Fprintln(buf, "::1\tlocalhost")
+	fmt.Fprintln(buf, "127.0.0.1\tlocalhost")
+	for _, e := range entries {
+		fmt.Fprintf(buf, "%v\t%v\n", e.IP, strings.Join(e.Names, " "))
+	}
+	return buf.Bytes()
+}
+
+// Returns names a pod is known by: fully qualified name first, then
+// the short one.
+func podHostNames(name, domain string) []string {
+	if i := strings.Index(name, "."); i > 0 {
+		return []string{name, name[:i]}
+	}
+	if domain == "" {
+		return []string{name}
+	}
+	return []string{name + "." + domain, name}
+}
+
+func dnsDomain() string {
+	return strings.Trim(Config().GetString("mds.dns.domain", "jetpack"), ".")
+}
+
+func (pod *Pod) hostsEntries() []hostsEntry {
+	var entries []hostsEntry
+	for _, ip := range pod.IPAddresses() {
+		entries = append(entries, hostsEntry{IP: ip, Names: podHostNames(pod.Name(), dnsDomain())})
+	}
+	return entries
+}
+
+// Returns contents of pod's /etc/hosts: the pod itself, and all other
+// pods on the host if hosts.all-pods is set.
+func (pod *Pod) hostsFile() []byte {
+	entries := pod.hostsEntries()
+	if Config().GetBool("hosts.all-pods", false) {
+		for _, other := range pod.Host.Pods() {
+			if !uuid.Equal(other.UUID, pod.UUID) {
+				entries = append(entries, other.hostsEntries()...)
+			}
+		}
+	}
+	return hostsFile(entries)
+}
+
+// Writes hosts and resolv.conf, unless pod's modes say otherwise, to
+// /etc of each app that has one
+func (pod *Pod) writeEtcFiles() error {
+	var resolvConf []byte
+	if mode, err := podResolvConfMode(&pod.Manifest); err != nil {
+		return errors.Trace(err)
+	} else if mode == ResolvConfGenerate {
+		if resolvConf, err = pod.resolvConf(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	var hosts []byte
+	if mode, err := podHostsMode(&pod.Manifest); err != nil {
+		return errors.Trace(err)
+	} else if mode == HostsGenerate {
+		hosts = pod.hostsFile()
+	}
+	for _, app := range pod.Manifest.Apps {
+		etcPath := pod.Path("rootfs", "app", app.Name.String(), "rootfs", "etc")
+		if fi, err := os.Stat(etcPath); err == nil && fi.IsDir() {
+			if resolvConf != nil {
+				if err := pod.writeEtcFile(app.Name, etcPath, "resolv.conf", resolvConf); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			if hosts != nil {
+				if err := pod.writeEtcFile(app.Name, etcPath, "hosts", hosts); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Suffix of the file that records that app had no such file in /etc
+const etcAbsentSuffix = ".absent"
+
+// Returns path in pod's directory where app's original /etc/name is
+// kept while jetpack provides its own
+func (pod *Pod) etcOrigPath(app types.ACName, name string) string {
+	return pod.Path("etc", app.String(), name)
+}
+
+// Saves app's original /etc/name, unless it has been saved already.
+// Symlinks are saved as they are, not followed.
+func (pod *Pod) saveEtcFile(app types.ACName, etcPath, name string) error {
+	orig := pod.etcOrigPath(app, name)
+	for _, path := range []string{orig, orig + etcAbsentSuffix} {
+		if _, err := os.Lstat(path); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(orig), 0700); err != nil {
+		return errors.Trace(err)
+	}
+
+	target := filepath.Join(etcPath, name)
+	fi, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		return errors.Trace(ioutil.WriteFile(orig+etcAbsentSuffix, nil, 0600))
+	case err != nil:
+		return errors.Trace(err)
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(target)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(os.Symlink(link, orig))
+	case fi.Mode().IsRegular():
+		data, err := ioutil.ReadFile(target)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(ioutil.WriteFile(orig, data, fi.Mode().Perm()))
+	default:
+		return errors.Errorf("%v is not a regular file", target)
+	}
+}
+
+// Replaces app's /etc/name with data, saving the original first
+func (pod *Pod) writeEtcFile(app types.ACName, etcPath, name string, data []byte) error {
+	if err := pod.saveEtcFile(app, etcPath, name); err != nil {
+		return errors.Trace(err)
+	}
+	// Don't write through a symlink, it's resolved on the host
+	target := filepath.Join(etcPath, name)
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(target, data, 0644))
+}
+
+// Puts app's original /etc files that jetpack has replaced back in
+// rootfs (app's root filesystem, possibly cloned), so that jetpack's
+// files don't end up in an image.
+func (pod *Pod) restoreEtcFiles(app types.ACName, rootfs string) error {
+	origDir := pod.Path("etc", app.String())
+	names, err := ioutil.ReadDir(origDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	for _, fi := range names {
+		orig := filepath.Join(origDir, fi.Name())
+		name := strings.TrimSuffix(fi.Name(), etcAbsentSuffix)
+		target := filepath.Join(rootfs, "etc", name)
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+		switch {
+		case name != fi.Name():
+			// App had no such file
+		case fi.Mode()&os.ModeSymlink != 0:
+			if link, err := os.Readlink(orig); err != nil {
+				return errors.Trace(err)
+			} else if err := os.Symlink(link, target); err != nil {
+				return errors.Trace(err)
+			}
+		default:
+			if data, err := ioutil.ReadFile(orig); err != nil {
+				return errors.Trace(err)
+			} else if err := ioutil.WriteFile(target, data, fi.Mode().Perm()); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package jetpack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+func TestHostsFile(t *testing.T) {
+	expected := "# Generated by jetpack\n" +
+		"::1\tlocalhost\n" +
+		"127.0.0.1\tlocalhost\n" +
+		"172.23.0.2\tweb.jetpack web\n" +
+		"fd00:23::2\tweb.jetpack web\n"
+	actual := hostsFile([]hostsEntry{
+		{IP: "172.23.0.2", Names: podHostNames("web", "jetpack")},
+		{IP: "fd00:23::2", Names: podHostNames("web", "jetpack")},
+	})
+	if string(actual) != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, string(actual))
+	}
+}
+
+func TestPodHostNames(t *testing.T) {
+	for _, c := range []struct {
+		name, domain string
+		expected     []string
+	}{
+		{"web", "jetpack", []string{"web.jetpack", "web"}},
+		{"web", "", []string{"web"}},
+		{"web.example.com", "jetpack", []string{"web.example.com", "web"}},
+	} {
+		if actual := podHostNames(c.name, c.domain); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, actual)
+		}
+	}
+}
+
+func TestPodHostsMode(t *testing.T) {
+	pm := schema.BlankPodManifest()
+	if mode, err := podHostsMode(pm); err != nil || mode != HostsGenerate {
+		t.Errorf("Expected %v by default, got %v (%v)", HostsGenerate, mode, err)
+	}
+	pm.Annotations.Set(HostsAnnotation, HostsNone)
+	if mode, err := podHostsMode(pm); err != nil || mode != HostsNone {
+		t.Errorf("Expected %v, got %v (%v)", HostsNone, mode, err)
+	}
+	pm.Annotations.Set(HostsAnnotation, "host")
+	if _, err := podHostsMode(pm); err == nil {
+		t.Error("Expected error for invalid hosts mode")
+	}
+}
+
+func TestRestoreEtcFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jetpack-etc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pod := &Pod{
+		UUID: uuid.NewRandom(),
+		Host: &Host{Dataset: &zfs.Dataset{Mountpoint: filepath.Join(dir, "host")}},
+	}
+	rootfs := filepath.Join(dir, "rootfs")
+	etcPath := filepath.Join(rootfs, "etc")
+	if err := os.MkdirAll(etcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(etcPath, "hosts"), []byte("10.0.0.1 own\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/var/run/resolv.conf", filepath.Join(etcPath, "resolv.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written twice, as on each jail start
+	for i := 0; i < 2; i++ {
+		for _, name := range []string{"hosts", "resolv.conf", "absent"} {
+			if err := pod.writeEtcFile("app", etcPath, name, []byte("generated\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(etcPath, "resolv.conf")); string(data) != "generated\n" {
+		t.Errorf("Symlink not replaced: %#v", string(data))
+	}
+
+	if err := pod.restoreEtcFiles("app", rootfs); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(etcPath, "hosts")); string(data) != "10.0.0.1 own\n" {
+		t.Errorf("Original hosts not restored: %#v", string(data))
+	}
+	if fi, err := os.Stat(filepath.Join(etcPath, "hosts")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Original hosts' mode not restored: %v %v", fi, err)
+	}
+	if link, err := os.Readlink(filepath.Join(etcPath, "resolv.conf")); err != nil || link != "/var/run/resolv.conf" {
+		t.Errorf("Original symlink not restored: %#v %v", link, err)
+	}
+	if _, err := os.Lstat(filepath.Join(etcPath, "absent")); !os.IsNotExist(err) {
+		t.Errorf("Generated file not removed: %v", err)
+	}
+}
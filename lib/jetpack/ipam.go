@@ -0,0 +1,368 @@
+package jetpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+	"golang.org/x/sys/unix"
+)
+
+// IPLease is an address leased to a pod, stored in host's lease
+// database.
+type IPLease struct {
+	IP      string
+	Pod     string
+	Static  bool `json:",omitempty"`
+	Created time.Time
+}
+
+// Range of addresses of a single family, both ends inclusive
+type ipRange struct {
+	First, Last net.IP
+}
+
+func (r ipRange) String() string {
+	if r.First.Equal(r.Last) {
+		return r.First.String()
+	}
+	return r.First.String() + "-" + r.Last.String()
+}
+
+func (r ipRange) Contains(ip net.IP) bool {
+	ip = ip.To16()
+	return bytes.Compare(r.First.To16(), ip) <= 0 && bytes.Compare(ip, r.Last.To16()) <= 0
+}
+
+func (r ipRange) IsIPv6() bool {
+	return r.First.To4() == nil
+}
+
+// Parses an address range: a single address, network in CIDR
+// notation, or FIRST-LAST.
+func parseIPRange(s string) (ipRange, error) {
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return ipRange{}, errors.Trace(err)
+		}
+		return ipRange{First: ipnet.IP, Last: lastIP(ipnet)}, nil
+	}
+
+	pieces := strings.SplitN(s, "-", 2)
+	if len(pieces) == 1 {
+		pieces = append(pieces, pieces[0])
+	}
+	first, last := net.ParseIP(strings.TrimSpace(pieces[0])), net.ParseIP(strings.TrimSpace(pieces[1]))
+	if first == nil || last == nil {
+		return ipRange{}, errors.Errorf("Invalid address range %#v", s)
+	}
+	if (first.To4() == nil) != (last.To4() == nil) {
+		return ipRange{}, errors.Errorf("Invalid address range %#v: mixed address families", s)
+	}
+	if bytes.Compare(first.To16(), last.To16()) > 0 {
+		return ipRange{}, errors.Errorf("Invalid address range %#v: first address is after last", s)
+	}
+	return ipRange{First: first, Last: last}, nil
+}
+
+// Parses a comma- or whitespace-separated list of address ranges
+func parseIPRanges(s string) ([]ipRange, error) {
+	var ranges []ipRange
+	for _, rs := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		r, err := parseIPRange(rs)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// Pool of addresses of a single family that can be leased to pods
+type ipPool struct {
+	HostIP  net.IP
+	Network *net.IPNet
+	// Dynamic addresses are allocated from these ranges, in order
+	Ranges []ipRange
+	// Addresses that are never leased
+	Exclude []ipRange
+}
+
+// Returns an error if ip can't be leased to a pod
+func (p *ipPool) check(ip net.IP) error {
+	if !p.Network.Contains(ip) {
+		return errors.Errorf("%v is outside of network %v", ip, p.Network)
+	}
+	if ip.Equal(p.HostIP) {
+		return errors.Errorf("%v is host's address", ip)
+	}
+	if ip.Equal(p.Network.IP) {
+		return errors.Errorf("%v is network's address", ip)
+	}
+	if ip.To4() != nil && ip.Equal(lastIP(p.Network)) {
+		return errors.Errorf("%v is network's broadcast address", ip)
+	}
+	for _, r := range p.Exclude {
+		if r.Contains(ip) {
+			return errors.Errorf("%v is excluded (%v)", ip, r)
+		}
+	}
+	return nil
+}
+
+// Returns first address from pool's ranges that can be leased and is
+// not in leased (a set of addresses' canonical string representations)
+func (p *ipPool) allocate(leased map[string]bool) (net.IP, error) {
+	for _, r := range p.Ranges {
+		for ip := copyIP(r.First); ip != nil && r.Contains(ip); ip = nextIP(ip) {
+			if !leased[ip.String()] && p.check(ip) == nil {
+				return copyIP(ip), nil
+			}
+		}
+	}
+	return nil, errors.New("Out of IPs")
+}
+
+func copyIP(ip net.IP) net.IP {
+	return append(net.IP(nil), ip...)
+}
+
+func lastIP(ipnet *net.IPNet) net.IP {
+	last := make(net.IP, len(ipnet.IP))
+	for i := range ipnet.IP {
+		last[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	return last
+}
+
+// Returns pool of addresses of given family, configured by
+// ipam.ranges and ipam.exclude. Without configured ranges, whole
+// network of jail.interface is used.
+func (h *Host) ipPool(v6 bool) (*ipPool, error) {
+	pool := &ipPool{}
+	var err error
+	if v6 {
+		pool.HostIP, pool.Network, err = h.HostIP6()
+	} else {
+		pool.HostIP, pool.Network, err = h.HostIP()
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ranges, err := parseIPRanges(Config().GetString("ipam.ranges", ""))
+	if err != nil {
+		return nil, errors.Annotate(err, "ipam.ranges")
+	}
+	for _, r := range ranges {
+		if r.IsIPv6() != v6 {
+			continue
+		}
+		if !pool.Network.Contains(r.First) || !pool.Network.Contains(r.Last) {
+			return nil, errors.Errorf("ipam.ranges: %v is outside of network %v", r, pool.Network)
+		}
+		pool.Ranges = append(pool.Ranges, r)
+	}
+	if len(pool.Ranges) == 0 {
+		pool.Ranges = []ipRange{{First: pool.Network.IP, Last: lastIP(pool.Network)}}
+	}
+
+	exclude, err := parseIPRanges(Config().GetString("ipam.exclude", ""))
+	if err != nil {
+		return nil, errors.Annotate(err, "ipam.exclude")
+	}
+	for _, r := range exclude {
+		if r.IsIPv6() == v6 {
+			pool.Exclude = append(pool.Exclude, r)
+		}
+	}
+
+	return pool, nil
+}
+
+// Lease database
+//////////////////////////////////////////////////////////////////////////////
+
+// Reads lease database. If it doesn't exist yet, leases are
+// recovered from pods' address annotations.
+func (h *Host) readIPLeases() ([]IPLease, error) {
+	data, err := ioutil.ReadFile(h.Path("ipam.json"))
+	if os.IsNotExist(err) {
+		var leases []IPLease
+		for _, pod := range h.Pods() {
+			for _, ip := range pod.IPAddresses() {
+				leases = append(leases, IPLease{IP: ip, Pod: pod.UUID.String(), Created: time.Now()})
+			}
+		}
+		return leases, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var leases []IPLease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, errors.Annotate(err, h.Path("ipam.json"))
+	}
+	return leases, nil
+}
+
+// Runs fn with an exclusive lock on the lease database, and saves
+// leases it returns. The database is replaced atomically, so that
+// readers (e.g. the metadata service) don't need the lock.
+func (h *Host) updateIPLeases(fn func([]IPLease) ([]IPLease, error)) error {
+	lock, err := os.OpenFile(h.Path("ipam.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Trace(err)
+	}
+
+	leases, err := h.readIPLeases()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if leases, err = fn(leases); err != nil {
+		return errors.Trace(err)
+	}
+	sortIPLeases(leases)
+
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmp := h.Path("ipam.json.tmp")
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return errors.Trace(err)
+	}
+	_, mdsGID := MDSUidGid()
+	if err := os.Chown(tmp, 0, mdsGID); err != nil {
+		os.Remove(tmp)
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, h.Path("ipam.json")))
+}
+
+type ipLeasesByIP []IPLease
+
+func (ll ipLeasesByIP) Len() int      { return len(ll) }
+func (ll ipLeasesByIP) Swap(i, j int) { ll[i], ll[j] = ll[j], ll[i] }
+func (ll ipLeasesByIP) Less(i, j int) bool {
+	return bytes.Compare(net.ParseIP(ll[i].IP).To16(), net.ParseIP(ll[j].IP).To16()) < 0
+}
+
+func sortIPLeases(leases []IPLease) {
+	sort.Sort(ipLeasesByIP(leases))
+}
+
+// IPLeases returns all addresses leased to pods
+func (h *Host) IPLeases() ([]IPLease, error) {
+	leases, err := h.readIPLeases()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sortIPLeases(leases)
+	return leases, nil
+}
+
+// PodByIP returns pod that ip is leased to
+func (h *Host) PodByIP(ip net.IP) (*Pod, error) {
+	leases, err := h.readIPLeases()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, lease := range leases {
+		if ip.Equal(net.ParseIP(lease.IP)) {
+			if id := uuid.Parse(lease.Pod); id != nil {
+				return h.GetPod(id)
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Leases addresses to a new pod, according to its IP family and
+// requested static addresses, and stores them in pod's annotations.
+// Annotations of address families pod doesn't use are removed.
+func (h *Host) leaseIPs(id uuid.UUID, pm *schema.PodManifest) error {
+	v4, v6, err := podIPFamily(pm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	static, err := podStaticIPs(pm, v4, v6)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return h.updateIPLeases(func(leases []IPLease) ([]IPLease, error) {
+		leased := make(map[string]bool, len(leases))
+		owners := make(map[string]string, len(leases))
+		for _, lease := range leases {
+			if ip := net.ParseIP(lease.IP); ip != nil {
+				leased[ip.String()] = true
+				owners[ip.String()] = lease.Pod
+			}
+		}
+
+		for i, fam := range []struct {
+			use        bool
+			v6         bool
+			annotation string
+		}{
+			{v4, false, IP4AddressAnnotation},
+			{v6, true, IP6AddressAnnotation},
+		} {
+			if !fam.use {
+				unsetAnnotation(&pm.Annotations, types.ACIdentifier(fam.annotation))
+				continue
+			}
+			pool, err := h.ipPool(fam.v6)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			lease := IPLease{Pod: id.String(), Created: time.Now()}
+			if ip := static[i]; ip != nil {
+				if err := pool.check(ip); err != nil {
+					return nil, errors.Annotate(err, "Static IP address")
+				}
+				if leased[ip.String()] {
+					return nil, errors.Errorf("Static IP address %v is already leased to pod %v", ip, owners[ip.String()])
+				}
+				lease.IP, lease.Static = ip.String(), true
+			} else {
+				ip, err := pool.allocate(leased)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				lease.IP = ip.String()
+			}
+			leases = append(leases, lease)
+			leased[lease.IP] = true
+			pm.Annotations.Set(types.ACIdentifier(fam.annotation), lease.IP)
+		}
+		return leases, nil
+	})
+}
+
+// Releases addresses leased to pod
+func (h *Host) releaseIPs(id uuid.UUID) error {
+	return h.updateIPLeases(func(leases []IPLease) ([]IPLease, error) {
+		kept := leases[:0]
+		for _, lease := range leases {
+			if lease.Pod != id.String() {
+				kept = append(kept, lease)
+			}
+		}
+		return kept, nil
+	})
+}
@@ -0,0 +1,98 @@
+package jetpack
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	ranges, err := parseIPRanges("172.23.1.0/24, 172.23.0.10-172.23.0.20 fd00:23::5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"172.23.1.0-172.23.1.255", "172.23.0.10-172.23.0.20", "fd00:23::5"}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Expected %d ranges, got %v", len(expected), ranges)
+	}
+	for i, r := range ranges {
+		if r.String() != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], r)
+		}
+	}
+	if !ranges[2].IsIPv6() || ranges[0].IsIPv6() {
+		t.Error("Wrong address family")
+	}
+	if !ranges[1].Contains(net.ParseIP("172.23.0.20")) || ranges[1].Contains(net.ParseIP("172.23.0.21")) {
+		t.Error("Wrong range bounds")
+	}
+
+	for _, s := range []string{"nope", "172.23.0.10-fd00::1", "172.23.0.20-172.23.0.10", "172.23.0.0/33"} {
+		if _, err := parseIPRanges(s); err == nil {
+			t.Errorf("%v: expected error", s)
+		}
+	}
+}
+
+func testIPPool(t *testing.T, cidr, ranges, exclude string) *ipPool {
+	pool := &ipPool{}
+	var err error
+	if pool.HostIP, pool.Network, err = net.ParseCIDR(cidr); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Ranges, err = parseIPRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Exclude, err = parseIPRanges(exclude); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestIPPoolCheck(t *testing.T) {
+	pool := testIPPool(t, "172.23.0.1/24", "", "172.23.0.100-172.23.0.110")
+	for ip, ok := range map[string]bool{
+		"172.23.0.2":   true,
+		"172.23.0.111": true,
+		"172.23.0.0":   false, // network
+		"172.23.0.1":   false, // host
+		"172.23.0.255": false, // broadcast
+		"172.23.0.105": false, // excluded
+		"172.23.1.2":   false, // outside of network
+	} {
+		if err := pool.check(net.ParseIP(ip)); (err == nil) != ok {
+			t.Errorf("%v: expected ok=%v, got %v", ip, ok, err)
+		}
+	}
+}
+
+func TestIPPoolAllocate(t *testing.T) {
+	for _, c := range []struct {
+		cidr, ranges, exclude string
+		leased                []string
+		expected              string
+	}{
+		{"172.23.0.1/16", "172.23.0.0/16", "", nil, "172.23.0.2"},
+		{"172.23.0.1/16", "172.23.0.0/16", "", []string{"172.23.0.2", "172.23.0.3"}, "172.23.0.4"},
+		{"172.23.0.1/16", "172.23.0.0/16", "172.23.0.0-172.23.0.255", nil, "172.23.1.0"},
+		{"172.23.0.1/16", "172.23.5.10-172.23.5.11, 172.23.6.0/24", "", []string{"172.23.5.10", "172.23.5.11"}, "172.23.6.0"},
+		{"172.23.0.1/24", "172.23.0.250-172.23.0.255", "", []string{"172.23.0.250", "172.23.0.251", "172.23.0.252", "172.23.0.253", "172.23.0.254"}, ""},
+		{"fd00:23::1/64", "fd00:23::/64", "", []string{"fd00:23::2"}, "fd00:23::3"},
+		{"fd00:23::1/64", "fd00:23::ffff-fd00:23::1:1", "", []string{"fd00:23::ffff"}, "fd00:23::1:0"},
+	} {
+		pool := testIPPool(t, c.cidr, c.ranges, c.exclude)
+		leased := make(map[string]bool)
+		for _, ip := range c.leased {
+			leased[ip] = true
+		}
+		actual, err := pool.allocate(leased)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%v: expected error, got %v", c.ranges, actual)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %v", c.ranges, err)
+		} else if actual.String() != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.ranges, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"k8s.io/kubernetes/pkg/api/resource"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+// Jetpack-specific isolators. Their values are JSON objects with
+// a single "limit" field (maxproc, openfiles), or with any of
+// "readbps", "writebps", "readiops", "writeiops" fields (disk-io).
+const (
+	IsolatorMaxProc   = "jetpack/maxproc"
+	IsolatorOpenFiles = "jetpack/openfiles"
+	IsolatorDiskIO    = "jetpack/disk-io"
+)
+
+// Rctl manages kernel resource limit rules (see rctl(8)). It is an
+// interface, so that tests don't need to touch the real thing.
+type Rctl interface {
+	AddRule(rule string) error
+	RemoveRules(filter string) error
+}
+
+type sysRctl struct{}
+
+func (sysRctl) AddRule(rule string) error {
+	return run.Command("/usr/bin/rctl", "-a", rule).Run()
+}
+
+func (sysRctl) RemoveRules(filter string) error {
+	return run.Command("/usr/bin/rctl", "-r", filter).Run()
+}
+
+// rctl resources with rule actions other than the default "deny"
+var rctlActions = map[string]string{
+	"readbps":   "throttle",
+	"writebps":  "throttle",
+	"readiops":  "throttle",
+	"writeiops": "throttle",
+}
+
+type limitIsolator struct {
+	Limit *resource.Quantity `json:"limit"`
+}
+
+type diskIOIsolator struct {
+	ReadBPS   *resource.Quantity `json:"readbps"`
+	WriteBPS  *resource.Quantity `json:"writebps"`
+	ReadIOPS  *resource.Quantity `json:"readiops"`
+	WriteIOPS *resource.Quantity `json:"writeiops"`
+}
+
+// Returns rctl resource amounts for a list of isolators. Later
+// isolators of the same kind override earlier ones.
+func isolatorLimits(isolators types.Isolators) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, isol := range isolators {
+		switch isol.Name {
+		case types.ResourceCPUName, types.ResourceMemoryName:
+			res, ok := isol.Value().(types.Resource)
+			if !ok {
+				return nil, errors.Errorf("Invalid isolator %v", isol.Name)
+			}
+			if lim := res.Limit(); lim == nil {
+				continue
+			} else if isol.Name == types.ResourceCPUName {
+				// Limit is in CPU cores, rctl wants percent of a core
+				limits["pcpu"] = (lim.MilliValue() + 9) / 10
+			} else {
+				limits["memoryuse"] = lim.Value()
+			}
+
+		case IsolatorMaxProc, IsolatorOpenFiles:
+			var val limitIsolator
+			if err := unmarshalIsolator(isol, &val); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if val.Limit == nil {
+				return nil, errors.Errorf("Isolator %v: no limit", isol.Name)
+			}
+			limits[string(isol.Name)[len("jetpack/"):]] = val.Limit.Value()
+
+		case IsolatorDiskIO:
+			var val diskIOIsolator
+			if err := unmarshalIsolator(isol, &val); err != nil {
+				return nil, errors.Trace(err)
+			}
+			for rsrc, qty := range map[string]*resource.Quantity{
+				"readbps":   val.ReadBPS,
+				"writebps":  val.WriteBPS,
+				"readiops":  val.ReadIOPS,
+				"writeiops": val.WriteIOPS,
+			} {
+				if qty != nil {
+					limits[rsrc] = qty.Value()
+				}
+			}
+
+		default:
+			return nil, errors.Errorf("Unsupported isolator: %v", isol.Name)
+		}
+	}
+	return limits, nil
+}
+
+func unmarshalIsolator(isol types.Isolator, v interface{}) error {
+	if isol.ValueRaw == nil {
+		return errors.Errorf("Isolator %v has no value", isol.Name)
+	}
+	if err := json.Unmarshal(*isol.ValueRaw, v); err != nil {
+		return errors.Annotatef(err, "Isolator %v", isol.Name)
+	}
+	return nil
+}
+
+// Returns rctl rules for a jail named jailName. Pod-level isolators
+// limit the whole jail. All apps share the same jail, so app-level
+// limits are summed up for resources that the pod doesn't limit
+// itself.
+func rctlRules(jailName string, podIsolators types.Isolators, appIsolators []types.Isolators) ([]string, error) {
+	limits, err := isolatorLimits(podIsolators)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	appLimits := make(map[string]int64)
+	for _, isolators := range appIsolators {
+		if lims, err := isolatorLimits(isolators); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			for rsrc, amount := range lims {
+				appLimits[rsrc] += amount
+			}
+		}
+	}
+
+	for rsrc, amount := range appLimits {
+		if _, ok := limits[rsrc]; !ok {
+			limits[rsrc] = amount
+		}
+	}
+
+	rules := make([]string, 0, len(limits))
+	for rsrc, amount := range limits {
+		action := rctlActions[rsrc]
+		if action == "" {
+			action = "deny"
+		}
+		rules = append(rules, fmt.Sprintf("jail:%v:%v:%v=%d", jailName, rsrc, action, amount))
+	}
+	sort.Strings(rules)
+	return rules, nil
+}
+
+// Adds rctl rules. If any rule fails, rules for the filter are
+// removed again, so that jail is not left half-limited.
+func addRctlRules(rctl Rctl, filter string, rules []string) error {
+	for _, rule := range rules {
+		if err := rctl.AddRule(rule); err != nil {
+			rctl.RemoveRules(filter)
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
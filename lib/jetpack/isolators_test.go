@@ -0,0 +1,117 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func mustIsolators(js string) types.Isolators {
+	var isols types.Isolators
+	if err := json.Unmarshal([]byte(js), &isols); err != nil {
+		panic(err)
+	}
+	return isols
+}
+
+func TestRctlRules(t *testing.T) {
+	for i, tc := range []struct {
+		pod      string
+		apps     []string
+		expected []string
+	}{
+		{`[]`, nil, []string{}},
+		{
+			`[{"name":"resource/cpu","value":{"limit":"500m"}},{"name":"resource/memory","value":{"limit":"1Gi"}}]`,
+			nil,
+			[]string{"jail:j:memoryuse:deny=1073741824", "jail:j:pcpu:deny=50"},
+		},
+		{
+			`[{"name":"resource/cpu","value":{"request":"1","limit":"2"}}]`,
+			[]string{
+				`[{"name":"resource/cpu","value":{"limit":"1"}},{"name":"resource/memory","value":{"limit":"100M"}}]`,
+				`[{"name":"resource/memory","value":{"limit":"50M"}}]`,
+			},
+			[]string{"jail:j:memoryuse:deny=150000000", "jail:j:pcpu:deny=200"},
+		},
+		{
+			`[{"name":"jetpack/maxproc","value":{"limit":64}},{"name":"jetpack/openfiles","value":{"limit":"1k"}}]`,
+			nil,
+			[]string{"jail:j:maxproc:deny=64", "jail:j:openfiles:deny=1000"},
+		},
+		{
+			`[{"name":"jetpack/disk-io","value":{"readbps":"10Mi","writeiops":100}}]`,
+			nil,
+			[]string{"jail:j:readbps:throttle=10485760", "jail:j:writeiops:throttle=100"},
+		},
+		{
+			`[{"name":"jetpack/maxproc","value":{"limit":64}},{"name":"jetpack/maxproc","value":{"limit":32}}]`,
+			nil,
+			[]string{"jail:j:maxproc:deny=32"},
+		},
+	} {
+		appIsols := make([]types.Isolators, len(tc.apps))
+		for j, js := range tc.apps {
+			appIsols[j] = mustIsolators(js)
+		}
+		if rules, err := rctlRules("j", mustIsolators(tc.pod), appIsols); err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(rules, tc.expected) {
+			t.Errorf("Case %d: expected %#v, got %#v", i, tc.expected, rules)
+		}
+	}
+}
+
+func TestRctlRulesUnsupported(t *testing.T) {
+	for _, js := range []string{
+		`[{"name":"resource/network-bandwidth","value":{"default":true,"limit":"1M"}}]`,
+		`[{"name":"example.com/whatever","value":{}}]`,
+		`[{"name":"jetpack/maxproc","value":{}}]`,
+	} {
+		if rules, err := rctlRules("j", mustIsolators(js), nil); err == nil {
+			t.Errorf("Expected error for %v, got rules %#v", js, rules)
+		}
+	}
+}
+
+type fakeRctl struct {
+	rules   []string
+	removed []string
+	failOn  string
+}
+
+func (fr *fakeRctl) AddRule(rule string) error {
+	if rule == fr.failOn {
+		return errors.New("rctl failed")
+	}
+	fr.rules = append(fr.rules, rule)
+	return nil
+}
+
+func (fr *fakeRctl) RemoveRules(filter string) error {
+	fr.removed = append(fr.removed, filter)
+	return nil
+}
+
+func TestAddRctlRules(t *testing.T) {
+	rules := []string{"jail:j:maxproc:deny=10", "jail:j:pcpu:deny=50"}
+
+	fr := &fakeRctl{}
+	if err := addRctlRules(fr, "jail:j", rules); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !reflect.DeepEqual(fr.rules, rules) || len(fr.removed) != 0 {
+		t.Errorf("Unexpected rctl state: %#v", fr)
+	}
+
+	fr = &fakeRctl{failOn: rules[1]}
+	if err := addRctlRules(fr, "jail:j", rules); err == nil {
+		t.Error("Expected an error")
+	}
+	if !reflect.DeepEqual(fr.removed, []string{"jail:j"}) {
+		t.Errorf("Expected rules to be removed, got %#v", fr.removed)
+	}
+}
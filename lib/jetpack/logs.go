@@ -0,0 +1,118 @@
+package jetpack
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"github.com/mattrobenolt/size"
+
+	"github.com/3ofcoins/jetpack/lib/applog"
+	"github.com/3ofcoins/jetpack/lib/drain"
+)
+
+// App's output streams that are logged
+var LogStreams = []string{"out", "err"}
+
+// LogSource identifies an output stream of an app
+type LogSource struct {
+	App    types.ACName
+	Stream string
+}
+
+func (ls LogSource) String() string {
+	return fmt.Sprintf("%v:%v", ls.App, ls.Stream)
+}
+
+func (pod *Pod) logPath(app types.ACName, stream string) string {
+	return pod.Path("logs", string(app), stream+".log")
+}
+
+// Opens app's log for writing, with rotation configured by logs.max-size
+// and logs.keep properties.
+func (pod *Pod) openLog(app types.ACName, stream string) (*applog.Log, error) {
+	maxSize, err := size.ParseCapacity(Config().GetString("logs.max-size", "0"))
+	if err != nil {
+		return nil, errors.Annotate(err, "logs.max-size")
+	}
+	if err := os.MkdirAll(pod.Path("logs", string(app)), 0750); err != nil {
+		return nil, errors.Trace(err)
+	}
+	l, err := applog.Open(pod.logPath(app, stream), int64(maxSize.Bytes()), Config().GetInt("logs.keep", 0))
+	return l, errors.Trace(err)
+}
+
+// ReadLog returns all logged entries of app's stream, oldest first,
+// and the offset to follow the log from.
+func (pod *Pod) ReadLog(app types.ACName, stream string) ([]applog.Entry, int64, error) {
+	entries, offset, err := applog.Read(pod.logPath(app, stream))
+	return entries, offset, errors.Trace(err)
+}
+
+// FollowLog calls fn for each new entry of app's stream logged after
+// offset, until stop is closed.
+func (pod *Pod) FollowLog(app types.ACName, stream string, offset int64, stop <-chan struct{}, fn func(applog.Entry)) error {
+	return errors.Trace(applog.Follow(pod.logPath(app, stream), offset, stop, fn))
+}
+
+// RunAttached runs the app like Run, with its input and output attached
+// to stdin, stdout, and stderr. The output is logged as well, as in
+// Pod.Run. In pod's JSON LogFormat, app's output and jetpack's
+// messages are printed to stdout as JSON objects.
+func (app *App) RunAttached(stdin io.Reader, stdout, stderr io.Writer) error {
+	out, err := app.Pod.newRunOutput(stdout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer out.Close()
+
+	dr := make(drain.Drain)
+	writers := [2]*drain.Writer{dr.NewWriter(), dr.NewWriter()}
+	logs := make(map[*drain.Writer]*applog.Log, len(writers))
+	for i, stream := range LogStreams {
+		if l, err := app.Pod.openLog(app.Name, stream); err != nil {
+			for _, l := range logs {
+				l.Close()
+			}
+			return errors.Trace(err)
+		} else {
+			logs[writers[i]] = l
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for line := range dr {
+			if out.enc != nil {
+				src := LogSource{App: app.Name, Stream: LogStreams[0]}
+				if line.Writer == writers[1] {
+					src.Stream = LogStreams[1]
+				}
+				out.Line(line, src)
+			}
+			if err := logs[line.Writer].Write(applog.Entry{Timestamp: line.Timestamp, Text: line.Text}); err != nil {
+				app.Pod.ui.Printf("%v: cannot write log: %v", app.Name, err)
+			}
+		}
+		for _, l := range logs {
+			l.Close()
+		}
+		close(done)
+	}()
+
+	if out.enc != nil {
+		err = app.Run(stdin, writers[0], writers[1])
+	} else {
+		app.logOut, app.logErr = writers[0], writers[1]
+		err = app.Run(stdin, stdout, stderr)
+		app.logOut, app.logErr = nil, nil
+	}
+
+	writers[0].Close()
+	writers[1].Close()
+	close(dr)
+	<-done
+	return err
+}
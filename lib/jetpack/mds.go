@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os/user"
 	"strconv"
+	"strings"
 
 	"github.com/pborman/uuid"
 
@@ -19,11 +21,18 @@ import (
 type MDSInfo struct {
 	Pid, Uid, Gid, Port int
 	Version, IP         string
+	IP6                 string `json:",omitempty"`
 }
 
 func (mdsi *MDSInfo) String() string {
-	return fmt.Sprintf("MDS[%d] (u%d g%d %v:%d %v)",
-		mdsi.Pid, mdsi.Uid, mdsi.Gid, mdsi.IP, mdsi.Port, mdsi.Version)
+	addrs := make([]string, 0, 2)
+	for _, ip := range []string{mdsi.IP, mdsi.IP6} {
+		if ip != "" {
+			addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(mdsi.Port)))
+		}
+	}
+	return fmt.Sprintf("MDS[%d] (u%d g%d %v %v)",
+		mdsi.Pid, mdsi.Uid, mdsi.Gid, strings.Join(addrs, " "), mdsi.Version)
 }
 
 var mdsUid = -1
@@ -108,20 +117,31 @@ func VerifyMetadataToken(id uuid.UUID, received string) bool {
 }
 
 // MetadataURL returns URL of the metadata service for pod with
-// provided UUID.
+// provided UUID. Host's IPv4 address is preferred, if it has one.
 func (h *Host) MetadataURL(id uuid.UUID) (string, error) {
-	if hostip, _, err := h.HostIP(); err != nil {
+	ip4, ip6, err := h.HostIPs()
+	if err != nil {
 		return "", errors.Trace(err)
-	} else {
-		url := fmt.Sprintf("http://%v", hostip)
-		if mdport := Config().MustGetInt("mds.port"); mdport != 80 {
-			url = fmt.Sprintf("%v:%v", url, mdport)
-		}
-		if token := MetadataToken(id); token != "" {
-			url = fmt.Sprintf("%v/~%v", url, token)
-		}
-		return url, nil
 	}
+	hostip := ip4
+	if hostip == nil {
+		hostip = ip6
+	}
+	return metadataURL(hostip, Config().MustGetInt("mds.port"), MetadataToken(id)), nil
+}
+
+func metadataURL(hostip net.IP, port int, token string) string {
+	host := hostip.String()
+	if port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if hostip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	url := "http://" + host
+	if token != "" {
+		url = fmt.Sprintf("%v/~%v", url, token)
+	}
+	return url
 }
 
 func (h *Host) GetMDSInfo() (*MDSInfo, error) {
@@ -175,11 +195,16 @@ func (h *Host) validateMDSInfo(mdsi *MDSInfo) error {
 		return errors.Errorf("Port mismatch: expected %d, got %d", port, mdsi.Port)
 	}
 
-	if hostip, _, err := h.HostIP(); err != nil {
+	ip4, ip6, err := h.HostIPs()
+	if err != nil {
 		return errors.Trace(err)
-	} else if ip := hostip.String(); mdsi.IP != ip {
+	}
+	if ip := ipString(ip4); mdsi.IP != ip {
 		return errors.Errorf("IP mismatch: expected %v, got %v", ip, mdsi.IP)
 	}
+	if ip := ipString(ip6); mdsi.IP6 != ip {
+		return errors.Errorf("IPv6 mismatch: expected %v, got %v", ip, mdsi.IP6)
+	}
 
 	return nil
 }
@@ -0,0 +1,262 @@
+package jetpack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/hashicorp/go-multierror"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+// appMount is a volume mount in app's rootfs, resolved from the pod
+// manifest and app's mount points.
+type appMount struct {
+	Volume *types.Volume
+	// Absolute, clean path inside app's rootfs
+	Path     string
+	ReadOnly bool
+}
+
+// Host volumes are mounted recursively, unless their recursive field
+// is false.
+func volumeRecursive(vol *types.Volume) bool {
+	return vol.Kind == "host" && (vol.Recursive == nil || *vol.Recursive)
+}
+
+// Returns mount points of filesystems mounted below dir, parents
+// before their children, given output of `mount -p`.
+func submountsOf(dir string, mountLines []string) []string {
+	dir = filepath.Clean(dir)
+	var submounts []string
+	for _, line := range mountLines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if mntpnt := filepath.Clean(fields[1]); strings.HasPrefix(mntpnt, dir+"/") {
+			submounts = append(submounts, mntpnt)
+		}
+	}
+	sort.Strings(submounts)
+	return submounts
+}
+
+// Checks pod's volumes. Returns mount points of host filesystems
+// mounted below sources of recursive host volumes.
+func (h *Host) checkVolumes(volumes []types.Volume) (map[types.ACName][]string, error) {
+	var errs error
+	var mountLines []string
+	submounts := make(map[types.ACName][]string)
+	seen := make(map[types.ACName]bool)
+	for i := range volumes {
+		vol := &volumes[i]
+		if seen[vol.Name] {
+			errs = multierror.Append(errs, errors.Errorf("Volume %v: defined more than once", vol.Name))
+			continue
+		}
+		seen[vol.Name] = true
+
+		switch vol.Kind {
+		case "empty":
+			if vol.Mode != nil {
+				if _, err := strconv.ParseUint(*vol.Mode, 8, 32); err != nil {
+					errs = multierror.Append(errs, errors.Errorf("Volume %v: invalid mode %#v", vol.Name, *vol.Mode))
+				}
+			}
+		case "host":
+			if fi, err := os.Stat(vol.Source); err != nil {
+				errs = multierror.Append(errs, errors.Errorf("Volume %v: %v", vol.Name, err))
+				continue
+			} else if !fi.IsDir() {
+				errs = multierror.Append(errs, errors.Errorf("Volume %v: source %v is not a directory", vol.Name, vol.Source))
+				continue
+			}
+			if _, err := h.namedVolume(*vol); err != nil {
+				errs = multierror.Append(errs, errors.Errorf("Volume %v: %v", vol.Name, err))
+				continue
+			}
+			if volumeRecursive(vol) {
+				if mountLines == nil {
+					if lines, err := run.Command("/sbin/mount", "-p").OutputLines(); err != nil {
+						return nil, errors.Trace(err)
+					} else {
+						mountLines = lines
+					}
+				}
+				submounts[vol.Name] = submountsOf(vol.Source, mountLines)
+			}
+		default:
+			errs = multierror.Append(errs, errors.Errorf("Volume %v: unknown kind %#v", vol.Name, vol.Kind))
+		}
+	}
+	return submounts, errs
+}
+
+// Checks that path (absolute and clean) can be used as a mount target
+// in rootfs: each of its existing components needs to be a directory,
+// and not a symlink that could lead outside the rootfs.
+func checkMountTarget(rootfs, path string) error {
+	cur := rootfs
+	for _, elt := range strings.Split(path, "/")[1:] {
+		cur = filepath.Join(cur, elt)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			// Remaining directories will be created
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("%v is a symlink", cur[len(rootfs):])
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("%v is not a directory", cur[len(rootfs):])
+		}
+	}
+	return nil
+}
+
+// Resolves mounts of an app, whose image's filesystem is rootfs.
+// Mount is read-only if either its volume or its mount point is
+// read-only. Every mount point of the app needs to be fulfilled.
+func appMounts(volumes []types.Volume, rtapp *schema.RuntimeApp, app *types.App, rootfs string) ([]appMount, error) {
+	var errs error
+	var mounts []appMount
+	fail := func(format string, args ...interface{}) {
+		errs = multierror.Append(errs, errors.Errorf("App %v: %v", rtapp.Name, fmt.Sprintf(format, args...)))
+	}
+
+	fulfilled := make(map[types.ACName]bool)
+	targets := make(map[string]bool)
+	for _, mnt := range rtapp.Mounts {
+		var vol *types.Volume
+		for i := range volumes {
+			if volumes[i].Name == mnt.Volume {
+				vol = &volumes[i]
+				break
+			}
+		}
+		if vol == nil {
+			fail("mount %v:%v: volume not found", mnt.Volume, mnt.Path)
+			continue
+		}
+
+		m := appMount{Volume: vol, Path: mnt.Path, ReadOnly: vol.ReadOnly != nil && *vol.ReadOnly}
+		if m.Path == "" {
+			fail("mount of volume %v: empty path", mnt.Volume)
+			continue
+		}
+
+		if m.Path[0] != '/' {
+			// Target path is a mount point name
+			if app == nil {
+				fail("invalid mount path %v:%#v: no app, no mount points", mnt.Volume, mnt.Path)
+				continue
+			}
+			name, err := types.NewACName(m.Path)
+			if err != nil {
+				fail("invalid mount path %v:%#v: invalid ACName: %v", mnt.Volume, mnt.Path, err)
+				continue
+			}
+			found := false
+			for _, mntpnt := range app.MountPoints {
+				if *name == mntpnt.Name {
+					m.Path = mntpnt.Path
+					m.ReadOnly = m.ReadOnly || mntpnt.ReadOnly
+					fulfilled[mntpnt.Name] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				fail("mount point %#v not found", mnt.Path)
+				continue
+			}
+		} else if app != nil {
+			// Target path may fulfill a mount point by path
+			for _, mntpnt := range app.MountPoints {
+				if filepath.Clean(mntpnt.Path) == filepath.Clean(m.Path) {
+					m.ReadOnly = m.ReadOnly || mntpnt.ReadOnly
+					fulfilled[mntpnt.Name] = true
+				}
+			}
+		}
+
+		m.Path = filepath.Clean("/" + m.Path)
+		if m.Path == "/" {
+			fail("mount %v:%v: can't mount over rootfs", mnt.Volume, mnt.Path)
+			continue
+		}
+		if targets[m.Path] {
+			fail("mount %v:%v: %v is mounted more than once", mnt.Volume, mnt.Path, m.Path)
+			continue
+		}
+		targets[m.Path] = true
+		if err := checkMountTarget(rootfs, m.Path); err != nil {
+			fail("mount %v:%v: %v", mnt.Volume, mnt.Path, err)
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+
+	if app != nil {
+		for _, mntpnt := range app.MountPoints {
+			if !fulfilled[mntpnt.Name] {
+				fail("mount point %v (%v) is not fulfilled", mntpnt.Name, mntpnt.Path)
+			}
+		}
+	}
+
+	return mounts, errs
+}
+
+func fstabLine(source, target, fstype, opts string, dump int) string {
+	return fmt.Sprintf("%v %v %v %v %d 0\n", source, target, fstype, opts, dump)
+}
+
+func mountOpts(readOnly bool) string {
+	if readOnly {
+		return "ro"
+	}
+	return "rw"
+}
+
+// Returns fstab lines that mount volume at target. Host filesystems
+// mounted below the source of a recursive host volume are mounted
+// at the corresponding places below target.
+func volumeFstab(vol *types.Volume, source, target string, readOnly bool, submounts []string, dump int) []string {
+	lines := []string{fstabLine(source, target, "nullfs", mountOpts(readOnly), dump)}
+	for _, sub := range submounts {
+		lines = append(lines, fstabLine(sub, filepath.Join(target, strings.TrimPrefix(sub, filepath.Clean(vol.Source))), "nullfs", mountOpts(readOnly), dump))
+	}
+	return lines
+}
+
+// Sets mode and ownership of empty volume's directory
+func initEmptyVolume(vol *types.Volume, path string) error {
+	if vol.Mode != nil {
+		mode, err := strconv.ParseUint(*vol.Mode, 8, 32)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid mode %#v", *vol.Mode)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	uid, gid := 0, 0
+	if vol.UID != nil {
+		uid = *vol.UID
+	}
+	if vol.GID != nil {
+		gid = *vol.GID
+	}
+	return errors.Trace(os.Chown(path, uid, gid))
+}
@@ -0,0 +1,167 @@
+package jetpack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestSubmountsOf(t *testing.T) {
+	lines := []string{
+		"zroot/ROOT/default / zfs rw 0 0",
+		"zroot/data /data zfs rw 0 0",
+		"zroot/data/b /data/b zfs rw 0 0",
+		"zroot/data/a /data/a zfs rw 0 0",
+		"zroot/database /database zfs rw 0 0",
+		"devfs /dev devfs rw,multilabel 0 0",
+	}
+	if sub := submountsOf("/data/", lines); !reflect.DeepEqual(sub, []string{"/data/a", "/data/b"}) {
+		t.Errorf("Unexpected submounts: %v", sub)
+	}
+	if sub := submountsOf("/srv", lines); len(sub) != 0 {
+		t.Errorf("Unexpected submounts: %v", sub)
+	}
+}
+
+func TestVolumeFstab(t *testing.T) {
+	tru := true
+	vol := &types.Volume{Name: *types.MustACName("data"), Kind: "host", Source: "/data/", Recursive: &tru}
+	lines := volumeFstab(vol, "/pod/rootfs/vol/data", "/pod/rootfs/0/srv", true, []string{"/data/a", "/data/a/x"}, 1)
+	expected := []string{
+		"/pod/rootfs/vol/data /pod/rootfs/0/srv nullfs ro 1 0\n",
+		"/data/a /pod/rootfs/0/srv/a nullfs ro 1 0\n",
+		"/data/a/x /pod/rootfs/0/srv/a/x nullfs ro 1 0\n",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Unexpected fstab: %#v", lines)
+	}
+}
+
+func TestVolumeRecursive(t *testing.T) {
+	tru, fals := true, false
+	for _, c := range []struct {
+		vol      types.Volume
+		expected bool
+	}{
+		{types.Volume{Kind: "host"}, true},
+		{types.Volume{Kind: "host", Recursive: &tru}, true},
+		{types.Volume{Kind: "host", Recursive: &fals}, false},
+		{types.Volume{Kind: "empty", Recursive: &tru}, false},
+	} {
+		if actual := volumeRecursive(&c.vol); actual != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.vol, c.expected, actual)
+		}
+	}
+}
+
+func mountsTestRootfs(t *testing.T) string {
+	rootfs, err := ioutil.TempDir("", "jetpack-mounts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(rootfs, "var", "db"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(rootfs, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return rootfs
+}
+
+func TestCheckMountTarget(t *testing.T) {
+	rootfs := mountsTestRootfs(t)
+	defer os.RemoveAll(rootfs)
+
+	for _, path := range []string{"/var/db", "/var/db/new/dir", "/new"} {
+		if err := checkMountTarget(rootfs, path); err != nil {
+			t.Errorf("%v: unexpected error: %v", path, err)
+		}
+	}
+	for _, path := range []string{"/file", "/file/x", "/link", "/link/x"} {
+		if err := checkMountTarget(rootfs, path); err == nil {
+			t.Errorf("%v: expected error", path)
+		}
+	}
+}
+
+func TestAppMounts(t *testing.T) {
+	rootfs := mountsTestRootfs(t)
+	defer os.RemoveAll(rootfs)
+
+	tru := true
+	volumes := []types.Volume{
+		{Name: *types.MustACName("db"), Kind: "empty"},
+		{Name: *types.MustACName("conf"), Kind: "host", Source: "/etc", ReadOnly: &tru},
+		{Name: *types.MustACName("cache"), Kind: "empty"},
+	}
+	app := &types.App{MountPoints: []types.MountPoint{
+		{Name: *types.MustACName("db"), Path: "/var/db"},
+		{Name: *types.MustACName("conf"), Path: "/usr/local/etc"},
+		{Name: *types.MustACName("cache"), Path: "/var/cache", ReadOnly: true},
+	}}
+	rtapp := &schema.RuntimeApp{
+		Name: *types.MustACName("app"),
+		Mounts: []schema.Mount{
+			{Volume: *types.MustACName("db"), Path: "db"},
+			{Volume: *types.MustACName("conf"), Path: "conf"},
+			{Volume: *types.MustACName("cache"), Path: "/var/cache/"},
+			{Volume: *types.MustACName("db"), Path: "/srv/../mnt/db"},
+		},
+	}
+
+	// Mount point cache is fulfilled by path
+	mounts, err := appMounts(volumes, rtapp, app, rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		path     string
+		readOnly bool
+	}{
+		{"/var/db", false},
+		{"/usr/local/etc", true}, // volume is read-only
+		{"/var/cache", true},     // mount point is read-only
+		{"/mnt/db", false},
+	}
+	if len(mounts) != len(expected) {
+		t.Fatalf("Expected %d mounts, got %#v", len(expected), mounts)
+	}
+	for i, m := range mounts {
+		if m.Path != expected[i].path || m.ReadOnly != expected[i].readOnly {
+			t.Errorf("Mount %d: expected %v (ro=%v), got %v (ro=%v)", i, expected[i].path, expected[i].readOnly, m.Path, m.ReadOnly)
+		}
+	}
+
+	// All problems are reported at once
+	rtapp.Mounts = []schema.Mount{
+		{Volume: *types.MustACName("db"), Path: "db"},
+		{Volume: *types.MustACName("missing"), Path: "/x"},
+		{Volume: *types.MustACName("cache"), Path: "/link/cache"},
+		{Volume: *types.MustACName("conf"), Path: "nope"},
+		{Volume: *types.MustACName("conf"), Path: "/var/db"},
+	}
+	_, err = appMounts(volumes, rtapp, app, rootfs)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	for _, msg := range []string{
+		"missing:/x: volume not found",
+		"/link is a symlink",
+		`mount point "nope" not found`,
+		"/var/db is mounted more than once",
+		"mount point conf (/usr/local/etc) is not fulfilled",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected %#v in error: %v", msg, err)
+		}
+	}
+}
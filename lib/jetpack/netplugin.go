@@ -0,0 +1,396 @@
+package jetpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+// Commands sent to network plugins during pod's lifecycle
+const (
+	// Pod is being created. Plugin can return addresses that the jail
+	// will be created with (in alias network mode), stored in pod's
+	// jetpack/network/NAME annotation.
+	NetworkCreate = "CREATE"
+	// Pod's jail has been started; Jid is set. Plugin can return
+	// addresses that will be assigned to the running jail.
+	NetworkAdd = "ADD"
+	// Pod's jail has been removed
+	NetworkDel = "DEL"
+	// Pod is being destroyed
+	NetworkDestroy = "DESTROY"
+)
+
+// Pod annotation with comma-separated names of networks that pod is
+// attached to, defaults to jail.networks setting.
+const NetworksAnnotation = "jetpack/networks"
+
+// NetworkRequest is sent as JSON to network plugin's standard input
+type NetworkRequest struct {
+	Command  string
+	Network  string
+	Pod      string
+	JailName string
+	Jid      int `json:",omitempty"`
+	// Requested addresses: addresses that plugin has returned on
+	// CREATE, or addresses allocated to the pod by jetpack. On DEL,
+	// addresses that plugin has returned on ADD, if any.
+	Addresses []string
+	// network.NAME.* settings, except for plugin
+	Config map[string]string `json:",omitempty"`
+}
+
+// NetworkResult is read as JSON from network plugin's standard
+// output. Empty output is the same as empty result.
+type NetworkResult struct {
+	Addresses []string `json:",omitempty"`
+}
+
+// NetworkPlugin sets up pod's networking outside of jetpack
+type NetworkPlugin interface {
+	Call(req *NetworkRequest) (*NetworkResult, error)
+}
+
+// execNetworkPlugin is an external executable, run with the command
+// as its only argument.
+type execNetworkPlugin struct {
+	Path string
+}
+
+func (p execNetworkPlugin) Call(req *NetworkRequest) (*NetworkResult, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	out, err := run.Command(p.Path, req.Command).ReadFrom(bytes.NewReader(reqJSON)).Output()
+	if err != nil {
+		return nil, errors.Annotatef(err, "Network %v: %v", req.Network, req.Command)
+	}
+	res := &NetworkResult{}
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, res); err != nil {
+			return nil, errors.Annotatef(err, "Network %v: %v: invalid result %#v", req.Network, req.Command, string(out))
+		}
+	}
+	return res, nil
+}
+
+// Returns plugin of a network configured with network.NAME.plugin
+func networkPlugin(name string) (NetworkPlugin, error) {
+	path := Config().GetString("network."+name+".plugin", "")
+	if path == "" {
+		return nil, errors.Errorf("Network %v: network.%v.plugin is not set", name, name)
+	}
+	return execNetworkPlugin{Path: path}, nil
+}
+
+func networkConfig(name string) map[string]string {
+	cfg := ConfigPrefix("network." + name + ".")
+	delete(cfg, "plugin")
+	return cfg
+}
+
+// Returns names of networks that pod is attached to
+func podNetworks(pm *schema.PodManifest) []string {
+	networks := Config().GetString("jail.networks", "")
+	if v, ok := pm.Annotations.Get(NetworksAnnotation); ok {
+		networks = v
+	}
+	return strings.FieldsFunc(networks, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+func networkAddressesAnnotation(name string) types.ACIdentifier {
+	return types.ACIdentifier("jetpack/network/" + name)
+}
+
+// Returns addresses that network plugins returned on CREATE, split by
+// family. Addresses can be in jail.conf's "interface|address" form.
+func pluginAddresses(pm *schema.PodManifest) (ip4, ip6 []string) {
+	for _, name := range podNetworks(pm) {
+		v, _ := pm.Annotations.Get(string(networkAddressesAnnotation(name)))
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			ipStr := addr[strings.LastIndex(addr, "|")+1:]
+			if i := strings.Index(ipStr, "/"); i >= 0 {
+				ipStr = ipStr[:i]
+			}
+			if ip := net.ParseIP(ipStr); ip != nil && ip.To4() == nil {
+				ip6 = append(ip6, addr)
+			} else {
+				ip4 = append(ip4, addr)
+			}
+		}
+	}
+	return ip4, ip6
+}
+
+// Returns addresses requested from network plugin: addresses that
+// plugin has returned on CREATE, or pod's own addresses.
+func (pod *Pod) networkAddresses(name string) []string {
+	if v, ok := pod.Manifest.Annotations.Get(string(networkAddressesAnnotation(name))); ok && v != "" {
+		return strings.Split(v, ",")
+	}
+	return pod.IPAddresses()
+}
+
+// Path of the file with addresses that network plugin has returned on
+// ADD. They are kept until DEL.
+func (pod *Pod) addedAddressesPath(name string) string {
+	return pod.Path("network", name)
+}
+
+// Returns addresses that network plugin has returned on ADD
+func (pod *Pod) addedAddresses(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(pod.addedAddressesPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == ',' || r == '\n' }), nil
+}
+
+func (pod *Pod) saveAddedAddresses(name string, addrs []string) error {
+	if err := os.MkdirAll(pod.Path("network"), 0750); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(pod.addedAddressesPath(name), []byte(strings.Join(addrs, ",")+"\n"), 0640))
+}
+
+// Calls network plugins of all pod's networks. On DEL and DESTROY,
+// all plugins are called, even if some fail. Addresses returned on
+// ADD are saved, to be assigned to the running jail by
+// assignAddedAddresses.
+func (pod *Pod) callNetworkPlugins(command string, jid int) error {
+	var errs []string
+	for _, name := range podNetworks(&pod.Manifest) {
+		plugin, err := networkPlugin(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		req := &NetworkRequest{
+			Command:  command,
+			Network:  name,
+			Pod:      pod.UUID.String(),
+			JailName: pod.jailName(),
+			Jid:      jid,
+			Config:   networkConfig(name),
+		}
+		switch command {
+		case NetworkCreate:
+			req.Addresses = pod.IPAddresses()
+		case NetworkDel:
+			if added, err := pod.addedAddresses(name); err != nil {
+				errs = append(errs, err.Error())
+			} else {
+				req.Addresses = added
+			}
+		}
+		if len(req.Addresses) == 0 {
+			req.Addresses = pod.networkAddresses(name)
+		}
+
+		pod.ui.Debugf("Network %v: %v", name, command)
+		res, err := plugin.Call(req)
+		if command == NetworkDel {
+			// Jail is gone, and so are the addresses
+			if err := os.Remove(pod.addedAddressesPath(name)); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+			}
+		}
+		if err != nil {
+			if command == NetworkDel || command == NetworkDestroy {
+				errs = append(errs, err.Error())
+				continue
+			}
+			return errors.Trace(err)
+		}
+		if len(res.Addresses) > 0 {
+			pod.ui.Debugf("Network %v: addresses %v", name, res.Addresses)
+		}
+		switch command {
+		case NetworkCreate:
+			unsetAnnotation(&pod.Manifest.Annotations, networkAddressesAnnotation(name))
+			if len(res.Addresses) > 0 {
+				pod.Manifest.Annotations.Set(networkAddressesAnnotation(name), strings.Join(res.Addresses, ","))
+			}
+		case NetworkAdd:
+			if len(res.Addresses) > 0 {
+				if err := pod.saveAddedAddresses(name, res.Addresses); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Splits address returned by network plugin, in jail.conf's
+// "interface|address[/prefixlen]" form.
+func splitPluginAddress(addr string) (iface string, ip net.IP, prefixLen int, err error) {
+	ipStr := addr
+	if i := strings.LastIndex(addr, "|"); i >= 0 {
+		iface, ipStr = addr[:i], addr[i+1:]
+	}
+	prefixLen = -1
+	if i := strings.Index(ipStr, "/"); i >= 0 {
+		if prefixLen, err = strconv.Atoi(ipStr[i+1:]); err != nil {
+			return "", nil, 0, errors.Errorf("Invalid address %#v", addr)
+		}
+		ipStr = ipStr[:i]
+	}
+	if ip = net.ParseIP(ipStr); ip == nil {
+		return "", nil, 0, errors.Errorf("Invalid address %#v", addr)
+	}
+	if prefixLen < 0 {
+		if ip.To4() != nil {
+			prefixLen = 32
+		} else {
+			prefixLen = 128
+		}
+	}
+	return iface, ip, prefixLen, nil
+}
+
+func addressFamily(ip net.IP) string {
+	if ip.To4() == nil {
+		return "inet6"
+	}
+	return "inet"
+}
+
+// Returns commands that assign addresses added by network plugins to
+// the running jail with the given jid. In a VNET jail, addresses are
+// added to the jail's interface (by default, jail side of the epair).
+// Otherwise, they are added as aliases to host's interface (by
+// default, iface), and the jail's address lists are replaced with
+// current addresses and the added ones.
+func addAddressCommands(jid int, v *vnet, iface string, current, added []string) ([][]string, error) {
+	j := strconv.Itoa(jid)
+	var cmds [][]string
+	ips := map[string][]string{}
+	for _, addr := range current {
+		if _, ip, _, err := splitPluginAddress(addr); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			ips[addressFamily(ip)] = append(ips[addressFamily(ip)], ip.String())
+		}
+	}
+	changed := map[string]bool{}
+	for _, addr := range added {
+		ifc, ip, prefixLen, err := splitPluginAddress(addr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		family := addressFamily(ip)
+		cidr := ip.String() + "/" + strconv.Itoa(prefixLen)
+		if v != nil {
+			if ifc == "" {
+				ifc = v.JailSide()
+			}
+			cmds = append(cmds, []string{"/sbin/ifconfig", "-j", j, ifc, family, cidr, "alias"})
+			continue
+		}
+		if ifc == "" {
+			ifc = iface
+		}
+		cmds = append(cmds, []string{"/sbin/ifconfig", ifc, family, cidr, "alias"})
+		ips[family] = append(ips[family], ip.String())
+		changed[family] = true
+	}
+	if changed["inet"] {
+		cmds = append(cmds, []string{"/usr/sbin/jail", "-m", "jid=" + j, "ip4.addr=" + strings.Join(ips["inet"], ",")})
+	}
+	if changed["inet6"] {
+		cmds = append(cmds, []string{"/usr/sbin/jail", "-m", "jid=" + j, "ip6.addr=" + strings.Join(ips["inet6"], ",")})
+	}
+	return cmds, nil
+}
+
+// Returns commands that remove aliases added to host's interface (by
+// default, iface) for addresses added by network plugins, after the
+// jail has been removed.
+func removeAddressCommands(iface string, added []string) [][]string {
+	var cmds [][]string
+	for _, addr := range added {
+		ifc, ip, _, err := splitPluginAddress(addr)
+		if err != nil {
+			continue
+		}
+		if ifc == "" {
+			ifc = iface
+		}
+		cmds = append(cmds, []string{"/sbin/ifconfig", ifc, addressFamily(ip), ip.String(), "-alias"})
+	}
+	return cmds
+}
+
+// Returns addresses that network plugins have returned on ADD
+func (pod *Pod) allAddedAddresses() ([]string, error) {
+	var added []string
+	for _, name := range podNetworks(&pod.Manifest) {
+		if addrs, err := pod.addedAddresses(name); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			added = append(added, addrs...)
+		}
+	}
+	return added, nil
+}
+
+// Assigns addresses that network plugins have returned on ADD to the
+// running jail
+func (pod *Pod) assignAddedAddresses(jid int, v *vnet) error {
+	added, err := pod.allAddedAddresses()
+	if err != nil || len(added) == 0 {
+		return errors.Trace(err)
+	}
+	pod.ui.Debug("Assigning addresses", strings.Join(added, ", "))
+	current := pod.IPAddresses()
+	pluginIP4, pluginIP6 := pluginAddresses(&pod.Manifest)
+	current = append(append(current, pluginIP4...), pluginIP6...)
+	cmds, err := addAddressCommands(jid, v, Config().MustGetString("jail.interface"), current, added)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(runCommands(cmds))
+}
+
+// Removes host's aliases of addresses that network plugins have
+// returned on ADD, after the jail has been removed. VNET jail's
+// addresses are gone with its epair.
+func (pod *Pod) unassignAddedAddresses(v *vnet) error {
+	if v != nil {
+		return nil
+	}
+	added, err := pod.allAddedAddresses()
+	if err != nil || len(added) == 0 {
+		return errors.Trace(err)
+	}
+	cmds := removeAddressCommands(Config().MustGetString("jail.interface"), added)
+	var errs []string
+	for _, cmd := range cmds {
+		if err := run.Command(cmd[0], cmd[1:]...).Run(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
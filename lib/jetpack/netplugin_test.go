@@ -0,0 +1,207 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+// Fake plugin logs its commands and requests, and returns addresses
+// on CREATE, and on ADD if JETPACK_FAKE_ADDRESSES is set
+const fakeNetworkPlugin = `#!/bin/sh
+set -e
+echo "$1" >> "$(dirname "$0")/commands"
+cat >> "$(dirname "$0")/requests"
+echo >> "$(dirname "$0")/requests"
+case "$1" in
+CREATE) echo '{"Addresses":["em1|10.0.0.5","fd00:1::5"]}' ;;
+ADD) [ "$JETPACK_FAKE_FAIL" = "" ] || exit 1
+     [ "$JETPACK_FAKE_ADDRESSES" = "" ] || echo '{"Addresses":["10.0.0.6"]}' ;;
+esac
+`
+
+func testNetworkPlugin(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jetpack-netplugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "plugin"), []byte(fakeNetworkPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExecNetworkPlugin(t *testing.T) {
+	dir := testNetworkPlugin(t)
+	defer os.RemoveAll(dir)
+
+	plugin := execNetworkPlugin{Path: filepath.Join(dir, "plugin")}
+	req := &NetworkRequest{Command: NetworkCreate, Network: "test", Pod: "x", Addresses: []string{"172.23.0.2"}}
+	res, err := plugin.Call(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Addresses, []string{"em1|10.0.0.5", "fd00:1::5"}) {
+		t.Errorf("Unexpected result: %#v", res)
+	}
+
+	var received NetworkRequest
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "requests")); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(&received, req) {
+		t.Errorf("Plugin received %#v, expected %#v", received, req)
+	}
+
+	// Empty output is an empty result
+	if res, err := plugin.Call(&NetworkRequest{Command: NetworkDel, Network: "test"}); err != nil {
+		t.Error(err)
+	} else if len(res.Addresses) != 0 {
+		t.Errorf("Unexpected result: %#v", res)
+	}
+
+	os.Setenv("JETPACK_FAKE_FAIL", "1")
+	defer os.Unsetenv("JETPACK_FAKE_FAIL")
+	if _, err := plugin.Call(&NetworkRequest{Command: NetworkAdd, Network: "test"}); err == nil {
+		t.Error("Expected error from failing plugin")
+	}
+}
+
+func TestCallNetworkPlugins(t *testing.T) {
+	dir := testNetworkPlugin(t)
+	defer os.RemoveAll(dir)
+	Config().Set("network.test.plugin", filepath.Join(dir, "plugin"))
+	Config().Set("network.test.bridge", "bridge1")
+	defer Config().Delete("network.test.plugin")
+	defer Config().Delete("network.test.bridge")
+
+	pod := newPod(&Host{Dataset: &zfs.Dataset{Mountpoint: dir}}, nil)
+	pod.Manifest = *schema.BlankPodManifest()
+	pod.Manifest.Annotations.Set(NetworksAnnotation, "test")
+	pod.Manifest.Annotations.Set(IP4AddressAnnotation, "172.23.0.2")
+
+	if err := pod.callNetworkPlugins(NetworkCreate, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := pod.Manifest.Annotations.Get("jetpack/network/test"); v != "em1|10.0.0.5,fd00:1::5" {
+		t.Errorf("Unexpected addresses annotation: %#v", v)
+	}
+	ip4, ip6 := pluginAddresses(&pod.Manifest)
+	if !reflect.DeepEqual(ip4, []string{"em1|10.0.0.5"}) || !reflect.DeepEqual(ip6, []string{"fd00:1::5"}) {
+		t.Errorf("Unexpected plugin addresses: %v %v", ip4, ip6)
+	}
+
+	if err := pod.callNetworkPlugins(NetworkAdd, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := pod.callNetworkPlugins(NetworkDestroy, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "commands")); err != nil {
+		t.Fatal(err)
+	} else if cmds := strings.Fields(string(data)); !reflect.DeepEqual(cmds, []string{"CREATE", "ADD", "DESTROY"}) {
+		t.Errorf("Unexpected commands: %v", cmds)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "requests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqs []NetworkRequest
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	for dec.More() {
+		var req NetworkRequest
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(reqs))
+	}
+	if !reflect.DeepEqual(reqs[0].Addresses, []string{"172.23.0.2"}) || reqs[0].Config["bridge"] != "bridge1" {
+		t.Errorf("Unexpected CREATE request: %#v", reqs[0])
+	}
+	if reqs[1].Jid != 42 || reqs[1].JailName != pod.jailName() || !reflect.DeepEqual(reqs[1].Addresses, []string{"em1|10.0.0.5", "fd00:1::5"}) {
+		t.Errorf("Unexpected ADD request: %#v", reqs[1])
+	}
+
+	// Addresses returned on ADD are kept until DEL
+	os.Setenv("JETPACK_FAKE_ADDRESSES", "1")
+	defer os.Unsetenv("JETPACK_FAKE_ADDRESSES")
+	if err := pod.callNetworkPlugins(NetworkAdd, 42); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := pod.allAddedAddresses(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(added, []string{"10.0.0.6"}) {
+		t.Errorf("Unexpected added addresses: %v", added)
+	}
+	if err := pod.callNetworkPlugins(NetworkDel, 0); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := pod.allAddedAddresses(); err != nil || len(added) > 0 {
+		t.Errorf("Added addresses not removed on DEL: %v %v", added, err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "requests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var del NetworkRequest
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &del); err != nil {
+		t.Fatal(err)
+	} else if del.Command != NetworkDel || !reflect.DeepEqual(del.Addresses, []string{"10.0.0.6"}) {
+		t.Errorf("Unexpected DEL request: %#v", del)
+	}
+}
+
+func TestAddAddressCommands(t *testing.T) {
+	current := []string{"172.23.0.2", "em1|10.0.0.5", "fd00:23::2"}
+	cmds, err := addAddressCommands(42, nil, "lo1", current, []string{"10.0.0.6", "em1|10.0.1.1/24", "fd00:1::6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"/sbin/ifconfig", "lo1", "inet", "10.0.0.6/32", "alias"},
+		{"/sbin/ifconfig", "em1", "inet", "10.0.1.1/24", "alias"},
+		{"/sbin/ifconfig", "lo1", "inet6", "fd00:1::6/128", "alias"},
+		{"/usr/sbin/jail", "-m", "jid=42", "ip4.addr=172.23.0.2,10.0.0.5,10.0.0.6,10.0.1.1"},
+		{"/usr/sbin/jail", "-m", "jid=42", "ip6.addr=fd00:23::2,fd00:1::6"},
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("Expected %v, got %v", expected, cmds)
+	}
+
+	v := &vnet{Name: "jp1234abcd"}
+	cmds, err = addAddressCommands(42, v, "lo1", current, []string{"10.0.0.6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][]string{{"/sbin/ifconfig", "-j", "42", "jp1234abcdb", "inet", "10.0.0.6/32", "alias"}}; !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("Expected %v, got %v", expected, cmds)
+	}
+
+	if _, err := addAddressCommands(42, nil, "lo1", current, []string{"bogus"}); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+
+	cmds = removeAddressCommands("lo1", []string{"10.0.0.6", "em1|fd00:1::6/64"})
+	expected = [][]string{
+		{"/sbin/ifconfig", "lo1", "inet", "10.0.0.6", "-alias"},
+		{"/sbin/ifconfig", "em1", "inet6", "fd00:1::6", "-alias"},
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("Expected %v, got %v", expected, cmds)
+	}
+}
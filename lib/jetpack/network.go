@@ -0,0 +1,188 @@
+package jetpack
+
+import (
+	"net"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/juju/errors"
+)
+
+// Address families of pods' networking, configured globally with
+// jail.ip-family or for a single pod with jetpack/ip-family annotation.
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+	IPFamilyDual = "dual"
+)
+
+// Network modes, configured globally with jail.network or for a
+// single pod with jetpack/network annotation. In alias mode, pods'
+// addresses are aliases on jail.interface; in VNET mode, each pod has
+// its own network stack, connected to a bridge with an epair.
+const (
+	NetworkModeAlias = "alias"
+	NetworkModeVNET  = "vnet"
+
+	NetworkModeAnnotation = "jetpack/network"
+)
+
+// Returns pod's network mode
+func podNetworkMode(pm *schema.PodManifest) (string, error) {
+	mode := Config().GetString("jail.network", NetworkModeAlias)
+	if v, ok := pm.Annotations.Get(NetworkModeAnnotation); ok {
+		mode = v
+	}
+	switch mode {
+	case NetworkModeAlias, NetworkModeVNET:
+		return mode, nil
+	default:
+		return "", errors.Errorf("Invalid network mode %#v (expected %v or %v)", mode, NetworkModeAlias, NetworkModeVNET)
+	}
+}
+
+// Pod annotations that hold pod's addresses
+const (
+	IP4AddressAnnotation = "ip-address"
+	IP6AddressAnnotation = "ip6-address"
+
+	// Comma-separated static addresses requested for the pod, at most
+	// one for each address family
+	StaticIPAnnotation = "jetpack/ip-address"
+)
+
+// Returns whether pod should get an IPv4 and an IPv6 address
+func podIPFamily(pm *schema.PodManifest) (v4, v6 bool, err error) {
+	family := Config().GetString("jail.ip-family", IPFamilyIPv4)
+	if v, ok := pm.Annotations.Get("jetpack/ip-family"); ok {
+		family = v
+	}
+	switch family {
+	case IPFamilyIPv4:
+		return true, false, nil
+	case IPFamilyIPv6:
+		return false, true, nil
+	case IPFamilyDual:
+		return true, true, nil
+	default:
+		return false, false, errors.Errorf("Invalid IP family %#v (expected %v, %v, or %v)",
+			family, IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual)
+	}
+}
+
+// Returns static addresses requested for the pod, indexed by address
+// family (0 for IPv4, 1 for IPv6). Families that pod doesn't use
+// can't have a static address.
+func podStaticIPs(pm *schema.PodManifest, v4, v6 bool) ([2]net.IP, error) {
+	var static [2]net.IP
+	v, ok := pm.Annotations.Get(StaticIPAnnotation)
+	if !ok {
+		return static, nil
+	}
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return static, errors.Errorf("Invalid static IP address %#v", s)
+		}
+		family, use := 0, v4
+		if ip.To4() == nil {
+			family, use = 1, v6
+		} else {
+			ip = ip.To4()
+		}
+		if !use {
+			return static, errors.Errorf("Static IP address %v: pod doesn't use its address family", ip)
+		}
+		if static[family] != nil {
+			return static, errors.Errorf("More than one static IP address of the same family: %v, %v", static[family], ip)
+		}
+		static[family] = ip
+	}
+	return static, nil
+}
+
+// Returns first address of given family from interface's addresses.
+// IPv6 link-local addresses are skipped, since pods' addresses are
+// allocated from address's prefix.
+func interfaceAddr(addrs []net.Addr, v6 bool) (net.IP, *net.IPNet, error) {
+	for _, addr := range addrs {
+		ip, ipnet, err := net.ParseCIDR(addr.String())
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if (ip.To4() == nil) != v6 || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if !v6 {
+			ip = ip.To4()
+		}
+		return ip, ipnet, nil
+	}
+	return nil, nil, ErrNotFound
+}
+
+func (h *Host) interfaceAddr(v6 bool) (net.IP, *net.IPNet, error) {
+	iface := Config().MustGetString("jail.interface")
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	ip, ipnet, err := interfaceAddr(addrs, v6)
+	if err == ErrNotFound {
+		family := "IPv4"
+		if v6 {
+			family = "IPv6"
+		}
+		return nil, nil, errors.Errorf("Interface %v has no %v address", iface, family)
+	}
+	return ip, ipnet, errors.Trace(err)
+}
+
+// HostIP returns host's IPv4 address on jail.interface, and its network
+func (h *Host) HostIP() (net.IP, *net.IPNet, error) {
+	return h.interfaceAddr(false)
+}
+
+// HostIP6 returns host's global IPv6 address on jail.interface, and
+// its prefix
+func (h *Host) HostIP6() (net.IP, *net.IPNet, error) {
+	return h.interfaceAddr(true)
+}
+
+// HostIPs returns host's IPv4 and IPv6 addresses on jail.interface.
+// One of them may be nil, but not both.
+func (h *Host) HostIPs() (net.IP, net.IP, error) {
+	ip4, _, err4 := h.HostIP()
+	ip6, _, err6 := h.HostIP6()
+	if ip4 == nil && ip6 == nil {
+		return nil, nil, errors.Errorf("No usable address on jail interface: %v; %v", err4, err6)
+	}
+	return ip4, ip6, nil
+}
+
+// Returns string representation of ip, or empty string if ip is nil
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// IPAddresses returns pod's IPv4 and IPv6 addresses, in this order,
+// skipping families that the pod doesn't use.
+func (pod *Pod) IPAddresses() []string {
+	var ips []string
+	for _, name := range []string{IP4AddressAnnotation, IP6AddressAnnotation} {
+		if ip, ok := pod.Manifest.Annotations.Get(name); ok {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,111 @@
+package jetpack
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema"
+)
+
+type testAddr string
+
+func (a testAddr) Network() string { return "ip+net" }
+func (a testAddr) String() string  { return string(a) }
+
+func TestInterfaceAddr(t *testing.T) {
+	addrs := []net.Addr{
+		testAddr("fe80::1/64"),
+		testAddr("fd00:23::1/64"),
+		testAddr("172.23.0.1/16"),
+		testAddr("172.24.0.1/16"),
+	}
+	if ip, ipnet, err := interfaceAddr(addrs, false); err != nil {
+		t.Error(err)
+	} else if ip.String() != "172.23.0.1" || ipnet.String() != "172.23.0.0/16" {
+		t.Errorf("Unexpected IPv4 address: %v %v", ip, ipnet)
+	}
+	if ip, ipnet, err := interfaceAddr(addrs, true); err != nil {
+		t.Error(err)
+	} else if ip.String() != "fd00:23::1" || ipnet.String() != "fd00:23::/64" {
+		t.Errorf("Unexpected IPv6 address: %v %v", ip, ipnet)
+	}
+	if _, _, err := interfaceAddr(addrs[:1], true); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for link-local only, got %v", err)
+	}
+}
+
+func TestPodIPFamily(t *testing.T) {
+	for family, expected := range map[string][2]bool{
+		IPFamilyIPv4: {true, false},
+		IPFamilyIPv6: {false, true},
+		IPFamilyDual: {true, true},
+	} {
+		pm := schema.BlankPodManifest()
+		pm.Annotations.Set("jetpack/ip-family", family)
+		if v4, v6, err := podIPFamily(pm); err != nil {
+			t.Errorf("%v: unexpected error: %v", family, err)
+		} else if v4 != expected[0] || v6 != expected[1] {
+			t.Errorf("%v: expected %v, got %v %v", family, expected, v4, v6)
+		}
+	}
+
+	pm := schema.BlankPodManifest()
+	pm.Annotations.Set("jetpack/ip-family", "ipx")
+	if _, _, err := podIPFamily(pm); err == nil {
+		t.Error("Expected error for invalid IP family")
+	}
+}
+
+func TestPodStaticIPs(t *testing.T) {
+	pm := schema.BlankPodManifest()
+	pm.Annotations.Set(StaticIPAnnotation, "fd00:23::10, 172.23.0.10")
+	if static, err := podStaticIPs(pm, true, true); err != nil {
+		t.Error(err)
+	} else if static[0].String() != "172.23.0.10" || static[1].String() != "fd00:23::10" {
+		t.Errorf("Unexpected static addresses: %v", static)
+	}
+
+	for value, v6 := range map[string]bool{
+		"fd00:23::10":             false, // pod doesn't use IPv6
+		"172.23.0.10,172.23.0.11": true,
+		"nope":                    true,
+	} {
+		pm.Annotations.Set(StaticIPAnnotation, value)
+		if _, err := podStaticIPs(pm, true, v6); err == nil {
+			t.Errorf("%v: expected error", value)
+		}
+	}
+}
+
+func TestPodIPAddresses(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	pod.Manifest.Annotations.Set(IP6AddressAnnotation, "fd00:23::2")
+	pod.Manifest.Annotations.Set(IP4AddressAnnotation, "172.23.0.2")
+	if ips := pod.IPAddresses(); !reflect.DeepEqual(ips, []string{"172.23.0.2", "fd00:23::2"}) {
+		t.Errorf("Unexpected addresses: %v", ips)
+	}
+
+	unsetAnnotation(&pod.Manifest.Annotations, IP4AddressAnnotation)
+	if ips := pod.IPAddresses(); !reflect.DeepEqual(ips, []string{"fd00:23::2"}) {
+		t.Errorf("Unexpected addresses: %v", ips)
+	}
+}
+
+func TestMetadataURL(t *testing.T) {
+	for _, c := range []struct {
+		ip       string
+		port     int
+		token    string
+		expected string
+	}{
+		{"172.23.0.1", 1104, "", "http://172.23.0.1:1104"},
+		{"172.23.0.1", 80, "abc", "http://172.23.0.1/~abc"},
+		{"fd00:23::1", 1104, "abc", "http://[fd00:23::1]:1104/~abc"},
+		{"fd00:23::1", 80, "", "http://[fd00:23::1]"},
+	} {
+		if actual := metadataURL(net.ParseIP(c.ip), c.port, c.token); actual != c.expected {
+			t.Errorf("Expected %v, got %v", c.expected, actual)
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/juju/errors"
 	"github.com/pborman/uuid"
 
+	"github.com/3ofcoins/jetpack/lib/applog"
 	"github.com/3ofcoins/jetpack/lib/drain"
 	"github.com/3ofcoins/jetpack/lib/run"
 	"github.com/3ofcoins/jetpack/lib/ui"
@@ -34,13 +35,21 @@ const (
 	PodStatusRunning
 	PodStatusDying
 	PodStatusStopped
+	PodStatusPrepared
+	PodStatusExited
+	PodStatusFailed
+	PodStatusKilled
 )
 
 var podStatusNames = []string{
-	PodStatusInvalid: "invalid",
-	PodStatusRunning: "running",
-	PodStatusDying:   "dying",
-	PodStatusStopped: "stopped",
+	PodStatusInvalid:  "invalid",
+	PodStatusRunning:  "running",
+	PodStatusDying:    "dying",
+	PodStatusStopped:  "stopped",
+	PodStatusPrepared: "prepared",
+	PodStatusExited:   "exited",
+	PodStatusFailed:   "failed",
+	PodStatusKilled:   "killed",
 }
 
 func (cs PodStatus) String() string {
@@ -55,9 +64,19 @@ type Pod struct {
 	Host     *Host
 	Manifest schema.PodManifest
 
-	sealed bool
-	ui     *ui.UI
-	jailMx sync.Mutex
+	// If set, overrides restart policy of all pod's apps
+	RestartPolicy *RestartPolicy
+
+	// Format of output printed by Run (LogFormatText or LogFormatJSON)
+	LogFormat string
+
+	// If set, Run kills remaining apps when a required app fails
+	FailFast bool
+
+	sealed  bool
+	ui      *ui.UI
+	jailMx  sync.Mutex
+	stateMx sync.Mutex
 }
 
 func newPod(h *Host, id uuid.UUID) *Pod {
@@ -71,16 +90,127 @@ func newPod(h *Host, id uuid.UUID) *Pod {
 	}
 }
 
-func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
+// podDatasets creates pod's volume and app rootfs datasets
+type podDatasets interface {
+	// Creates dataset for i-th volume (of "empty" kind), mounted at mountpoint
+	createVolume(ds *zfs.Dataset, i int, mountpoint string) (*zfs.Dataset, error)
+	// Creates dataset for i-th app's rootfs, mounted at mountpoint,
+	// with a "parent" snapshot of the image's filesystem
+	createRootfs(ds *zfs.Dataset, i int, img *Image, mountpoint string) (*zfs.Dataset, error)
+}
+
+// Creates fresh pod's datasets: empty volumes and clones of images
+type newPodDatasets struct{}
+
+func (newPodDatasets) createVolume(ds *zfs.Dataset, i int, mountpoint string) (*zfs.Dataset, error) {
+	return ds.CreateDataset(fmt.Sprintf("volume.%v", i), "-omountpoint="+mountpoint)
+}
+
+func (newPodDatasets) createRootfs(ds *zfs.Dataset, i int, img *Image, mountpoint string) (*zfs.Dataset, error) {
+	rootds, err := img.Clone(ds.ChildName(fmt.Sprintf("rootfs.%v", i)), mountpoint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := rootds.Snapshot("parent"); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return rootds, nil
+}
+
+func CreatePod(h *Host, pm *schema.PodManifest) (*Pod, error) {
+	return createPod(h, pm, nil, newPodDatasets{})
+}
+
+func createPod(h *Host, pm *schema.PodManifest, id uuid.UUID, pds podDatasets) (pod *Pod, rErr error) {
 	if pm == nil {
 		return nil, errors.New("Pod manifest is nil")
 	}
 	if len(pm.Apps) == 0 {
 		return nil, errors.New("Pod manifest has no apps")
 	}
-	pod = newPod(h, nil)
+	pod = newPod(h, id)
 	pod.Manifest = *pm
 
+	if v4, v6, err := podIPFamily(&pod.Manifest); err != nil {
+		return nil, errors.Trace(err)
+	} else if _, err := podStaticIPs(&pod.Manifest, v4, v6); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Network mode and networks are fixed when the pod is created,
+	// since jail.conf depends on them
+	if mode, err := podNetworkMode(&pod.Manifest); err != nil {
+		return nil, errors.Trace(err)
+	} else {
+		if mode == NetworkModeVNET {
+			if err := checkVnetBridge(); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		pod.Manifest.Annotations.Set(NetworkModeAnnotation, mode)
+	}
+	if networks := podNetworks(&pod.Manifest); len(networks) > 0 {
+		for _, name := range networks {
+			if _, err := types.NewACIdentifier(string(networkAddressesAnnotation(name))); err != nil {
+				return nil, errors.Errorf("Invalid network name %#v", name)
+			}
+			if _, err := networkPlugin(name); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		pod.Manifest.Annotations.Set(NetworksAnnotation, strings.Join(networks, ","))
+	}
+
+	resolvConfMode, err := podResolvConfMode(&pod.Manifest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := podHostsMode(&pod.Manifest); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Fail early if we can't enforce the isolators
+	if _, err := pod.rctlRules(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if mappings, err := pod.PortMappings(); err != nil {
+		return nil, errors.Trace(err)
+	} else if err := h.checkPortConflicts(pod.ID(), mappings); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Check volumes and mounts before anything is created, and report
+	// all problems at once
+	imgs := make([]*Image, len(pod.Manifest.Apps))
+	mounts := make([][]appMount, len(pod.Manifest.Apps))
+	submounts, errs := h.checkVolumes(pod.Manifest.Volumes)
+	for i := range pod.Manifest.Apps {
+		rtApp := &pod.Manifest.Apps[i]
+		img, err := h.getRuntimeImage(rtApp.Image)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Annotatef(err, "App %v: image %v", rtApp.Name, rtApp.Image.ID))
+			continue
+		}
+		imgs[i] = img
+		app := rtApp.App
+		if app == nil {
+			app = img.Manifest.App
+		}
+		if mm, err := appMounts(pod.Manifest.Volumes, rtApp, app, img.getRootfs().Mountpoint); err != nil {
+			errs = multierror.Append(errs, err)
+		} else {
+			mounts[i] = mm
+		}
+	}
+	if errs != nil {
+		return nil, errors.Trace(errs)
+	}
+
+	if _, err := pod.appStartOrder(pod.Apps()); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	pod.ui.Debug("Initializing dataset")
 	ds, err := h.Dataset.CreateDataset(path.Join("pods", pod.UUID.String()))
 	if err != nil {
@@ -113,42 +243,35 @@ func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
 
 	var fstab []string
 
-	if len(pod.Manifest.Volumes) > 0 {
-		for i, vol := range pod.Manifest.Volumes {
-			volPath := ds.Path("rootfs", "vol", vol.Name.String())
-			if err := os.MkdirAll(volPath, 0755); err != nil {
+	for i := range pod.Manifest.Volumes {
+		vol := &pod.Manifest.Volumes[i]
+		volPath := ds.Path("rootfs", "vol", vol.Name.String())
+		if err := os.MkdirAll(volPath, 0755); err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch vol.Kind {
+		case "empty":
+			pod.ui.Debugf("Creating volume.%v for volume %v", i, vol.Name)
+			if volds, err := pds.createVolume(ds, i, volPath); err != nil {
+				return nil, errors.Trace(err)
+			} else if err := volds.Set("jetpack:name", string(vol.Name)); err != nil {
 				return nil, errors.Trace(err)
 			}
-			switch vol.Kind {
-			case "empty":
-				pod.ui.Debugf("Creating volume.%v for volume %v", i, vol.Name)
-				if volds, err := ds.CreateDataset(fmt.Sprintf("volume.%v", i), "-omountpoint="+volPath); err != nil {
-					return nil, errors.Trace(err)
-				} else if err := volds.Set("jetpack:name", string(vol.Name)); err != nil {
-					return nil, errors.Trace(err)
-				}
-			case "host":
-				opts := "rw"
-				if vol.ReadOnly != nil && *vol.ReadOnly {
-					opts = "ro"
-				}
-				fstab = append(fstab, fmt.Sprintf("%v %v nullfs %v 0 0\n",
-					vol.Source, volPath, opts))
-			default:
-				return nil, errors.Errorf("Unknown volume kind: %v", vol.Kind)
+			if err := initEmptyVolume(vol, volPath); err != nil {
+				return nil, errors.Annotatef(err, "Volume %v", vol.Name)
 			}
+		case "host":
+			fstab = append(fstab, volumeFstab(vol, vol.Source, volPath,
+				vol.ReadOnly != nil && *vol.ReadOnly, submounts[vol.Name], 0)...)
 		}
 	}
 
 	for i, rtApp := range pod.Manifest.Apps {
 		pod.ui.Debugf("Cloning rootfs.%d for app %v", i, rtApp.Name)
-		img, err := h.getRuntimeImage(rtApp.Image)
-		if err != nil {
-			return nil, errors.Annotate(err, rtApp.Image.ID.String())
-		}
+		img := imgs[i]
 
 		appRootfs := ds.Path("rootfs", strconv.Itoa(i))
-		rootds, err := img.Clone(ds.ChildName(fmt.Sprintf("rootfs.%v", i)), appRootfs)
+		rootds, err := pds.createRootfs(ds, i, img, appRootfs)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -157,10 +280,6 @@ func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
 			return nil, errors.Trace(err)
 		}
 
-		if _, err := rootds.Snapshot("parent"); err != nil {
-			return nil, errors.Trace(err)
-		}
-
 		if err := os.Mkdir(ds.Path("rootfs", "app", rtApp.Name.String()), 0755); err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -172,11 +291,6 @@ func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
 			return nil, errors.Trace(err)
 		}
 
-		app := rtApp.App
-		if app == nil {
-			app = img.Manifest.App
-		}
-
 		// TODO: way to disable auto-devfs? Custom ruleset?
 		if err := os.Mkdir(filepath.Join(appRootfs, "dev"), 0555); err != nil && !os.IsExist(err) {
 			return nil, errors.Trace(err)
@@ -198,47 +312,27 @@ func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
 			fstab = append(fstab, fmt.Sprintf("linsys %v linsysfs  rw 0 0\n", filepath.Join(appRootfs, "sys")))
 		}
 
-		for _, mnt := range rtApp.Mounts {
-			path := mnt.Path
-			readOnly := false
-
-			if path[0] != '/' {
-				// Target path is a mount point name
-				if app == nil {
-					return nil, errors.Errorf("Invalid mount path %v:%#v: no app, no mount points", mnt.Volume, mnt.Path)
-				}
-				if name, err := types.NewACName(path); err != nil {
-					return nil, errors.Errorf("Invalid mount path %v:%#v: invalid ACName: %v", mnt.Volume, mnt.Path, err)
-				} else {
-					found := false
-					for _, mntpnt := range app.MountPoints {
-						if *name == mntpnt.Name {
-							path = mntpnt.Path
-							readOnly = mntpnt.ReadOnly
-							found = true
-							break
-						}
-					}
-					if !found {
-						return nil, errors.Errorf("Mount point %#v not found", mnt.Path)
-					}
-				}
-			} // TODO: (else?) { verify that target path exists }
-
-			path = filepath.Join(appRootfs, path)
-			if err := os.MkdirAll(path, 0755); err != nil && !os.IsExist(err) {
+		for _, m := range mounts[i] {
+			// Check again, as imported pod's rootfs may differ from image
+			if err := checkMountTarget(appRootfs, m.Path); err != nil {
+				return nil, errors.Annotatef(err, "App %v: mount %v", rtApp.Name, m.Path)
+			}
+			target := filepath.Join(appRootfs, m.Path)
+			if err := os.MkdirAll(target, 0755); err != nil && !os.IsExist(err) {
 				return nil, errors.Trace(err)
 			}
+			fstab = append(fstab, volumeFstab(m.Volume, ds.Path("rootfs", "vol", m.Volume.Name.String()),
+				target, m.ReadOnly, submounts[m.Volume.Name], 1)...)
+		}
 
-			opts := "rw"
-			if readOnly {
-				opts = "ro"
+		if resolvConfMode == ResolvConfHost {
+			if lines, err := pod.resolvConfFstab(rtApp.Name, appRootfs); err != nil {
+				return nil, errors.Annotatef(err, "App %v: resolv.conf", rtApp.Name)
+			} else {
+				fstab = append(fstab, lines...)
 			}
-			fstab = append(fstab, fmt.Sprintf("%v %v nullfs %v 1 0\n",
-				ds.Path("rootfs", "vol", mnt.Volume.String()), path, opts))
 		}
 
-		// TODO: verify app's unfulfilled mount points
 		// TODO: auto-mount mount points if volume of the same name exists?
 	}
 
@@ -246,18 +340,37 @@ func CreatePod(h *Host, pm *schema.PodManifest) (pod *Pod, rErr error) {
 		return nil, errors.Trace(err)
 	}
 
-	// FIXME: smarter IP allocation?
-	if ip, err := h.nextIP(); err != nil {
+	if err := h.leaseIPs(pod.UUID, &pod.Manifest); err != nil {
 		return nil, errors.Trace(err)
-	} else {
-		pod.ui.Debug("Using IP", ip)
-		pod.Manifest.Annotations.Set("ip-address", ip.String())
 	}
+	defer func() {
+		if rErr != nil {
+			h.releaseIPs(pod.UUID)
+		}
+	}()
+	pod.ui.Debug("Using IP", strings.Join(pod.IPAddresses(), ", "))
+
+	if err := pod.callNetworkPlugins(NetworkCreate, 0); err != nil {
+		pod.callNetworkPlugins(NetworkDestroy, 0)
+		return nil, errors.Trace(err)
+	}
+	defer func() {
+		if rErr != nil {
+			pod.callNetworkPlugins(NetworkDestroy, 0)
+		}
+	}()
 
 	if err := ioutil.WriteFile(pod.Path("jail.conf"), []byte(pod.jailConf()), 0400); err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	now := time.Now()
+	for _, rtApp := range pod.Manifest.Apps {
+		if err := pod.saveAppState(rtApp.Name, &AppState{Status: AppStatusPrepared, Created: now}); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
 	pod.ui.Debug("Saving manifest")
 	if manifestJSON, err := json.Marshal(pod.Manifest); err != nil {
 		return nil, errors.Trace(err)
@@ -285,6 +398,15 @@ func (pod *Pod) ID() string {
 	return pod.UUID.String()
 }
 
+// Name returns pod's hostname annotation, or its UUID if hostname is
+// not set.
+func (pod *Pod) Name() string {
+	if hostname, ok := pod.Manifest.Annotations.Get("hostname"); ok {
+		return hostname
+	}
+	return pod.UUID.String()
+}
+
 func (pod *Pod) Path(elem ...string) string {
 	return pod.Host.Path(append(
 		[]string{"pods", pod.UUID.String()},
@@ -333,10 +455,6 @@ func (pod *Pod) Load() error {
 		return errors.Errorf("No application set?")
 	}
 
-	if len(pod.Manifest.Isolators) != 0 {
-		return errors.Errorf("TODO: isolators are not supported")
-	}
-
 	pod.sealed = true
 	return nil
 }
@@ -345,7 +463,6 @@ func (pod *Pod) jailConf() string {
 	parameters := map[string]string{
 		"exec.clean":    "true",
 		"host.hostuuid": pod.UUID.String(),
-		"interface":     Config().MustGetString("jail.interface"),
 		"path":          pod.Path("rootfs"),
 		"persist":       "true",
 		"mount.fstab":   pod.Path("fstab"),
@@ -355,17 +472,35 @@ func (pod *Pod) jailConf() string {
 		parameters[pk] = pv
 	}
 
-	if hostname, ok := pod.Manifest.Annotations.Get("hostname"); ok {
-		parameters["host.hostname"] = hostname
-	} else {
-		parameters["host.hostname"] = parameters["host.hostuuid"]
-	}
+	parameters["host.hostname"] = pod.Name()
 
-	if ip, ok := pod.Manifest.Annotations.Get("ip-address"); ok {
-		parameters["ip4.addr"] = ip
-	} else {
+	ip4, hasIP4 := pod.Manifest.Annotations.Get(IP4AddressAnnotation)
+	ip6, hasIP6 := pod.Manifest.Annotations.Get(IP6AddressAnnotation)
+	if !hasIP4 && !hasIP6 {
 		panic(fmt.Sprintf("No IP address for pod %v", pod.UUID))
 	}
+	if v, err := pod.vnet(); err != nil {
+		panic(err)
+	} else if v != nil {
+		// Addresses are configured inside the jail
+		parameters["vnet"] = "new"
+		parameters["vnet.interface"] = v.JailSide()
+	} else {
+		parameters["interface"] = Config().MustGetString("jail.interface")
+		pluginIP4, pluginIP6 := pluginAddresses(&pod.Manifest)
+		if hasIP4 {
+			parameters["ip4.addr"] = strings.Join(append([]string{ip4}, pluginIP4...), ",")
+		} else if len(pluginIP4) > 0 {
+			parameters["ip4.addr"] = strings.Join(pluginIP4, ",")
+		} else {
+			parameters["ip4"] = "disable"
+		}
+		if hasIP6 {
+			parameters["ip6.addr"] = strings.Join(append([]string{ip6}, pluginIP6...), ",")
+		} else if len(pluginIP6) > 0 {
+			parameters["ip6.addr"] = strings.Join(pluginIP6, ",")
+		}
+	}
 
 	for _, antn := range pod.Manifest.Annotations {
 		if strings.HasPrefix(string(antn.Name), "jetpack/jail.conf/") {
@@ -383,43 +518,16 @@ func (pod *Pod) jailConf() string {
 }
 
 func (pod *Pod) prepJail() error {
-	for _, app := range pod.Manifest.Apps {
-		etcPath := pod.Path("rootfs", "app", app.Name.String(), "rootfs", "etc")
-		if fi, err := os.Stat(etcPath); err == nil && fi.IsDir() {
-			// TODO: option (isolator?) to prevent creation of resolv.conf
-			if dnsServers, ok := Config().Get("ace.dns-servers"); !ok {
-				// By default, copy /etc/resolv.conf from host
-				if bb, err := ioutil.ReadFile("/etc/resolv.conf"); err != nil {
-					return errors.Trace(err)
-				} else {
-					if err := ioutil.WriteFile(filepath.Join(etcPath, "resolv.conf"), bb, 0644); err != nil {
-						return errors.Trace(err)
-					}
-				}
-			} else if resolvconf, err := os.Create(filepath.Join(etcPath, "resolv.conf")); err != nil {
-				return errors.Trace(err)
-			} else {
-				for _, server := range strings.Fields(dnsServers) {
-					fmt.Fprintln(resolvconf, "nameserver", server)
-				}
-				resolvconf.Close()
-			}
-		}
-	}
-	return nil
+	return errors.Trace(pod.writeEtcFiles())
 }
 
 func (pod *Pod) Status() PodStatus {
-	if status, err := pod.jailStatus(false); err != nil {
+	if jail, err := pod.jailStatus(false); err != nil {
+		panic(err)
+	} else if states, err := pod.AppStates(); err != nil {
 		panic(err)
 	} else {
-		if status == NoJailStatus {
-			return PodStatusStopped
-		}
-		if status.Dying {
-			return PodStatusDying
-		}
-		return PodStatusRunning
+		return podStatus(jail, states)
 	}
 }
 
@@ -435,28 +543,101 @@ func (pod *Pod) runJail(op string) error {
 	return run.Command("jail", "-f", pod.Path("jail.conf"), verbosity, op, pod.jailName()).Run()
 }
 
+// Returns rctl rules enforcing pod's and apps' isolators
+func (pod *Pod) rctlRules() ([]string, error) {
+	appIsolators := make([]types.Isolators, 0, len(pod.Manifest.Apps))
+	for _, app := range pod.Apps() {
+		if app != nil {
+			appIsolators = append(appIsolators, app.app.Isolators)
+		}
+	}
+	return rctlRules(pod.jailName(), pod.Manifest.Isolators, appIsolators)
+}
+
+// Applies resource limits to a freshly created jail
+func (pod *Pod) limitJail() error {
+	if rules, err := pod.rctlRules(); err != nil {
+		return errors.Trace(err)
+	} else if len(rules) > 0 {
+		pod.ui.Debug("Applying resource limits:", rules)
+		return errors.Trace(addRctlRules(pod.Host.Rctl, "jail:"+pod.jailName(), rules))
+	}
+	return nil
+}
+
+// Removes resource limits after the jail is gone
+func (pod *Pod) unlimitJail() error {
+	if rules, err := pod.rctlRules(); err != nil {
+		return errors.Trace(err)
+	} else if len(rules) > 0 {
+		pod.ui.Debug("Removing resource limits")
+		return errors.Trace(pod.Host.Rctl.RemoveRules("jail:" + pod.jailName()))
+	}
+	return nil
+}
+
 func (pod *Pod) Kill() error {
 	pod.ui.Println("Shutting down")
 	spin := ui.NewSpinner("Waiting for jail to die", ui.SuffixElapsed(), nil)
 	defer spin.Finish()
+	removed := false
 retry:
-	switch status := pod.Status(); status {
-	case PodStatusStopped:
+	if status, err := pod.jailStatus(false); err != nil {
+		return errors.Trace(err)
+	} else if status == NoJailStatus {
 		// All's fine
-		return nil
-	case PodStatusRunning:
-		if err := pod.runJail("-r"); err != nil {
-			return errors.Trace(err)
+		if removed {
+			if err := pod.flushPfAnchor(); err != nil {
+				return errors.Trace(err)
+			}
+			if err := pod.unlimitJail(); err != nil {
+				return errors.Trace(err)
+			}
+			vnet, err := pod.vnet()
+			if err != nil {
+				return errors.Trace(err)
+			} else if vnet != nil {
+				if err := pod.destroyVnet(vnet); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			// Jail is gone, record it even if network plugins fail
+			if err := pod.markKilled(); err != nil {
+				return errors.Trace(err)
+			}
+			if err := pod.unassignAddedAddresses(vnet); err != nil {
+				pod.ui.Printf("WARNING: %v", err)
+			}
+			return errors.Trace(pod.callNetworkPlugins(NetworkDel, 0))
 		}
-		goto retry
-	case PodStatusDying:
+		return nil
+	} else if status.Dying {
 		// TODO: UI? Log?
 		spin.Step()
 		time.Sleep(250 * time.Millisecond)
 		goto retry
-	default:
-		return errors.Errorf("Pod is %v, I am confused", status)
+	} else {
+		if err := pod.runJail("-r"); err != nil {
+			return errors.Trace(err)
+		}
+		removed = true
+		goto retry
+	}
+}
+
+// Marks apps that were running when jail was removed as killed
+func (pod *Pod) markKilled() error {
+	for _, rtapp := range pod.Manifest.Apps {
+		if err := pod.updateAppState(rtapp.Name, func(st *AppState) {
+			if st.Status == AppStatusRunning {
+				st.Status = AppStatusKilled
+				st.Finished = time.Now()
+			}
+		}); err != nil {
+			return errors.Trace(err)
+		}
 	}
+	return nil
 }
 
 // FIXME: multi-app pods
@@ -477,13 +658,32 @@ func (pod *Pod) Destroy() error {
 			// FIXME: plow through, ensure it's destroyed
 			return errors.Trace(err)
 		}
+	} else if err := pod.flushPfAnchor(); err != nil {
+		return errors.Trace(err)
+	}
+	// Resource limits may be left over from a crashed run, even if the
+	// jail is not running anymore.
+	if err := pod.unlimitJail(); err != nil {
+		return errors.Trace(err)
 	}
 	if ds := pod.getDataset(); ds != nil {
+		promoted, err := pod.promoteCommittedImages(ds)
+		if err != nil {
+			return errors.Trace(err)
+		}
 		if err := ds.Destroy("-r"); err != nil {
 			return errors.Trace(err)
 		}
+		pod.cleanupPromotedImages(promoted)
+	}
+	if err := pod.callNetworkPlugins(NetworkDestroy, 0); err != nil {
+		// Pod's filesystems are gone already, so plow through
+		pod.ui.Printf("WARNING: %v", err)
 	}
-	return errors.Trace(os.RemoveAll(pod.Path()))
+	if err := os.RemoveAll(pod.Path()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(pod.Host.releaseIPs(pod.UUID))
 }
 
 func (pod *Pod) jailName() string {
@@ -502,26 +702,86 @@ func (pod *Pod) Jid() int {
 	}
 }
 
-// Return jail ID, start jail if necessary.
-func (pod *Pod) ensureJid() int {
+// Return jail ID, start jail if necessary. If starting the jail
+// fails, what has been done so far is undone.
+func (pod *Pod) ensureJid() (_ int, rErr error) {
 	pod.jailMx.Lock()
 	defer pod.jailMx.Unlock()
-	jid := pod.Jid()
-	if jid == 0 {
-		if err := errors.Trace(pod.runJail("-c")); err != nil {
-			panic(err)
+	if jid := pod.Jid(); jid != 0 {
+		return jid, nil
+	}
+
+	vnet, err := pod.vnet()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	var undo []func()
+	defer func() {
+		if rErr != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
 		}
-		jid = pod.Jid()
-		if jid == 0 {
-			panic("Could not start jail")
+	}()
+
+	if vnet != nil {
+		if err := pod.createVnet(vnet); err != nil {
+			return 0, errors.Trace(err)
 		}
+		undo = append(undo, func() { pod.destroyVnet(vnet) })
+	}
+	if err := pod.runJail("-c"); err != nil {
+		return 0, errors.Trace(err)
+	}
+	undo = append(undo, func() { pod.runJail("-r") })
+	status, err := pod.jailStatus(true)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	jid := status.Jid
+	if jid == 0 {
+		return 0, errors.New("Could not start jail")
+	}
+	if vnet != nil {
+		if err := pod.configureVnet(vnet, jid); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	if err := pod.limitJail(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	undo = append(undo, func() { pod.unlimitJail() })
+	if err := pod.loadPfAnchor(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	undo = append(undo, func() { pod.flushPfAnchor() })
+
+	undo = append(undo, func() {
+		pod.unassignAddedAddresses(vnet)
+		pod.callNetworkPlugins(NetworkDel, jid)
+	})
+	if err := pod.callNetworkPlugins(NetworkAdd, jid); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if err := pod.assignAddedAddresses(jid, vnet); err != nil {
+		return 0, errors.Trace(err)
 	}
-	return jid
+	return jid, nil
 }
 
+// MetadataURL returns URL of the metadata service for the pod. Pods
+// without an IPv4 address reach it at host's IPv6 address.
 func (pod *Pod) MetadataURL() (string, error) {
-	mds, err := pod.Host.MetadataURL(pod.UUID)
-	return mds, errors.Trace(err)
+	if _, ok := pod.Manifest.Annotations.Get(IP4AddressAnnotation); ok {
+		mds, err := pod.Host.MetadataURL(pod.UUID)
+		return mds, errors.Trace(err)
+	}
+	hostip, _, err := pod.Host.HostIP6()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return metadataURL(hostip, Config().MustGetInt("mds.port"), MetadataToken(pod.UUID)), nil
 }
 
 func (pod *Pod) App(name types.ACName) *App {
@@ -553,7 +813,9 @@ func (pod *Pod) Apps() []*App {
 }
 
 // Runs all the apps in parallel, with closed stdin & piped/logged
-// stdout and stderr
+// stdout and stderr. If any app that is not optional fails, returns
+// a *PodRunError listing results of all the apps. If pod's FailFast
+// is set, the first such failure kills the remaining apps.
 func (pod *Pod) Run() error {
 	// This is repeated in App.Run(); should we sync.Once it?
 	if _, err := pod.Host.CheckMDS(); err != nil {
@@ -562,37 +824,122 @@ func (pod *Pod) Run() error {
 
 	// Context
 	apps := pod.Apps()
-	prefixes := make(map[*drain.Writer]string)
+	results := make([]AppResult, len(apps))
+	healthChecks := make([]*HealthCheck, len(apps))
+	readiness := make([]*Readiness, len(apps))
+	ready := make(map[types.ACName]*appReady, len(apps))
+	for i, app := range apps {
+		if optional, err := app.Optional(); err != nil {
+			return errors.Trace(err)
+		} else {
+			results[i] = AppResult{Name: app.Name, Optional: optional}
+		}
+		if hc, err := app.HealthCheck(); err != nil {
+			return errors.Trace(err)
+		} else {
+			healthChecks[i] = hc
+		}
+		if r, err := app.Readiness(); err != nil {
+			return errors.Annotatef(err, "App %v", app.Name)
+		} else {
+			readiness[i] = r
+		}
+		ready[app.Name] = &appReady{done: make(chan struct{})}
+		// Stop request and kill of the previous run would keep the app
+		// waiting for its dependencies from starting. Apps are run with
+		// App.run, which keeps kills of this run.
+		app.mx.Lock()
+		app.killed = false
+		app.mx.Unlock()
+		if err := pod.updateAppState(app.Name, func(st *AppState) {
+			st.StopRequested = false
+			st.StopSignal = ""
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if order, err := pod.appStartOrder(apps); err != nil {
+		return errors.Trace(err)
+	} else {
+		pod.ui.Debugf("Start order: %v", order)
+	}
+	sources := make(map[*drain.Writer]LogSource)
+	logs := make(map[*drain.Writer]*applog.Log)
 	writers := make(map[*App][2]*drain.Writer)
 	dr := make(drain.Drain)
 	wg := new(sync.WaitGroup)
 	done := make(chan struct{})
-	errs := make(map[*App]error)
+	var failMx sync.Mutex
+	firstFailed := -1
 
 	// Prepare writers, fill in context
 	for _, app := range apps {
 		stdout := dr.NewWriter()
 		stderr := dr.NewWriter()
-		prefixes[stdout] = fmt.Sprintf("%v:out", app.Name)
-		prefixes[stderr] = fmt.Sprintf("%v:err", app.Name)
+		sources[stdout] = LogSource{app.Name, "out"}
+		sources[stderr] = LogSource{app.Name, "err"}
 		writers[app] = [2]*drain.Writer{stdout, stderr}
+		for i, stream := range LogStreams {
+			if l, err := pod.openLog(app.Name, stream); err != nil {
+				for _, l := range logs {
+					l.Close()
+				}
+				return errors.Trace(err)
+			} else {
+				logs[writers[app][i]] = l
+			}
+		}
+	}
+
+	out, err := pod.newRunOutput(os.Stdout)
+	if err != nil {
+		for _, l := range logs {
+			l.Close()
+		}
+		return errors.Trace(err)
 	}
+	defer out.Close()
 
 	// Output goroutine
 	go func() {
 		for line := range dr {
-			fmt.Printf("%v %v %v\n", line.Timestamp, prefixes[line.Writer], line.Text)
+			out.Line(line, sources[line.Writer])
+			if err := logs[line.Writer].Write(applog.Entry{Timestamp: line.Timestamp, Text: line.Text}); err != nil {
+				pod.ui.Printf("%v: cannot write log: %v", sources[line.Writer], err)
+			}
+		}
+		for _, l := range logs {
+			l.Close()
 		}
 		done <- struct{}{}
 	}()
 
-	// Signal handler
+	// Signal handler: first signal stops the pod gracefully, next ones
+	// kill the apps right away.
 	sigch := make(chan os.Signal, 1)
+	var stopMx sync.Mutex
+	var stopped chan struct{}
 	go func() {
-		if sig := <-sigch; sig != nil {
-			for _, app := range apps {
-				app.Kill()
+		for sig := range sigch {
+			if sig == nil {
+				return
+			}
+			stopMx.Lock()
+			if stopped == nil {
+				stopped = make(chan struct{})
+				go func(stopped chan struct{}) {
+					defer close(stopped)
+					if err := pod.Stop(DefaultStopTimeout()); err != nil {
+						pod.ui.Printf("Cannot stop pod: %v", err)
+					}
+				}(stopped)
+			} else {
+				pod.ui.Printf("%v again, killing apps", sig)
+				for _, app := range apps {
+					app.Kill()
+				}
 			}
+			stopMx.Unlock()
 		}
 	}()
 	signal.Notify(sigch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL, syscall.SIGQUIT)
@@ -603,29 +950,85 @@ func (pod *Pod) Run() error {
 
 	// Start the app goroutines
 	wg.Add(len(apps))
-	for _, app := range apps {
-		go func(app *App) {
+	for i, app := range apps {
+		go func(i int, app *App) {
 			defer wg.Done()
 			defer writers[app][0].Close()
 			defer writers[app][1].Close()
-			if err := app.Run(nil, writers[app][0], writers[app][1]); err != nil {
-				pod.ui.Printf("%v: error: %v", app.Name, err)
-				errs[app] = err
+
+			// Start app after apps it depends on are ready, and let apps
+			// that depend on it know when it's ready
+			var err error
+			if err = app.waitForDependencies(ready); err == nil {
+				exited := make(chan struct{})
+				if r := readiness[i]; r != nil {
+					go func() {
+						rdy := ready[app.Name]
+						if rdy.err = app.waitReady(r, exited); rdy.err != nil {
+							pod.ui.Printf("%v: not ready: %v", app.Name, rdy.err)
+						} else {
+							pod.ui.Debugf("%v: ready", app.Name)
+						}
+						close(rdy.done)
+					}()
+				} else {
+					close(ready[app.Name].done)
+				}
+
+				if hc := healthChecks[i]; hc != nil {
+					stopHealth := make(chan struct{})
+					defer close(stopHealth)
+					go app.monitorHealth(hc, stopHealth)
+				}
+				err = app.run(nil, writers[app][0], writers[app][1])
+				close(exited)
+			} else {
+				rdy := ready[app.Name]
+				rdy.err = errors.New("Not started")
+				close(rdy.done)
+			}
+			results[i].finish(app, err)
+			if err == nil {
+				return
+			}
+			pod.ui.Printf("%v: error: %v", app.Name, err)
+			if results[i].Optional {
+				return
 			}
-		}(app)
+
+			failMx.Lock()
+			defer failMx.Unlock()
+			if firstFailed >= 0 {
+				return
+			}
+			firstFailed = i
+			if pod.FailFast {
+				pod.ui.Printf("%v: failed, killing remaining apps", app.Name)
+				for _, other := range apps {
+					if other != app {
+						other.Kill()
+					}
+				}
+			}
+		}(i, app)
 	}
 
 	// Wait for the apps to finish
-	// TODO: kill the apps when killed?
 	wg.Wait()
 	close(dr)
 	<-done
 
-	// Collect errors
-	var erv error
-	for app, err := range errs {
-		pod.ui.Printf("AGAIN: %v: error: %v", app.Name, err)
-		erv = multierror.Append(err)
+	// If the pod is being stopped, let it finish
+	stopMx.Lock()
+	if stopped != nil {
+		<-stopped
+	}
+	stopMx.Unlock()
+
+	if firstFailed < 0 {
+		return nil
 	}
+	erv := &PodRunError{Apps: results, first: firstFailed}
+	pod.ui.Println(erv)
 	return erv
 }
@@ -0,0 +1,181 @@
+package jetpack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+// PortMapping is a pod's exposed port resolved to the app port it
+// redirects to.
+type PortMapping struct {
+	App      types.ACName
+	Name     types.ACName
+	Protocol string
+	HostPort uint
+	PodPort  uint
+	Count    uint
+}
+
+func (pm PortMapping) String() string {
+	rv := fmt.Sprintf("%d->%v:%v/%d", pm.HostPort, pm.App, pm.Name, pm.PodPort)
+	if pm.Count > 1 {
+		rv += fmt.Sprintf("+%d", pm.Count)
+	}
+	if pm.Protocol != "tcp" {
+		rv += "/" + pm.Protocol
+	}
+	return rv
+}
+
+// Returns true if both mappings claim any of the same host ports.
+func (pm PortMapping) Conflicts(other PortMapping) bool {
+	return pm.Protocol == other.Protocol &&
+		pm.HostPort < other.HostPort+other.Count &&
+		other.HostPort < pm.HostPort+pm.Count
+}
+
+func (pm PortMapping) portRange(port uint, star bool) string {
+	switch {
+	case pm.Count == 1:
+		return fmt.Sprint(port)
+	case star:
+		return fmt.Sprintf("%d:*", port)
+	default:
+		return fmt.Sprintf("%d:%d", port, port+pm.Count-1)
+	}
+}
+
+// Resolves exposed ports to ports declared by pod's apps. Exposed
+// port without a host port is exposed on the same port number as the
+// app declares.
+func portMappings(exposed []types.ExposedPort, apps []*App) ([]PortMapping, error) {
+	rv := make([]PortMapping, 0, len(exposed))
+	for _, ep := range exposed {
+		var mapping *PortMapping
+		for _, app := range apps {
+			for _, port := range app.app.Ports {
+				if port.Name != ep.Name {
+					continue
+				}
+				if mapping != nil {
+					return nil, errors.Errorf("Exposed port %v is ambiguous: declared by apps %v and %v", ep.Name, mapping.App, app.Name)
+				}
+				mapping = &PortMapping{
+					App:      app.Name,
+					Name:     port.Name,
+					Protocol: port.Protocol,
+					HostPort: ep.HostPort,
+					PodPort:  port.Port,
+					Count:    port.Count,
+				}
+				if mapping.Count == 0 {
+					mapping.Count = 1
+				}
+				if mapping.HostPort == 0 {
+					mapping.HostPort = mapping.PodPort
+				}
+			}
+		}
+		if mapping == nil {
+			return nil, errors.Errorf("Exposed port %v is not declared by any app", ep.Name)
+		}
+		for _, other := range rv {
+			if mapping.Conflicts(other) {
+				return nil, errors.Errorf("Exposed ports %v and %v use the same host port", other.Name, mapping.Name)
+			}
+		}
+		rv = append(rv, *mapping)
+	}
+	return rv, nil
+}
+
+// Returns pf rdr rules for the mappings. If iface is not empty, rules
+// are limited to this interface.
+func pfRules(iface, ip string, mappings []PortMapping) string {
+	on := ""
+	if iface != "" {
+		on = " on " + iface
+	}
+	if strings.Contains(ip, ":") {
+		on += " inet6"
+	}
+	lines := make([]string, len(mappings))
+	for i, pm := range mappings {
+		lines[i] = fmt.Sprintf("rdr pass%v proto %v from any to any port %v -> %v port %v\n",
+			on, pm.Protocol, pm.portRange(pm.HostPort, false), ip, pm.portRange(pm.PodPort, true))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// PortMappings returns pod's exposed ports resolved to apps' ports.
+func (pod *Pod) PortMappings() ([]PortMapping, error) {
+	if len(pod.Manifest.Ports) == 0 {
+		return nil, nil
+	}
+	pms, err := portMappings(pod.Manifest.Ports, pod.Apps())
+	return pms, errors.Trace(err)
+}
+
+func (pod *Pod) pfAnchor() string {
+	return Config().MustGetString("pf.anchor") + "/" + pod.UUID.String()
+}
+
+// Loads pod's port redirections to its pf anchor
+func (pod *Pod) loadPfAnchor() error {
+	mappings, err := pod.PortMappings()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(mappings) == 0 {
+		return nil
+	}
+	var rules string
+	for _, ip := range pod.IPAddresses() {
+		rules += pfRules(Config().GetString("pf.interface", ""), ip, mappings)
+	}
+	pod.ui.Debugf("Loading pf anchor %v:\n%v", pod.pfAnchor(), rules)
+	return errors.Trace(run.Command("/sbin/pfctl", "-q", "-a", pod.pfAnchor(), "-f", "-").
+		ReadFrom(strings.NewReader(rules)).Run())
+}
+
+// Flushes pod's pf anchor
+func (pod *Pod) flushPfAnchor() error {
+	if len(pod.Manifest.Ports) == 0 {
+		return nil
+	}
+	pod.ui.Debug("Flushing pf anchor", pod.pfAnchor())
+	return errors.Trace(run.Command("/sbin/pfctl", "-q", "-a", pod.pfAnchor(), "-F", "all").Run())
+}
+
+// Returns an error if any port in mappings is already exposed by
+// another pod.
+func (h *Host) checkPortConflicts(id string, mappings []PortMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	for _, pod := range h.Pods() {
+		if pod.ID() == id {
+			continue
+		}
+		others, err := pod.PortMappings()
+		if err != nil {
+			// Broken pod is not our problem right now
+			continue
+		}
+		for _, mapping := range mappings {
+			for _, other := range others {
+				if mapping.Conflicts(other) {
+					return errors.Errorf("Host port %d/%v is already exposed by pod %v", other.HostPort, other.Protocol, pod.UUID)
+				}
+			}
+		}
+	}
+	return nil
+}
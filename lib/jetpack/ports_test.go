@@ -0,0 +1,112 @@
+package jetpack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+var portTestApps = []*App{
+	{Name: "web", app: &types.App{Ports: []types.Port{
+		{Name: "http", Protocol: "tcp", Port: 80},
+		{Name: "rtp", Protocol: "udp", Port: 5004, Count: 4},
+	}}},
+	{Name: "db", app: &types.App{Ports: []types.Port{
+		{Name: "pgsql", Protocol: "tcp", Port: 5432},
+	}}},
+	{Name: "other", app: &types.App{Ports: []types.Port{
+		{Name: "pgsql", Protocol: "tcp", Port: 5433},
+	}}},
+}
+
+func TestPortMappings(t *testing.T) {
+	mappings, err := portMappings(
+		[]types.ExposedPort{{Name: "http", HostPort: 8080}, {Name: "rtp"}},
+		portTestApps)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := []PortMapping{
+		{App: "web", Name: "http", Protocol: "tcp", HostPort: 8080, PodPort: 80, Count: 1},
+		{App: "web", Name: "rtp", Protocol: "udp", HostPort: 5004, PodPort: 5004, Count: 4},
+	}
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, mappings)
+	}
+
+	// Same host port with a different protocol is fine
+	if _, err := portMappings([]types.ExposedPort{{Name: "http", HostPort: 5005}, {Name: "rtp"}}, portTestApps); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	for _, exposed := range [][]types.ExposedPort{
+		{{Name: "nonexistent"}},
+		{{Name: "pgsql"}}, // ambiguous
+		{{Name: "http", HostPort: 8080}, {Name: "http", HostPort: 8080}},
+		{{Name: "rtp"}, {Name: "rtp", HostPort: 5007}},
+	} {
+		if _, err := portMappings(exposed, portTestApps); err == nil {
+			t.Errorf("Expected error for %v", exposed)
+		}
+	}
+}
+
+func TestPortMappingConflicts(t *testing.T) {
+	base := PortMapping{Protocol: "tcp", HostPort: 1000, Count: 10}
+	for other, expected := range map[PortMapping]bool{
+		{Protocol: "tcp", HostPort: 1000, Count: 1}: true,
+		{Protocol: "tcp", HostPort: 1009, Count: 1}: true,
+		{Protocol: "tcp", HostPort: 995, Count: 6}:  true,
+		{Protocol: "tcp", HostPort: 1010, Count: 1}: false,
+		{Protocol: "tcp", HostPort: 990, Count: 10}: false,
+		{Protocol: "udp", HostPort: 1000, Count: 1}: false,
+	} {
+		if actual := base.Conflicts(other); actual != expected {
+			t.Errorf("Expected %v.Conflicts(%v) to be %v", base, other, expected)
+		}
+		if actual := other.Conflicts(base); actual != expected {
+			t.Errorf("Expected %v.Conflicts(%v) to be %v", other, base, expected)
+		}
+	}
+}
+
+func TestPfRules(t *testing.T) {
+	mappings := []PortMapping{
+		{App: "web", Name: "http", Protocol: "tcp", HostPort: 8080, PodPort: 80, Count: 1},
+		{App: "web", Name: "rtp", Protocol: "udp", HostPort: 6004, PodPort: 5004, Count: 4},
+	}
+
+	expected := "rdr pass proto tcp from any to any port 8080 -> 172.23.0.2 port 80\n" +
+		"rdr pass proto udp from any to any port 6004:6007 -> 172.23.0.2 port 5004:*\n"
+	if actual := pfRules("", "172.23.0.2", mappings); actual != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, actual)
+	}
+
+	expected = "rdr pass on em0 proto tcp from any to any port 8080 -> 172.23.0.2 port 80\n" +
+		"rdr pass on em0 proto udp from any to any port 6004:6007 -> 172.23.0.2 port 5004:*\n"
+	if actual := pfRules("em0", "172.23.0.2", mappings); actual != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, actual)
+	}
+
+	expected = "rdr pass on em0 inet6 proto tcp from any to any port 8080 -> fd00:23::2 port 80\n" +
+		"rdr pass on em0 inet6 proto udp from any to any port 6004:6007 -> fd00:23::2 port 5004:*\n"
+	if actual := pfRules("em0", "fd00:23::2", mappings); actual != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, actual)
+	}
+
+	if actual := pfRules("em0", "172.23.0.2", nil); actual != "" {
+		t.Errorf("Expected no rules, got %#v", actual)
+	}
+}
+
+func TestPortMappingString(t *testing.T) {
+	for pm, expected := range map[PortMapping]string{
+		{App: "web", Name: "http", Protocol: "tcp", HostPort: 8080, PodPort: 80, Count: 1}:  "8080->web:http/80",
+		{App: "web", Name: "rtp", Protocol: "udp", HostPort: 6004, PodPort: 5004, Count: 4}: "6004->web:rtp/5004+4/udp",
+	} {
+		if actual := pm.String(); actual != expected {
+			t.Errorf("Expected %#v, got %#v", expected, actual)
+		}
+	}
+}
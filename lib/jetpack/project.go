@@ -0,0 +1,296 @@
+package jetpack
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/acutil"
+)
+
+// Pod annotations that track pods created from a project file
+const (
+	// Name of the project
+	ProjectAnnotation = "jetpack/project"
+	// Name of the pod within the project
+	ProjectPodAnnotation = "jetpack/project/pod"
+	// Hash of pod's definition, to find pods that need to be recreated
+	ProjectHashAnnotation = "jetpack/project/hash"
+)
+
+// Project describes a group of related pods, read from a JSON
+// project file.
+type Project struct {
+	// Defaults to name of the project file's directory
+	Name string       `json:"name,omitempty"`
+	Pods []ProjectPod `json:"pods"`
+}
+
+// ProjectPod describes a single pod of a project. Volumes, ports, and
+// apps' mounts use the same syntax as `jetpack prepare` options.
+type ProjectPod struct {
+	Name        string            `json:"name"`
+	Apps        []ProjectApp      `json:"apps"`
+	Volumes     []string          `json:"volumes,omitempty"`
+	Ports       []string          `json:"ports,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Names of pods that need to be started before this one
+	After []string `json:"after,omitempty"`
+}
+
+// ProjectApp is a single app of a project's pod
+type ProjectApp struct {
+	// Image name (with optional labels) or hash
+	Image string `json:"image"`
+	// Defaults to image's base name
+	Name        string            `json:"name,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Mounts      []string          `json:"mounts,omitempty"`
+}
+
+// LoadProject reads and checks a project file
+func LoadProject(filename string) (*Project, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &Project{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, errors.Annotate(err, filename)
+	}
+	if p.Name == "" {
+		if abs, err := filepath.Abs(filename); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			p.Name = filepath.Base(filepath.Dir(abs))
+		}
+	}
+	if err := p.check(); err != nil {
+		return nil, errors.Annotate(err, filename)
+	}
+	return p, nil
+}
+
+func (p *Project) check() error {
+	if _, err := types.NewACName(p.Name); err != nil {
+		return errors.Annotatef(err, "Invalid project name %#v", p.Name)
+	}
+	seen := make(map[string]bool)
+	for i := range p.Pods {
+		pp := &p.Pods[i]
+		if _, err := types.NewACName(pp.Name); err != nil {
+			return errors.Annotatef(err, "Invalid pod name %#v", pp.Name)
+		}
+		if seen[pp.Name] {
+			return errors.Errorf("Duplicate pod %v", pp.Name)
+		}
+		seen[pp.Name] = true
+		if len(pp.Apps) == 0 {
+			return errors.Errorf("Pod %v has no apps", pp.Name)
+		}
+		if _, err := pp.PodManifest(p.Name); err != nil {
+			return errors.Annotatef(err, "Pod %v", pp.Name)
+		}
+	}
+	_, err := p.StartOrder()
+	return errors.Trace(err)
+}
+
+// Pod returns project's pod with given name, or nil if there's none
+func (p *Project) Pod(name string) *ProjectPod {
+	for i := range p.Pods {
+		if p.Pods[i].Name == name {
+			return &p.Pods[i]
+		}
+	}
+	return nil
+}
+
+// StartOrder returns project's pods in order they should be started:
+// each pod after pods it should be started after, otherwise in order
+// of the project file.
+func (p *Project) StartOrder() ([]*ProjectPod, error) {
+	var order []*ProjectPod
+	// 0: not visited, 1: being visited, 2: done
+	state := make(map[string]int)
+	var visit func(pp *ProjectPod) error
+	visit = func(pp *ProjectPod) error {
+		switch state[pp.Name] {
+		case 1:
+			return errors.Errorf("Pod %v: dependency cycle", pp.Name)
+		case 2:
+			return nil
+		}
+		state[pp.Name] = 1
+		for _, name := range pp.After {
+			dep := p.Pod(name)
+			if dep == nil {
+				return errors.Errorf("Pod %v: unknown pod %v", pp.Name, name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[pp.Name] = 2
+		order = append(order, pp)
+		return nil
+	}
+	for i := range p.Pods {
+		if err := visit(&p.Pods[i]); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Hash identifies pod's definition
+func (pp *ProjectPod) Hash() string {
+	// Maps are marshaled with sorted keys, so this is stable
+	data, err := json.Marshal(pp)
+	if err != nil {
+		// CAN'T HAPPEN
+		panic(err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func setAnnotations(anns *types.Annotations, values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		acid, err := types.NewACIdentifier(name)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid annotation %#v", name)
+		}
+		anns.Set(*acid, values[name])
+	}
+	return nil
+}
+
+// PodManifest returns pod manifest (not reified yet) of a project's
+// pod, annotated with the project. Pod's hostname defaults to
+// POD.PROJECT.
+func (pp *ProjectPod) PodManifest(project string) (*schema.PodManifest, error) {
+	pm := schema.BlankPodManifest()
+	for _, v := range pp.Volumes {
+		if err := (*acutil.VolumesFlag)(&pm.Volumes).Set(v); err != nil {
+			return nil, errors.Annotatef(err, "Volume %#v", v)
+		}
+	}
+	for _, port := range pp.Ports {
+		if err := (*acutil.ExposedPortsFlag)(&pm.Ports).Set(port); err != nil {
+			return nil, errors.Annotatef(err, "Port %#v", port)
+		}
+	}
+	if err := setAnnotations(&pm.Annotations, pp.Annotations); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, ok := pm.Annotations.Get("hostname"); !ok {
+		pm.Annotations.Set("hostname", pp.Name+"."+project)
+	}
+	pm.Annotations.Set(ProjectAnnotation, project)
+	pm.Annotations.Set(ProjectPodAnnotation, pp.Name)
+	pm.Annotations.Set(ProjectHashAnnotation, pp.Hash())
+
+	for _, pa := range pp.Apps {
+		rtapp := schema.RuntimeApp{}
+		if h, err := types.NewHash(pa.Image); err == nil {
+			rtapp.Image.ID = *h
+			rtapp.Name.Set(h.String())
+		} else if name, labels, err := acutil.ParseImageName(pa.Image); err == nil {
+			rtapp.Image.Name = &name
+			rtapp.Name.Set(path.Base(name.String()))
+			rtapp.Image.Labels = labels
+		} else {
+			return nil, errors.Annotatef(err, "Image %#v", pa.Image)
+		}
+		if pa.Name != "" {
+			if err := rtapp.Name.Set(pa.Name); err != nil {
+				return nil, errors.Annotatef(err, "App name %#v", pa.Name)
+			}
+		}
+		if err := setAnnotations(&rtapp.Annotations, pa.Annotations); err != nil {
+			return nil, errors.Annotatef(err, "App %v", rtapp.Name)
+		}
+		for _, m := range pa.Mounts {
+			if err := (*acutil.MountsFlag)(&rtapp.Mounts).Set(m); err != nil {
+				return nil, errors.Annotatef(err, "App %v: mount %#v", rtapp.Name, m)
+			}
+		}
+		pm.Apps = append(pm.Apps, rtapp)
+	}
+	return pm, nil
+}
+
+// ProjectName returns name of the project pod has been created from,
+// and pod's name within the project.
+func (pod *Pod) ProjectName() (project, name string) {
+	project, _ = pod.Manifest.Annotations.Get(ProjectAnnotation)
+	name, _ = pod.Manifest.Annotations.Get(ProjectPodAnnotation)
+	return project, name
+}
+
+// ProjectPods returns pods created from the project, by their names
+// within the project.
+func (h *Host) ProjectPods(project string) map[string]*Pod {
+	pods := make(map[string]*Pod)
+	for _, pod := range h.Pods() {
+		if pr, name := pod.ProjectName(); pr == project && name != "" {
+			pods[name] = pod
+		}
+	}
+	return pods
+}
+
+// WaitRunning waits up to timeout until pod, started at since, is
+// running and each of its apps has either started or exited cleanly
+// (e.g. one-shot init apps) since then. Returns an error if any of
+// pod's apps has failed since then.
+func (pod *Pod) WaitRunning(since time.Time, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := pod.jailStatus(true); err != nil {
+			return errors.Trace(err)
+		}
+		states, err := pod.AppStates()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if ready, err := pod.checkStartedApps(states, since, pod.Status() == PodStatusRunning); err != nil || ready {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("Pod %v is not running after %v", pod.Name(), timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Checks states of pod's apps started at since. Returns an error if
+// any app has failed since then, and true if each app has exited
+// cleanly since then, or has been started since then in a running
+// jail.
+func (pod *Pod) checkStartedApps(states []*AppState, since time.Time, jailRunning bool) (bool, error) {
+	ready := len(states) > 0
+	for i, st := range states {
+		finished := (st.Status == AppStatusExited || st.Status == AppStatusKilled) && st.Finished.After(since)
+		if finished && st.Failed() {
+			return false, errors.Errorf("Pod %v: app %v has %v", pod.Name(), pod.Manifest.Apps[i].Name, st)
+		}
+		started := jailRunning && st.Status == AppStatusRunning && !st.Started.Before(since)
+		ready = ready && (finished || started)
+	}
+	return ready, nil
+}
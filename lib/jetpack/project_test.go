@@ -0,0 +1,144 @@
+package jetpack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestProjectStartOrder(t *testing.T) {
+	p := &Project{Name: "blog", Pods: []ProjectPod{
+		{Name: "web", After: []string{"db", "cache"}},
+		{Name: "db"},
+		{Name: "cache", After: []string{"db"}},
+		{Name: "worker"},
+	}}
+	order, err := p.StartOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pp := range order {
+		names = append(names, pp.Name)
+	}
+	if expected := []string{"db", "cache", "web", "worker"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	p.Pods[1].After = []string{"web"}
+	if _, err := p.StartOrder(); err == nil {
+		t.Error("Expected error for dependency cycle")
+	}
+
+	p.Pods[1].After = []string{"nonexistent"}
+	if _, err := p.StartOrder(); err == nil {
+		t.Error("Expected error for unknown pod")
+	}
+}
+
+func TestProjectPodManifest(t *testing.T) {
+	pp := &ProjectPod{
+		Name:        "db",
+		Apps:        []ProjectApp{{Image: "example.com/postgresql", Mounts: []string{"data:/var/db"}}},
+		Volumes:     []string{"data"},
+		Ports:       []string{"pg=5432"},
+		Annotations: map[string]string{"jetpack/ip-family": "dual"},
+	}
+	pm, err := pp.PodManifest("blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"hostname":            "db.blog",
+		"jetpack/ip-family":   "dual",
+		ProjectAnnotation:     "blog",
+		ProjectPodAnnotation:  "db",
+		ProjectHashAnnotation: pp.Hash(),
+	} {
+		if v, _ := pm.Annotations.Get(name); v != expected {
+			t.Errorf("%v: expected %#v, got %#v", name, expected, v)
+		}
+	}
+	if len(pm.Apps) != 1 || pm.Apps[0].Name.String() != "postgresql" || pm.Apps[0].Mounts[0].Path != "/var/db" {
+		t.Errorf("Unexpected apps: %#v", pm.Apps)
+	}
+	if len(pm.Volumes) != 1 || pm.Volumes[0].Kind != "empty" {
+		t.Errorf("Unexpected volumes: %#v", pm.Volumes)
+	}
+	if len(pm.Ports) != 1 || pm.Ports[0].HostPort != 5432 {
+		t.Errorf("Unexpected ports: %#v", pm.Ports)
+	}
+
+	hash := pp.Hash()
+	pp.Ports = []string{"pg=5433"}
+	if pp.Hash() == hash {
+		t.Error("Hash didn't change with definition")
+	}
+}
+
+func TestLoadProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jetpack-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "myblog")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "jetpack.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"pods": [{"name": "web", "apps": [{"image": "example.com/nginx"}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := LoadProject(filename); err != nil {
+		t.Error(err)
+	} else if p.Name != "myblog" {
+		t.Errorf("Expected project name myblog, got %v", p.Name)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"pods": [{"name": "web", "apps": []}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProject(filename); err == nil {
+		t.Error("Expected error for pod without apps")
+	}
+}
+
+func TestCheckStartedApps(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	pod.Manifest.Apps = schema.AppList{{Name: *types.MustACName("init")}, {Name: *types.MustACName("server")}}
+	since := time.Now()
+	before, after := since.Add(-time.Minute), since.Add(time.Second)
+
+	for i, c := range []struct {
+		init, server AppState
+		jailRunning  bool
+		ready        bool
+		failed       bool
+	}{
+		{AppState{Status: AppStatusRunning}, AppState{Status: AppStatusRunning}, true, false, false},
+		{AppState{Status: AppStatusRunning, Started: after}, AppState{Status: AppStatusRunning, Started: after}, true, true, false},
+		{AppState{Status: AppStatusRunning, Started: after}, AppState{Status: AppStatusRunning, Started: after}, false, false, false},
+		{AppState{Status: AppStatusExited, Finished: after}, AppState{Status: AppStatusPrepared}, true, false, false},
+		{AppState{Status: AppStatusExited, Finished: after}, AppState{Status: AppStatusRunning, Started: after}, true, true, false},
+		{AppState{Status: AppStatusExited, Finished: after}, AppState{Status: AppStatusExited, Finished: after}, false, true, false},
+		{AppState{Status: AppStatusExited, Finished: after}, AppState{Status: AppStatusExited, Finished: before}, true, false, false},
+		{AppState{Status: AppStatusExited, ExitCode: 1, Finished: before}, AppState{Status: AppStatusRunning}, true, false, false},
+		{AppState{Status: AppStatusExited, ExitCode: 1, Finished: after}, AppState{Status: AppStatusRunning, Started: after}, true, false, true},
+		{AppState{Status: AppStatusExited, Signal: "SIGSEGV", Finished: after}, AppState{Status: AppStatusRunning}, true, false, true},
+		{AppState{Status: AppStatusExited, Finished: after}, AppState{Status: AppStatusKilled, Finished: after}, true, false, true},
+	} {
+		initSt, serverSt := c.init, c.server
+		ready, err := pod.checkStartedApps([]*AppState{&initSt, &serverSt}, since, c.jailRunning)
+		if ready != c.ready || (err != nil) != c.failed {
+			t.Errorf("%d: expected ready=%v failed=%v, got ready=%v err=%v", i, c.ready, c.failed, ready, err)
+		}
+	}
+}
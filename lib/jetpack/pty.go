@@ -0,0 +1,96 @@
+package jetpack
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/juju/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// Returns the local terminal if both stdin and stdout are terminals
+// (an interactive session), nil otherwise.
+func interactiveTerminal(stdin io.Reader, stdout io.Writer) *os.File {
+	in, ok := stdin.(*os.File)
+	if !ok || !terminal.IsTerminal(int(in.Fd())) {
+		return nil
+	}
+	if out, ok := stdout.(*os.File); !ok || !terminal.IsTerminal(int(out.Fd())) {
+		return nil
+	}
+	return in
+}
+
+// pty connects a stage2 command to the local terminal through a
+// pseudo-terminal. The local terminal is in raw mode, and its window
+// size is forwarded to the pseudo-terminal, until pty is closed.
+type pty struct {
+	// Master side, used by Jetpack
+	master *os.File
+	// Slave side, command's stdin, stdout, and stderr
+	slave *os.File
+	local *os.File
+	state *terminal.State
+	winch chan os.Signal
+}
+
+func newPTY(local *os.File) (*pty, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &pty{master: master, slave: slave, local: local}
+	p.resize()
+	if p.state, err = terminal.MakeRaw(int(local.Fd())); err != nil {
+		p.Close()
+		return nil, errors.Trace(err)
+	}
+	p.winch = make(chan os.Signal, 1)
+	signal.Notify(p.winch, syscall.SIGWINCH)
+	go func(winch chan os.Signal) {
+		for range winch {
+			p.resize()
+		}
+	}(p.winch)
+	return p, nil
+}
+
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// Copies local terminal's window size to the pseudo-terminal
+func (p *pty) resize() {
+	var ws winsize
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, p.local.Fd(), unix.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); errno != 0 {
+		return
+	}
+	unix.Syscall(unix.SYS_IOCTL, p.master.Fd(), unix.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// Copies data between the local terminal and the pseudo-terminal
+// until the slave side is closed by the command and by the Jetpack
+// process.
+func (p *pty) copy(stdin io.Reader, stdout io.Writer) {
+	// Reading stdin blocks until the next keystroke, even after the
+	// command has exited; the keystroke is lost then.
+	go io.Copy(p.master, stdin)
+	io.Copy(stdout, p.master)
+}
+
+// Close restores the local terminal, and closes the pseudo-terminal.
+func (p *pty) Close() error {
+	if p.winch != nil {
+		signal.Stop(p.winch)
+		close(p.winch)
+	}
+	if p.state != nil {
+		terminal.Restore(int(p.local.Fd()), p.state)
+	}
+	p.slave.Close()
+	return p.master.Close()
+}
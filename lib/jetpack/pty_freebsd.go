@@ -0,0 +1,33 @@
+package jetpack
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/juju/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Opens a new pseudo-terminal, returning its master and slave side
+func openPTY() (*os.File, *os.File, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_POSIX_OPENPT, unix.O_RDWR|unix.O_NOCTTY, 0, 0)
+	if errno != 0 {
+		return nil, nil, errors.Annotate(errno, "posix_openpt")
+	}
+	unix.CloseOnExec(int(fd))
+	master := os.NewFile(fd, "/dev/ptmx")
+
+	var n uint32
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, unix.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		return nil, nil, errors.Annotate(errno, "TIOCGPTN")
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.Trace(err)
+	}
+	return master, slave, nil
+}
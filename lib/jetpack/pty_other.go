@@ -0,0 +1,14 @@
+//go:build !freebsd
+// +build !freebsd
+
+package jetpack
+
+import (
+	"os"
+
+	"github.com/juju/errors"
+)
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("Pseudo-terminals are supported only on FreeBSD")
+}
@@ -0,0 +1,45 @@
+package jetpack
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTermEnv(t *testing.T) {
+	defer os.Setenv("TERM", os.Getenv("TERM"))
+	os.Setenv("TERM", "xterm")
+	env := []string{"PATH=/bin"}
+	if expected := []string{"PATH=/bin", "TERM=xterm"}; !reflect.DeepEqual(termEnv(env), expected) {
+		t.Errorf("Expected %v, got %v", expected, termEnv(env))
+	}
+	if len(env) != 1 {
+		t.Errorf("Original environment modified: %v", env)
+	}
+
+	env = []string{"TERM=screen", "PATH=/bin"}
+	if !reflect.DeepEqual(termEnv(env), env) {
+		t.Errorf("App's TERM overridden: %v", termEnv(env))
+	}
+
+	os.Setenv("TERM", "")
+	if got := termEnv(nil); !reflect.DeepEqual(got, []string{"TERM=vt100"}) {
+		t.Errorf("Expected default TERM, got %v", got)
+	}
+}
+
+func TestInteractiveTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if local := interactiveTerminal(r, w); local != nil {
+		t.Error("Pipe taken for a terminal")
+	}
+	if local := interactiveTerminal(bytes.NewReader(nil), &bytes.Buffer{}); local != nil {
+		t.Error("Buffer taken for a terminal")
+	}
+}
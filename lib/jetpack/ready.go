@@ -0,0 +1,243 @@
+package jetpack
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+)
+
+// Readiness lists conditions that app needs to meet before apps that
+// are started after it can start. All set conditions need to be met.
+type Readiness struct {
+	// TCP port that accepts connections on pod's address
+	Port int
+	// File that exists in app's rootfs
+	File string
+	// Shell command that succeeds in the app
+	Command string
+	// Conditions are checked this often
+	Interval time.Duration
+	// App is not ready if conditions are not met in this time
+	Timeout time.Duration
+}
+
+var DefaultReadiness = Readiness{
+	Interval: time.Second,
+	Timeout:  time.Minute,
+}
+
+// After returns names of apps that need to be ready before the app
+// starts, as listed in its jetpack/after annotation.
+func (app *App) After() []types.ACName {
+	v, _ := app.annotation("jetpack/after")
+	var names []types.ACName
+	for _, name := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+		names = append(names, types.ACName(name))
+	}
+	return names
+}
+
+// Readiness returns app's readiness conditions, or nil if app is ready
+// as soon as it has been started. It is configured with pod or image
+// annotations:
+//   - jetpack/ready-port (port number, or name of app's port)
+//   - jetpack/ready-file (path in app's rootfs)
+//   - jetpack/ready-command (shell command)
+//   - jetpack/ready-interval (e.g. "1s")
+//   - jetpack/ready-timeout (e.g. "1m")
+func (app *App) Readiness() (*Readiness, error) {
+	r := DefaultReadiness
+
+	if v, ok := app.annotation("jetpack/ready-port"); ok {
+		if port, err := strconv.Atoi(v); err == nil {
+			r.Port = port
+		} else {
+			for _, p := range app.app.Ports {
+				if p.Name.String() == v {
+					r.Port = int(p.Port)
+				}
+			}
+		}
+		if r.Port <= 0 || r.Port > 65535 {
+			return nil, errors.Errorf("jetpack/ready-port: invalid port %#v", v)
+		}
+	}
+
+	if v, ok := app.annotation("jetpack/ready-file"); ok {
+		if !strings.HasPrefix(v, "/") {
+			return nil, errors.Errorf("jetpack/ready-file: path %#v is not absolute", v)
+		}
+		r.File = v
+	}
+
+	r.Command, _ = app.annotation("jetpack/ready-command")
+
+	for name, dst := range map[string]*time.Duration{
+		"jetpack/ready-interval": &r.Interval,
+		"jetpack/ready-timeout":  &r.Timeout,
+	} {
+		if v, ok := app.annotation(name); ok {
+			if d, err := time.ParseDuration(v); err != nil {
+				return nil, errors.Annotate(err, name)
+			} else if d <= 0 {
+				return nil, errors.Errorf("%v: must be positive", name)
+			} else {
+				*dst = d
+			}
+		}
+	}
+
+	if r.Port == 0 && r.File == "" && r.Command == "" {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// Returns names of apps in order they can be started in: each app
+// after apps listed in after, otherwise in order of names. Returns an
+// error if after refers to unknown apps, or has a cycle.
+func appStartOrder(names []types.ACName, after map[types.ACName][]types.ACName) ([]types.ACName, error) {
+	known := make(map[types.ACName]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range names {
+		for _, dep := range after[name] {
+			if !known[dep] {
+				return nil, errors.Errorf("App %v: jetpack/after: unknown app %v", name, dep)
+			}
+		}
+	}
+
+	var order []types.ACName
+	done := make(map[types.ACName]bool, len(names))
+	var path []types.ACName
+	var visit func(name types.ACName) error
+	visit = func(name types.ACName) error {
+		if done[name] {
+			return nil
+		}
+		for i, seen := range path {
+			if seen == name {
+				cycle := make([]string, 0, len(path)-i+1)
+				for _, n := range append(path[i:], name) {
+					cycle = append(cycle, n.String())
+				}
+				return errors.Errorf("Apps' jetpack/after annotations have a cycle: %v", strings.Join(cycle, " -> "))
+			}
+		}
+		path = append(path, name)
+		for _, dep := range after[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		done[name] = true
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Checks apps' start order and readiness conditions, and returns
+// apps in order they can be started in.
+func (pod *Pod) appStartOrder(apps []*App) ([]types.ACName, error) {
+	names := make([]types.ACName, len(apps))
+	after := make(map[types.ACName][]types.ACName)
+	for i, app := range apps {
+		names[i] = app.Name
+		after[app.Name] = app.After()
+		if _, err := app.Readiness(); err != nil {
+			return nil, errors.Annotatef(err, "App %v", app.Name)
+		}
+	}
+	return appStartOrder(names, after)
+}
+
+// Checks app's readiness conditions once
+func (app *App) checkReady(r *Readiness) error {
+	if r.Port != 0 {
+		addrs := app.Pod.IPAddresses()
+		if len(addrs) == 0 {
+			return errors.New("Pod has no IP address")
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(addrs[0], strconv.Itoa(r.Port)), r.Interval)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		conn.Close()
+	}
+	if r.File != "" {
+		if _, err := os.Stat(app.Path(r.File)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if r.Command != "" {
+		if output, err := app.runCheck(r.Command, r.Timeout); err != nil {
+			if output = strings.TrimSpace(output); output != "" {
+				return errors.Annotate(err, output)
+			}
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Waits until app is ready. Gives up when the app's timeout passes,
+// or when exited is closed.
+func (app *App) waitReady(r *Readiness, exited <-chan struct{}) error {
+	deadline := time.Now().Add(r.Timeout)
+	for {
+		err := app.checkReady(r)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Annotatef(err, "Not ready after %v", r.Timeout)
+		}
+		select {
+		case <-exited:
+			return errors.New("Exited before it was ready")
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// appReady is closed when app is ready, or is known never to be
+type appReady struct {
+	done chan struct{}
+	err  error
+}
+
+// Waits until apps that app is started after are ready. Gives up if
+// the app is stopped in the meantime.
+func (app *App) waitForDependencies(ready map[types.ACName]*appReady) error {
+	for _, name := range app.After() {
+		dep := ready[name]
+		for waiting := true; waiting; {
+			select {
+			case <-dep.done:
+				waiting = false
+			case <-time.After(250 * time.Millisecond):
+				if app.isKilled() || app.stopRequested() {
+					return errors.New("Stopped before it was started")
+				}
+			}
+		}
+		if dep.err != nil {
+			return errors.Errorf("App %v is not ready: %v", name, dep.err)
+		}
+	}
+	return nil
+}
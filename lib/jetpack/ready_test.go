@@ -0,0 +1,82 @@
+package jetpack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestAppStartOrder(t *testing.T) {
+	names := []types.ACName{"web", "db", "cache", "worker"}
+	after := map[types.ACName][]types.ACName{
+		"web":    {"db", "cache"},
+		"worker": {"db"},
+		"cache":  {"db"},
+	}
+	order, err := appStartOrder(names, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []types.ACName{"db", "cache", "web", "worker"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+
+	after["db"] = []types.ACName{"worker"}
+	if _, err := appStartOrder(names, after); err == nil || !strings.Contains(err.Error(), "db -> worker -> db") {
+		t.Errorf("Expected cycle error, got %v", err)
+	}
+
+	after["db"] = []types.ACName{"nonexistent"}
+	if _, err := appStartOrder(names, after); err == nil || !strings.Contains(err.Error(), "unknown app nonexistent") {
+		t.Errorf("Expected unknown app error, got %v", err)
+	}
+
+	after["db"] = []types.ACName{"db"}
+	if _, err := appStartOrder(names, after); err == nil {
+		t.Error("Expected error for app started after itself")
+	}
+}
+
+func TestAppReadiness(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	db := schema.RuntimeApp{Name: "db"}
+	db.Annotations.Set("jetpack/ready-port", "pg")
+	db.Annotations.Set("jetpack/ready-file", "/var/run/postgres.pid")
+	db.Annotations.Set("jetpack/ready-timeout", "30s")
+	web := schema.RuntimeApp{Name: "web"}
+	web.Annotations.Set("jetpack/after", "db, cache")
+	bad := schema.RuntimeApp{Name: "bad"}
+	bad.Annotations.Set("jetpack/ready-file", "relative/path")
+	pod.Manifest.Apps = append(pod.Manifest.Apps, db, web, bad)
+	dbApp := &types.App{Ports: []types.Port{{Name: "pg", Protocol: "tcp", Port: 5432}}}
+
+	r, err := (&App{Name: "db", Pod: pod, app: dbApp}).Readiness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Readiness{
+		Port:     5432,
+		File:     "/var/run/postgres.pid",
+		Interval: DefaultReadiness.Interval,
+		Timeout:  30 * time.Second,
+	}
+	if r == nil || *r != expected {
+		t.Errorf("Expected %#v, got %#v", expected, r)
+	}
+
+	webApp := &App{Name: "web", Pod: pod, app: &types.App{}}
+	if r, err := webApp.Readiness(); err != nil || r != nil {
+		t.Errorf("Expected no readiness conditions, got %#v, %v", r, err)
+	}
+	if after := webApp.After(); !reflect.DeepEqual(after, []types.ACName{"db", "cache"}) {
+		t.Errorf("Unexpected jetpack/after: %v", after)
+	}
+
+	if _, err := (&App{Name: "bad", Pod: pod, app: &types.App{}}).Readiness(); err == nil {
+		t.Error("Expected error for relative ready file")
+	}
+}
@@ -0,0 +1,181 @@
+package jetpack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+)
+
+// Resolvers use at most this many nameservers (MAXNS in resolv.h)
+const maxNameservers = 3
+
+// Pod annotations that control apps' /etc/resolv.conf
+const (
+	// How the file is provided, one of ResolvConf* modes
+	ResolvConfAnnotation = "jetpack/resolv.conf"
+	// Comma- or whitespace-separated lists that replace nameservers,
+	// search domains, and options of the generated file
+	ResolvConfNameserversAnnotation = "jetpack/resolv.conf/nameservers"
+	ResolvConfSearchAnnotation      = "jetpack/resolv.conf/search"
+	ResolvConfOptionsAnnotation     = "jetpack/resolv.conf/options"
+)
+
+const (
+	// Generate the file when the pod starts (default)
+	ResolvConfGenerate = "generate"
+	// Mount host's /etc/resolv.conf read-only
+	ResolvConfHost = "host"
+	// Leave apps' own file alone
+	ResolvConfNone = "none"
+)
+
+// Returns pod's resolv.conf mode, and checks the other resolv.conf
+// annotations.
+func podResolvConfMode(pm *schema.PodManifest) (string, error) {
+	mode := ResolvConfGenerate
+	if v, ok := pm.Annotations.Get(ResolvConfAnnotation); ok {
+		mode = v
+	}
+	switch mode {
+	case ResolvConfGenerate, ResolvConfHost, ResolvConfNone:
+	default:
+		return "", errors.Errorf("Invalid %v annotation: %#v", ResolvConfAnnotation, mode)
+	}
+	if v, ok := pm.Annotations.Get(ResolvConfNameserversAnnotation); ok {
+		for _, ns := range splitResolvConfList(v) {
+			if net.ParseIP(ns) == nil {
+				return "", errors.Errorf("Invalid %v annotation: %#v is not an IP address", ResolvConfNameserversAnnotation, ns)
+			}
+		}
+	}
+	return mode, nil
+}
+
+func splitResolvConfList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// Parsed resolv.conf
+type resolvConf struct {
+	Search      []string
+	Nameservers []string
+	Options     []string
+	// Other lines (comments, sortlist, ...) are kept as they are
+	Other []string
+}
+
+func parseResolvConf(data []byte) *resolvConf {
+	rc := &resolvConf{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) > 0 && (fields[0] == "search" || fields[0] == "domain"):
+			rc.Search = append(rc.Search, fields[1:]...)
+		case len(fields) > 1 && fields[0] == "nameserver":
+			rc.Nameservers = append(rc.Nameservers, fields[1])
+		case len(fields) > 0 && fields[0] == "options":
+			rc.Options = append(rc.Options, fields[1:]...)
+		default:
+			rc.Other = append(rc.Other, line)
+		}
+	}
+	return rc
+}
+
+// Returns contents of the file. Nameservers beyond the resolver's
+// limit are dropped.
+func (rc *resolvConf) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if len(rc.Search) > 0 {
+		fmt.Fprintln(buf, "search", strings.Join(rc.Search, " "))
+	}
+	for i, ns := range rc.Nameservers {
+		if i == maxNameservers {
+			break
+		}
+		fmt.Fprintln(buf, "nameserver", ns)
+	}
+	if len(rc.Options) > 0 {
+		fmt.Fprintln(buf, "options", strings.Join(rc.Options, " "))
+	}
+	for _, line := range rc.Other {
+		fmt.Fprintln(buf, line)
+	}
+	return buf.Bytes()
+}
+
+// Applies pod's resolv.conf annotations
+func (rc *resolvConf) override(pm *schema.PodManifest) {
+	if v, ok := pm.Annotations.Get(ResolvConfNameserversAnnotation); ok {
+		rc.Nameservers = splitResolvConfList(v)
+	}
+	if v, ok := pm.Annotations.Get(ResolvConfSearchAnnotation); ok {
+		rc.Search = splitResolvConfList(v)
+	}
+	if v, ok := pm.Annotations.Get(ResolvConfOptionsAnnotation); ok {
+		rc.Options = splitResolvConfList(v)
+	}
+}
+
+// Returns contents of pod's generated /etc/resolv.conf: copied from
+// the host, or listing ace.dns-servers, with pod's annotations
+// applied. If metadata service's DNS responder is enabled, it is used
+// first, and its domain is searched.
+func (pod *Pod) resolvConf() ([]byte, error) {
+	var rc *resolvConf
+	if dnsServers, ok := Config().Get("ace.dns-servers"); !ok {
+		// By default, copy /etc/resolv.conf from host
+		if bb, err := ioutil.ReadFile("/etc/resolv.conf"); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			rc = parseResolvConf(bb)
+		}
+	} else {
+		rc = &resolvConf{Nameservers: strings.Fields(dnsServers)}
+	}
+
+	rc.override(&pod.Manifest)
+
+	if Config().GetBool("mds.dns", false) {
+		hostip, _, err := pod.Host.HostIP()
+		if _, ok := pod.Manifest.Annotations.Get(IP4AddressAnnotation); !ok {
+			hostip, _, err = pod.Host.HostIP6()
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rc.Nameservers = append([]string{hostip.String()}, rc.Nameservers...)
+		rc.Search = append([]string{dnsDomain()}, rc.Search...)
+	}
+	return rc.Bytes(), nil
+}
+
+// Returns fstab line that mounts host's /etc/resolv.conf read-only in
+// app's rootfs, or nothing if the app has no /etc. Creates the mount
+// target if needed; it's removed from images built or committed from
+// the pod.
+func (pod *Pod) resolvConfFstab(app types.ACName, appRootfs string) ([]string, error) {
+	etcPath := filepath.Join(appRootfs, "etc")
+	if fi, err := os.Stat(etcPath); err != nil || !fi.IsDir() {
+		return nil, nil
+	}
+	target := filepath.Join(etcPath, "resolv.conf")
+	if fi, err := os.Lstat(target); err == nil && fi.Mode().IsRegular() {
+		// Mounted over, the app's own file stays intact
+	} else if err := pod.writeEtcFile(app, etcPath, "resolv.conf", nil); err != nil {
+		// Missing, or a symlink, which mount would resolve on the host
+		return nil, errors.Trace(err)
+	}
+	return []string{fstabLine("/etc/resolv.conf", target, "nullfs", mountOpts(true), 1)}, nil
+}
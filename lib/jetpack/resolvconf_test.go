@@ -0,0 +1,119 @@
+package jetpack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+func TestPodResolvConfMode(t *testing.T) {
+	for _, c := range []struct {
+		annotations map[string]string
+		expected    string
+		err         bool
+	}{
+		{nil, ResolvConfGenerate, false},
+		{map[string]string{ResolvConfAnnotation: "host"}, ResolvConfHost, false},
+		{map[string]string{ResolvConfAnnotation: "none"}, ResolvConfNone, false},
+		{map[string]string{ResolvConfAnnotation: "bogus"}, "", true},
+		{map[string]string{ResolvConfNameserversAnnotation: "10.0.0.1, fd00::1"}, ResolvConfGenerate, false},
+		{map[string]string{ResolvConfNameserversAnnotation: "ns.example.com"}, "", true},
+	} {
+		pm := schema.BlankPodManifest()
+		for k, v := range c.annotations {
+			pm.Annotations.Set(types.ACIdentifier(k), v)
+		}
+		mode, err := podResolvConfMode(pm)
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: expected error, got %v", c.annotations, mode)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %v", c.annotations, err)
+		} else if mode != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.annotations, c.expected, mode)
+		}
+	}
+}
+
+func TestResolvConf(t *testing.T) {
+	base := "# comment\n" +
+		"domain example.com\n" +
+		"nameserver 10.0.0.1\n" +
+		"nameserver 10.0.0.2\n" +
+		"nameserver 10.0.0.3\n" +
+		"options edns0\n"
+
+	rc := parseResolvConf([]byte(base))
+	rc.Nameservers = append([]string{"172.23.0.1"}, rc.Nameservers...)
+	rc.Search = append([]string{"jetpack"}, rc.Search...)
+	expected := "search jetpack example.com\n" +
+		"nameserver 172.23.0.1\n" +
+		"nameserver 10.0.0.1\n" +
+		"nameserver 10.0.0.2\n" +
+		"options edns0\n" +
+		"# comment\n"
+	if actual := rc.Bytes(); string(actual) != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, string(actual))
+	}
+
+	pm := schema.BlankPodManifest()
+	pm.Annotations.Set(ResolvConfNameserversAnnotation, "192.168.1.1,192.168.1.2")
+	pm.Annotations.Set(ResolvConfOptionsAnnotation, "ndots:2 timeout:1")
+	rc = parseResolvConf([]byte(base))
+	rc.override(pm)
+	expected = "search example.com\n" +
+		"nameserver 192.168.1.1\n" +
+		"nameserver 192.168.1.2\n" +
+		"options ndots:2 timeout:1\n" +
+		"# comment\n"
+	if actual := rc.Bytes(); string(actual) != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, string(actual))
+	}
+}
+
+func TestResolvConfFstab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jetpack-resolvconf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pod := &Pod{
+		UUID: uuid.NewRandom(),
+		Host: &Host{Dataset: &zfs.Dataset{Mountpoint: filepath.Join(dir, "host")}},
+	}
+	for _, app := range []string{"own", "none"} {
+		if err := os.MkdirAll(filepath.Join(dir, app, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ownResolvConf := filepath.Join(dir, "own", "etc", "resolv.conf")
+	if err := ioutil.WriteFile(ownResolvConf, []byte("nameserver 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []string{"own", "none"} {
+		if lines, err := pod.resolvConfFstab(types.ACName(app), filepath.Join(dir, app)); err != nil {
+			t.Fatal(err)
+		} else if len(lines) != 1 {
+			t.Errorf("%v: expected one fstab line, got %v", app, lines)
+		}
+		if err := pod.restoreEtcFiles(types.ACName(app), filepath.Join(dir, app)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, _ := ioutil.ReadFile(ownResolvConf); string(data) != "nameserver 10.0.0.1\n" {
+		t.Errorf("App's own resolv.conf changed: %#v", string(data))
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "none", "etc", "resolv.conf")); !os.IsNotExist(err) {
+		t.Errorf("Created mount target not removed: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package jetpack
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type RestartMode uint
+
+const (
+	RestartNo RestartMode = iota
+	RestartOnFailure
+	RestartAlways
+)
+
+var restartModeNames = []string{
+	RestartNo:        "no",
+	RestartOnFailure: "on-failure",
+	RestartAlways:    "always",
+}
+
+func (rm RestartMode) String() string {
+	if int(rm) < len(restartModeNames) {
+		return restartModeNames[rm]
+	}
+	return fmt.Sprintf("RestartMode[%d]", rm)
+}
+
+// Set implements flag.Value
+func (rm *RestartMode) Set(v string) error {
+	for i, name := range restartModeNames {
+		if name == v {
+			*rm = RestartMode(i)
+			return nil
+		}
+	}
+	return errors.Errorf("Invalid restart mode %#v (allowed values: no, on-failure, always)", v)
+}
+
+// Delay between restarts is doubled after each restart, up to this
+// value.
+const maxRestartBackoff = 5 * time.Minute
+
+// RestartPolicy says whether and when an app that has exited should
+// be run again.
+type RestartPolicy struct {
+	Mode RestartMode
+	// Maximum number of restarts; zero means no limit.
+	MaxRetries int
+	// Delay before the first restart.
+	Backoff time.Duration
+}
+
+var DefaultRestartPolicy = RestartPolicy{Mode: RestartNo, Backoff: time.Second}
+
+// Checks that policy's limits are in range: a negative number of
+// restarts would mean no limit, and a restart without a delay would
+// spin in a tight loop.
+func (rp *RestartPolicy) check() error {
+	if rp.MaxRetries < 0 {
+		return errors.Errorf("Invalid max retries %d: can't be negative", rp.MaxRetries)
+	}
+	if rp.Backoff <= 0 {
+		return errors.Errorf("Invalid backoff %v: needs to be positive", rp.Backoff)
+	}
+	return nil
+}
+
+// Returns true if app that has exited with err after given number of
+// restarts should be restarted.
+func (rp *RestartPolicy) shouldRestart(err error, restarts int) bool {
+	if rp.MaxRetries > 0 && restarts >= rp.MaxRetries {
+		return false
+	}
+	if err != nil {
+		if _, ok := waitStatus(err); !ok {
+			// Not app's exit status; restarting won't help.
+			return false
+		}
+	}
+	switch rp.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// Returns delay before the next restart, after given number of
+// restarts.
+func (rp *RestartPolicy) delay(restarts int) time.Duration {
+	delay := rp.Backoff
+	for i := 0; i < restarts && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
+	}
+	return delay
+}
+
+// RestartPolicy returns app's restart policy. Pod's RestartPolicy
+// field, if set, overrides the app's annotations:
+//  - jetpack/restart (no, on-failure, always)
+//  - jetpack/restart-max-retries
+//  - jetpack/restart-backoff (initial delay, e.g. "5s")
+func (app *App) RestartPolicy() (*RestartPolicy, error) {
+	if app.Pod.RestartPolicy != nil {
+		if err := app.Pod.RestartPolicy.check(); err != nil {
+			return nil, errors.Annotatef(err, "App %v", app.Name)
+		}
+		return app.Pod.RestartPolicy, nil
+	}
+
+	rp := DefaultRestartPolicy
+	rtapp := app.Pod.Manifest.Apps.Get(app.Name)
+	if rtapp == nil {
+		return &rp, nil
+	}
+
+	if v, ok := rtapp.Annotations.Get("jetpack/restart"); ok {
+		if err := rp.Mode.Set(v); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if v, ok := rtapp.Annotations.Get("jetpack/restart-max-retries"); ok {
+		if n, err := strconv.Atoi(v); err != nil {
+			return nil, errors.Annotate(err, "jetpack/restart-max-retries")
+		} else {
+			rp.MaxRetries = n
+		}
+	}
+
+	if v, ok := rtapp.Annotations.Get("jetpack/restart-backoff"); ok {
+		if d, err := time.ParseDuration(v); err != nil {
+			return nil, errors.Annotate(err, "jetpack/restart-backoff")
+		} else {
+			rp.Backoff = d
+		}
+	}
+
+	if err := rp.check(); err != nil {
+		return nil, errors.Annotatef(err, "App %v", app.Name)
+	}
+	return &rp, nil
+}
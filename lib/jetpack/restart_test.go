@@ -0,0 +1,113 @@
+package jetpack
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+func exitError(script string) error {
+	cmd := run.Command("/bin/sh", "-c", script)
+	cmd.Cmd.Stdin, cmd.Cmd.Stdout, cmd.Cmd.Stderr = nil, nil, nil
+	return cmd.Run()
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	failure := exitError("exit 1")
+	notStarted := errors.New("cannot find user")
+
+	for i, tc := range []struct {
+		policy   RestartPolicy
+		err      error
+		restarts int
+		expected bool
+	}{
+		{RestartPolicy{Mode: RestartNo}, failure, 0, false},
+		{RestartPolicy{Mode: RestartOnFailure}, failure, 0, true},
+		{RestartPolicy{Mode: RestartOnFailure}, nil, 0, false},
+		{RestartPolicy{Mode: RestartAlways}, nil, 0, true},
+		{RestartPolicy{Mode: RestartAlways}, failure, 100, true},
+		{RestartPolicy{Mode: RestartAlways, MaxRetries: 3}, nil, 2, true},
+		{RestartPolicy{Mode: RestartAlways, MaxRetries: 3}, nil, 3, false},
+		{RestartPolicy{Mode: RestartAlways}, notStarted, 0, false},
+	} {
+		if actual := tc.policy.shouldRestart(tc.err, tc.restarts); actual != tc.expected {
+			t.Errorf("Case %d: expected %v, got %v", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	rp := RestartPolicy{Backoff: time.Second}
+	for restarts, expected := range map[int]time.Duration{
+		0:    time.Second,
+		1:    2 * time.Second,
+		3:    8 * time.Second,
+		9:    maxRestartBackoff,
+		1000: maxRestartBackoff,
+	} {
+		if actual := rp.delay(restarts); actual != expected {
+			t.Errorf("Expected delay after %d restarts to be %v, got %v", restarts, expected, actual)
+		}
+	}
+}
+
+func TestAppRestartPolicy(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	rtapp := schema.RuntimeApp{Name: "app"}
+	rtapp.Annotations.Set("jetpack/restart", "on-failure")
+	rtapp.Annotations.Set("jetpack/restart-max-retries", "5")
+	rtapp.Annotations.Set("jetpack/restart-backoff", "10s")
+	pod.Manifest.Apps = append(pod.Manifest.Apps, rtapp, schema.RuntimeApp{Name: "other"})
+
+	expected := RestartPolicy{Mode: RestartOnFailure, MaxRetries: 5, Backoff: 10 * time.Second}
+	if rp, err := (&App{Name: "app", Pod: pod}).RestartPolicy(); err != nil {
+		t.Error("Unexpected error:", err)
+	} else if *rp != expected {
+		t.Errorf("Expected %#v, got %#v", expected, *rp)
+	}
+
+	if rp, err := (&App{Name: "other", Pod: pod}).RestartPolicy(); err != nil {
+		t.Error("Unexpected error:", err)
+	} else if *rp != DefaultRestartPolicy {
+		t.Errorf("Expected default policy, got %#v", *rp)
+	}
+
+	pod.RestartPolicy = &RestartPolicy{Mode: RestartAlways, Backoff: time.Second}
+	if rp, _ := (&App{Name: "app", Pod: pod}).RestartPolicy(); rp != pod.RestartPolicy {
+		t.Errorf("Expected pod's policy to override annotations, got %#v", *rp)
+	}
+
+	pod.RestartPolicy = &RestartPolicy{Mode: RestartAlways, MaxRetries: -1, Backoff: time.Second}
+	if _, err := (&App{Name: "app", Pod: pod}).RestartPolicy(); err == nil || !strings.Contains(err.Error(), "App app") {
+		t.Errorf("Expected error naming the app for negative max retries, got %v", err)
+	}
+	pod.RestartPolicy = &RestartPolicy{Mode: RestartAlways}
+	if _, err := (&App{Name: "app", Pod: pod}).RestartPolicy(); err == nil {
+		t.Error("Expected error for zero backoff")
+	}
+
+	pod.RestartPolicy = nil
+	for _, c := range []struct{ name, invalid, valid string }{
+		{"jetpack/restart-max-retries", "-1", "5"},
+		{"jetpack/restart-backoff", "-5s", "10s"},
+		{"jetpack/restart-backoff", "0s", "10s"},
+	} {
+		pod.Manifest.Apps[0].Annotations.Set(types.ACIdentifier(c.name), c.invalid)
+		if _, err := (&App{Name: "app", Pod: pod}).RestartPolicy(); err == nil || !strings.Contains(err.Error(), "App app") {
+			t.Errorf("Expected error naming the app for %v=%v, got %v", c.name, c.invalid, err)
+		}
+		pod.Manifest.Apps[0].Annotations.Set(types.ACIdentifier(c.name), c.valid)
+	}
+
+	pod.Manifest.Apps[0].Annotations.Set(types.ACIdentifier("jetpack/restart"), "sometimes")
+	if _, err := (&App{Name: "app", Pod: pod}).RestartPolicy(); err == nil {
+		t.Error("Expected error for invalid restart mode")
+	}
+}
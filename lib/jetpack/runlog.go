@@ -0,0 +1,97 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/drain"
+	"github.com/3ofcoins/jetpack/lib/ui"
+)
+
+// Output formats of Pod.Run
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// A single line of JSON output. Lines of apps' output have App and
+// Stream set; jetpack's own messages have Stream set to "jetpack",
+// and Kind and ID of the message's source.
+type runLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Pod       string `json:"pod"`
+	PodName   string `json:"pod_name"`
+	App       string `json:"app,omitempty"`
+	Stream    string `json:"stream"`
+	Kind      string `json:"kind,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Text      string `json:"text"`
+}
+
+// Prints output of Pod.Run in pod's LogFormat
+type runOutput struct {
+	pod *Pod
+	w   io.Writer
+	enc *json.Encoder
+	mx  sync.Mutex
+}
+
+// Returns pod's run output writing to w. In JSON format, jetpack's
+// UI messages are written to w as well until output is closed.
+func (pod *Pod) newRunOutput(w io.Writer) (*runOutput, error) {
+	out := &runOutput{pod: pod, w: w}
+	switch pod.LogFormat {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		out.enc = json.NewEncoder(w)
+		ui.SetSink(out.message)
+	default:
+		return nil, errors.Errorf("Invalid log format %#v (allowed values: text, json)", pod.LogFormat)
+	}
+	return out, nil
+}
+
+func (out *runOutput) write(entry runLogEntry) {
+	out.mx.Lock()
+	defer out.mx.Unlock()
+	entry.Pod = out.pod.UUID.String()
+	entry.PodName = out.pod.Name()
+	// Nowhere to report the error to
+	_ = out.enc.Encode(entry)
+}
+
+// Line prints a line of app's output
+func (out *runOutput) Line(line drain.Line, src LogSource) {
+	if out.enc == nil {
+		fmt.Fprintf(out.w, "%v %v %v\n", line.Timestamp, src, line.Text)
+		return
+	}
+	out.write(runLogEntry{
+		Timestamp: line.Timestamp.Format(time.RFC3339Nano),
+		App:       string(src.App),
+		Stream:    src.Stream,
+		Text:      line.Text,
+	})
+}
+
+func (out *runOutput) message(ts time.Time, kind, id, text string) {
+	out.write(runLogEntry{
+		Timestamp: ts.Format(time.RFC3339Nano),
+		Stream:    "jetpack",
+		Kind:      kind,
+		ID:        id,
+		Text:      text,
+	})
+}
+
+// Close restores printing of UI messages to the standard error
+func (out *runOutput) Close() {
+	if out.enc != nil {
+		ui.SetSink(nil)
+	}
+}
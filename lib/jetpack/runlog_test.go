@@ -0,0 +1,62 @@
+package jetpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/drain"
+	"github.com/3ofcoins/jetpack/lib/ui"
+)
+
+func TestRunOutputJSON(t *testing.T) {
+	pod := &Pod{UUID: uuid.NewRandom(), Manifest: *schema.BlankPodManifest(), LogFormat: LogFormatJSON}
+	pod.Manifest.Annotations.Set("hostname", "web")
+
+	buf := new(bytes.Buffer)
+	out, err := pod.newRunOutput(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2016, 10, 1, 12, 0, 0, 5, time.UTC)
+	out.Line(drain.Line{Text: "hello", Timestamp: ts}, LogSource{"app", "out"})
+	ui.NewUI("yellow", "pod", "xyz").Println("stopping")
+	out.Close()
+	if ui.CurrentSink() != nil {
+		t.Error("UI sink not reset")
+	}
+
+	dec := json.NewDecoder(buf)
+	var line, msg runLogEntry
+	if err := dec.Decode(&line); err != nil {
+		t.Fatal(err)
+	}
+	expected := runLogEntry{
+		Timestamp: "2016-10-01T12:00:00.000000005Z",
+		Pod:       pod.UUID.String(),
+		PodName:   "web",
+		App:       "app",
+		Stream:    "out",
+		Text:      "hello",
+	}
+	if line != expected {
+		t.Errorf("Expected %#v, got %#v", expected, line)
+	}
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Stream != "jetpack" || msg.Kind != "pod" || msg.ID != "xyz" || msg.Text != "stopping" || msg.App != "" {
+		t.Errorf("Unexpected UI message: %#v", msg)
+	}
+}
+
+func TestRunOutputInvalidFormat(t *testing.T) {
+	pod := &Pod{UUID: uuid.NewRandom(), LogFormat: "xml"}
+	if _, err := pod.newRunOutput(new(bytes.Buffer)); err == nil {
+		t.Error("Expected error for invalid format")
+	}
+}
@@ -0,0 +1,193 @@
+package jetpack
+
+import (
+	stderrors "errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+var ErrPodRunning = stderrors.New("Pod is running")
+
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]*$`)
+
+// PodSnapshot is a recursive snapshot of pod's datasets
+type PodSnapshot struct {
+	Name    string
+	Created time.Time
+}
+
+func validateSnapshotName(name string) error {
+	if !snapshotNameRegexp.MatchString(name) {
+		return errors.Errorf("Invalid snapshot name %#v", name)
+	}
+	if name == "parent" || strings.HasPrefix(name, commitSnapshotPrefix) || strings.HasPrefix(name, exportSnapshotPrefix) {
+		return errors.Errorf("Snapshot name %#v is reserved", name)
+	}
+	return nil
+}
+
+func (pod *Pod) mustGetDataset() (*zfs.Dataset, error) {
+	if ds := pod.getDataset(); ds != nil {
+		return ds, nil
+	}
+	return nil, errors.Errorf("No dataset for pod %v", pod.UUID)
+}
+
+// Snapshot takes a consistent, recursive snapshot of all pod's
+// datasets, and returns its name. If name is empty, snapshot is named
+// after current time.
+func (pod *Pod) Snapshot(name string) (string, error) {
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if err := validateSnapshotName(name); err != nil {
+		return "", errors.Trace(err)
+	}
+	ds, err := pod.mustGetDataset()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	pod.ui.Printf("Taking snapshot %v", name)
+	if _, err := ds.Snapshot(name, "-r"); err != nil {
+		return "", errors.Trace(err)
+	}
+	return name, nil
+}
+
+// Snapshots returns pod's snapshots, oldest first
+func (pod *Pod) Snapshots() ([]PodSnapshot, error) {
+	ds, err := pod.mustGetDataset()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lines, err := ds.ZfsFields("list", "-p", "-t", "snapshot", "-d", "1", "-o", "name,creation", "-s", "creation")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	snaps := make([]PodSnapshot, 0, len(lines))
+	for _, fields := range lines {
+		if len(fields) != 2 {
+			return nil, errors.Errorf("Cannot parse zfs list line %#v", fields)
+		}
+		created, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Cannot parse zfs list line %#v", fields)
+		}
+		snaps = append(snaps, PodSnapshot{
+			Name:    fields[0][strings.Index(fields[0], "@")+1:],
+			Created: time.Unix(created, 0),
+		})
+	}
+	return snaps, nil
+}
+
+// Rollback rolls pod's app rootfs and volume datasets back to the
+// named snapshot, destroying any later snapshots. Pod's own
+// directory, holding its manifest, state, and logs, is not rolled
+// back. Returns ErrPodRunning if pod's jail is running.
+func (pod *Pod) Rollback(name string) error {
+	if err := validateSnapshotName(name); err != nil {
+		return errors.Trace(err)
+	}
+	if pod.Jid() != 0 {
+		return ErrPodRunning
+	}
+	ds, err := pod.mustGetDataset()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := ds.GetSnapshot(name); err != nil {
+		return errors.Annotatef(err, "Snapshot %v", name)
+	}
+	children, err := ds.Children(1, "-t", "filesystem")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Check all datasets before rolling back any, so that a failure
+	// doesn't leave some of them rolled back and others not
+	for _, checked := range append([]*zfs.Dataset{ds}, children...) {
+		if snaps, err := datasetSnapshots(checked); err != nil {
+			return errors.Trace(err)
+		} else if err := checkRollback(checked.Name, name, snaps); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	pod.ui.Printf("Rolling back to %v", name)
+	for _, child := range children {
+		pod.ui.Debugf("Rolling back %v", child.Name)
+		if err := child.RollbackTo(name, "-r"); err != nil {
+			return errors.Annotate(err, child.Name)
+		}
+	}
+	// Destroy later snapshots of the pod's dataset as well, so that
+	// snapshot list stays consistent.
+	snaps, err := pod.Snapshots()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i, snap := range snaps {
+		if snap.Name == name {
+			for _, later := range snaps[i+1:] {
+				if err := zfs.Zfs("destroy", ds.SnapshotName(later.Name)); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Snapshot of a dataset, as checked before rollback
+type datasetSnapshot struct {
+	// Name of the snapshot, without the dataset's name
+	Name string
+	// Clones of the snapshot
+	Clones []string
+}
+
+// Returns dataset's snapshots, oldest first
+func datasetSnapshots(ds *zfs.Dataset) ([]datasetSnapshot, error) {
+	lines, err := ds.ZfsFields("list", "-p", "-t", "snapshot", "-d", "1", "-o", "name,clones", "-s", "createtxg")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	snaps := make([]datasetSnapshot, 0, len(lines))
+	for _, fields := range lines {
+		if len(fields) != 2 {
+			return nil, errors.Errorf("Cannot parse zfs list line %#v", fields)
+		}
+		snap := datasetSnapshot{Name: fields[0][strings.Index(fields[0], "@")+1:]}
+		if fields[1] != "" && fields[1] != "-" {
+			snap.Clones = strings.Split(fields[1], ",")
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+// Checks that dataset, with snapshots listed oldest first, can be
+// rolled back to the named snapshot: the snapshot exists, and none of
+// the later snapshots, which the rollback destroys, has clones (such
+// as images committed from the pod).
+func checkRollback(dataset, name string, snaps []datasetSnapshot) error {
+	for i, snap := range snaps {
+		if snap.Name != name {
+			continue
+		}
+		for _, later := range snaps[i+1:] {
+			if len(later.Clones) > 0 {
+				return errors.Errorf("Cannot roll %v back to %v: later snapshot %v has clones: %v",
+					dataset, name, later.Name, strings.Join(later.Clones, ", "))
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("Cannot roll %v back to %v: no such snapshot", dataset, name)
+}
@@ -0,0 +1,37 @@
+package jetpack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSnapshotName(t *testing.T) {
+	for _, name := range []string{"before-upgrade", "20161001T120000Z", "v1.2", "a:b_c"} {
+		if err := validateSnapshotName(name); err != nil {
+			t.Errorf("%#v: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "parent", "commit-123", "export-123", "a@b", "a/b", "-x", "with space"} {
+		if err := validateSnapshotName(name); err == nil {
+			t.Errorf("%#v: expected error", name)
+		}
+	}
+}
+
+func TestCheckRollback(t *testing.T) {
+	snaps := []datasetSnapshot{
+		{Name: "parent", Clones: []string{"zroot/jetpack/pods/x/rootfs.1"}},
+		{Name: "before-upgrade"},
+		{Name: "commit-123", Clones: []string{"zroot/jetpack/images/123"}},
+		{Name: "after-upgrade"},
+	}
+	if err := checkRollback("zroot/jetpack/pods/x/rootfs.0", "after-upgrade", snaps); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := checkRollback("zroot/jetpack/pods/x/rootfs.0", "before-upgrade", snaps); err == nil || !strings.Contains(err.Error(), "commit-123") {
+		t.Errorf("Expected error for later snapshot with clones, got %v", err)
+	}
+	if err := checkRollback("zroot/jetpack/pods/x/rootfs.0", "nonexistent", snaps); err == nil {
+		t.Error("Expected error for missing snapshot")
+	}
+}
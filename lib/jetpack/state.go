@@ -0,0 +1,323 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+type AppStatus uint
+
+const (
+	AppStatusInvalid AppStatus = iota
+	AppStatusPrepared
+	AppStatusRunning
+	AppStatusExited
+	AppStatusKilled
+)
+
+var appStatusNames = []string{
+	AppStatusInvalid:  "invalid",
+	AppStatusPrepared: "prepared",
+	AppStatusRunning:  "running",
+	AppStatusExited:   "exited",
+	AppStatusKilled:   "killed",
+}
+
+func (as AppStatus) String() string {
+	if int(as) < len(appStatusNames) {
+		return appStatusNames[as]
+	}
+	return fmt.Sprintf("AppStatus[%d]", as)
+}
+
+func (as AppStatus) MarshalText() ([]byte, error) {
+	return []byte(as.String()), nil
+}
+
+func (as *AppStatus) UnmarshalText(text []byte) error {
+	for i, name := range appStatusNames {
+		if name == string(text) {
+			*as = AppStatus(i)
+			return nil
+		}
+	}
+	return errors.Errorf("Invalid app status: %#v", string(text))
+}
+
+// AppState is app's lifecycle state, persisted in the pod directory.
+type AppState struct {
+	Status   AppStatus
+	ExitCode int    `json:",omitempty"`
+	Signal   string `json:",omitempty"`
+	Restarts int    `json:",omitempty"`
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+
+	// PID of app's running command
+	Pid int `json:",omitempty"`
+	// PID of jetpack process that runs the app and records its state
+	Supervisor int `json:",omitempty"`
+	// Set by Pod.Stop; tells supervisor not to restart the app
+	StopRequested bool `json:",omitempty"`
+	// Signal sent by Pod.Stop; app that exits on it has stopped cleanly
+	StopSignal string `json:",omitempty"`
+	// Results of app's health checks, if it has any
+	Health *HealthState `json:",omitempty"`
+}
+
+func (st *AppState) String() string {
+	var rv string
+	switch {
+	case st.Status == AppStatusExited && st.Signal != "":
+		rv = fmt.Sprintf("%v(%v)", st.Status, st.Signal)
+	case st.Status == AppStatusExited:
+		rv = fmt.Sprintf("%v(%d)", st.Status, st.ExitCode)
+	case st.Status == AppStatusRunning && st.StopRequested:
+		rv = "stopping"
+	case st.Status == AppStatusRunning && st.Health != nil:
+		rv = fmt.Sprintf("%v(%v)", st.Status, st.Health.Status)
+	default:
+		rv = st.Status.String()
+	}
+	if st.Restarts > 0 {
+		rv += fmt.Sprintf("[%d restarts]", st.Restarts)
+	}
+	return rv
+}
+
+// Failed returns true if app has exited unsuccessfully, or was killed.
+// App that has exited on the stop signal has not failed.
+func (st *AppState) Failed() bool {
+	return st.Status == AppStatusKilled ||
+		(st.Status == AppStatusExited && (st.ExitCode != 0 || st.Signal != "") && !st.Stopped())
+}
+
+// Stopped returns true if app has exited on the signal sent by
+// Pod.Stop.
+func (st *AppState) Stopped() bool {
+	return st.Status == AppStatusExited && st.StopRequested &&
+		st.Signal != "" && st.Signal == st.StopSignal
+}
+
+// ExitStatus returns app's exit status as a shell would report it:
+// exit code, or 128+signal number if app was killed by a signal.
+func (st *AppState) ExitStatus() int {
+	switch st.Status {
+	case AppStatusExited:
+		if st.Stopped() {
+			return 0
+		}
+		if st.Signal != "" {
+			return 128 + st.signalNumber()
+		}
+		return st.ExitCode
+	case AppStatusKilled:
+		return 128 + int(syscall.SIGKILL)
+	default:
+		return 0
+	}
+}
+
+func (st *AppState) signalNumber() int {
+	for sig := syscall.Signal(1); sig < 64; sig++ {
+		if sig.String() == st.Signal {
+			return int(sig)
+		}
+	}
+	return 0
+}
+
+// Records final state of a finished command, based on the error
+// returned by running it.
+func (st *AppState) finish(err error, killed bool) {
+	st.Finished = time.Now()
+	st.ExitCode = 0
+	st.Signal = ""
+	st.Pid = 0
+	if killed {
+		st.Status = AppStatusKilled
+		return
+	}
+	st.Status = AppStatusExited
+	if err == nil {
+		return
+	}
+	if ws, ok := waitStatus(err); !ok {
+		// Could not even start
+		st.ExitCode = -1
+	} else if ws.Signaled() {
+		st.Signal = ws.Signal().String()
+	} else {
+		st.ExitCode = ws.ExitStatus()
+	}
+}
+
+func waitStatus(err error) (syscall.WaitStatus, bool) {
+	err = errors.Cause(err)
+	if cerr, ok := err.(*run.CmdError); ok {
+		err = cerr.ExecError
+	}
+	if xerr, ok := err.(*exec.ExitError); ok {
+		ws, ok := xerr.Sys().(syscall.WaitStatus)
+		return ws, ok
+	}
+	return 0, false
+}
+
+func (pod *Pod) statePath(name types.ACName) string {
+	return pod.Path("state", name.String()+".json")
+}
+
+// AppState returns persisted state of the named app. Pods prepared
+// before states were introduced have no state files; they get an
+// empty state with AppStatusInvalid.
+func (pod *Pod) AppState(name types.ACName) (*AppState, error) {
+	st := &AppState{}
+	if stateJSON, err := ioutil.ReadFile(pod.statePath(name)); os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	} else if err := json.Unmarshal(stateJSON, st); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return st, nil
+}
+
+func (pod *Pod) saveAppState(name types.ACName, st *AppState) error {
+	if err := os.MkdirAll(pod.Path("state"), 0750); err != nil {
+		return errors.Trace(err)
+	}
+	stateJSON, err := json.Marshal(st)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Write to a temporary file and rename, so that readers never see
+	// a partially written state.
+	tmpf, err := ioutil.TempFile(pod.Path("state"), ".tmp.")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = tmpf.Write(stateJSON)
+	if err2 := tmpf.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmpf.Name(), pod.statePath(name))
+	}
+	if err != nil {
+		os.Remove(tmpf.Name())
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Loads app's state, modifies it with fn, and saves it back. States
+// are modified by different processes (e.g. `jetpack run` and
+// `jetpack stop`), so the update is done with an exclusive lock on
+// pod's state lock file.
+func (pod *Pod) updateAppState(name types.ACName, fn func(*AppState)) error {
+	pod.stateMx.Lock()
+	defer pod.stateMx.Unlock()
+	if err := os.MkdirAll(pod.Path("state"), 0750); err != nil {
+		return errors.Trace(err)
+	}
+	lock, err := os.OpenFile(pod.Path("state", ".lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Trace(err)
+	}
+
+	st, err := pod.AppState(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fn(st)
+	return errors.Trace(pod.saveAppState(name, st))
+}
+
+// AppStates returns persisted states of all pod's apps, in manifest order.
+func (pod *Pod) AppStates() ([]*AppState, error) {
+	states := make([]*AppState, len(pod.Manifest.Apps))
+	for i, rtapp := range pod.Manifest.Apps {
+		if st, err := pod.AppState(rtapp.Name); err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			states[i] = st
+		}
+	}
+	return states, nil
+}
+
+// ExitStatus returns exit status of the first failed app that is not
+// optional, or zero if no such app has failed.
+func (pod *Pod) ExitStatus() (int, error) {
+	states, err := pod.AppStates()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	for i, st := range states {
+		if !st.Failed() {
+			continue
+		}
+		if optional, err := appOptional(&pod.Manifest.Apps[i]); err != nil {
+			return 0, errors.Trace(err)
+		} else if !optional {
+			return st.ExitStatus(), nil
+		}
+	}
+	return 0, nil
+}
+
+// Derives pod's status from its apps' states and from the jail
+func podStatus(jail JailStatus, states []*AppState) PodStatus {
+	if jail.Dying {
+		return PodStatusDying
+	}
+
+	var killed, failed, exited, prepared int
+	for _, st := range states {
+		switch {
+		case st.Status == AppStatusKilled:
+			killed++
+		case st.Failed():
+			failed++
+		case st.Status == AppStatusExited:
+			exited++
+		case st.Status == AppStatusPrepared:
+			prepared++
+		}
+	}
+
+	switch {
+	case jail.Jid != 0:
+		// Apps' states describe the pod only when its jail is gone;
+		// a running jail holds pod's addresses, mounts, and limits
+		// even if all apps have exited.
+		return PodStatusRunning
+	case killed > 0:
+		return PodStatusKilled
+	case failed > 0:
+		return PodStatusFailed
+	case exited > 0:
+		return PodStatusExited
+	case len(states) > 0 && prepared == len(states):
+		return PodStatusPrepared
+	default:
+		return PodStatusStopped
+	}
+}
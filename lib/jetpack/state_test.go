@@ -0,0 +1,134 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+func TestAppStateFinish(t *testing.T) {
+	for _, tc := range []struct {
+		script     string
+		killed     bool
+		str        string
+		exitStatus int
+	}{
+		{"exit 0", false, "exited(0)", 0},
+		{"exit 3", false, "exited(3)", 3},
+		{"kill -TERM $$", false, "exited(terminated)", 143},
+		{"kill -KILL $$", true, "killed", 137},
+	} {
+		st := &AppState{Status: AppStatusRunning}
+		st.finish(exitError(tc.script), tc.killed)
+		if actual := st.String(); actual != tc.str {
+			t.Errorf("%#v: expected state %#v, got %#v", tc.script, tc.str, actual)
+		}
+		if actual := st.ExitStatus(); actual != tc.exitStatus {
+			t.Errorf("%#v: expected exit status %d, got %d", tc.script, tc.exitStatus, actual)
+		}
+		if st.Failed() != (tc.exitStatus != 0) {
+			t.Errorf("%#v: unexpected Failed(): %v", tc.script, st.Failed())
+		}
+		if st.Finished.IsZero() {
+			t.Errorf("%#v: finish time not set", tc.script)
+		}
+	}
+
+	st := &AppState{Status: AppStatusRunning}
+	st.finish(errors.New("could not start"), false)
+	if st.ExitCode != -1 || !st.Failed() {
+		t.Errorf("Unexpected state for an unstarted command: %#v", st)
+	}
+}
+
+func TestAppStateJSON(t *testing.T) {
+	st := &AppState{Status: AppStatusExited, ExitCode: 2}
+	js, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st2 AppState
+	if err := json.Unmarshal(js, &st2); err != nil {
+		t.Fatal(err)
+	}
+	if st2.Status != AppStatusExited || st2.ExitCode != 2 {
+		t.Errorf("State did not survive JSON round trip: %s -> %#v", js, st2)
+	}
+	if err := json.Unmarshal([]byte(`{"Status":"bogus"}`), &st2); err == nil {
+		t.Error("Expected error for invalid status")
+	}
+}
+
+func TestPodStatus(t *testing.T) {
+	running := JailStatus{Jid: 1}
+	for i, tc := range []struct {
+		jail     JailStatus
+		states   []AppState
+		expected PodStatus
+	}{
+		{NoJailStatus, nil, PodStatusStopped},
+		{running, nil, PodStatusRunning},
+		{JailStatus{Jid: 1, Dying: true}, []AppState{{Status: AppStatusRunning}}, PodStatusDying},
+		{NoJailStatus, []AppState{{Status: AppStatusPrepared}, {Status: AppStatusPrepared}}, PodStatusPrepared},
+		{running, []AppState{{Status: AppStatusPrepared}}, PodStatusRunning},
+		{running, []AppState{{Status: AppStatusRunning}, {Status: AppStatusExited, ExitCode: 1}}, PodStatusRunning},
+		{NoJailStatus, []AppState{{Status: AppStatusRunning}}, PodStatusStopped},
+		{running, []AppState{{Status: AppStatusExited}, {Status: AppStatusExited}}, PodStatusRunning},
+		{running, []AppState{{Status: AppStatusExited, ExitCode: 1}, {Status: AppStatusKilled}}, PodStatusRunning},
+		{NoJailStatus, []AppState{{Status: AppStatusExited}, {Status: AppStatusExited}}, PodStatusExited},
+		{NoJailStatus, []AppState{{Status: AppStatusExited}, {Status: AppStatusExited, Signal: "killed"}}, PodStatusFailed},
+		{NoJailStatus, []AppState{{Status: AppStatusKilled}, {Status: AppStatusExited, ExitCode: 1}}, PodStatusKilled},
+	} {
+		states := make([]*AppState, len(tc.states))
+		for j := range tc.states {
+			states[j] = &tc.states[j]
+		}
+		if actual := podStatus(tc.jail, states); actual != tc.expected {
+			t.Errorf("Case %d: expected %v, got %v", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestUpdateAppStateConcurrently(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "jetpack-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Separate Pod values don't share the in-process mutex, like pods
+	// loaded by different Jetpack processes.
+	h := &Host{Dataset: &zfs.Dataset{Mountpoint: tmp}}
+	id := uuid.NewRandom()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(pod *Pod) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := pod.updateAppState("web", func(st *AppState) {
+					restarts := st.Restarts
+					time.Sleep(time.Millisecond)
+					st.Restarts = restarts + 1
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(&Pod{UUID: id, Host: h})
+	}
+	wg.Wait()
+
+	if st, err := (&Pod{UUID: id, Host: h}).AppState("web"); err != nil {
+		t.Fatal(err)
+	} else if st.Restarts != 100 {
+		t.Errorf("Expected 100 restarts, got %d", st.Restarts)
+	}
+}
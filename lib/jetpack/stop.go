@@ -0,0 +1,315 @@
+package jetpack
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+	"github.com/3ofcoins/jetpack/lib/ui"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// Parses signal name ("TERM", "SIGTERM") or number
+func parseSignal(v string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(v), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, errors.Errorf("Invalid signal %#v", v)
+}
+
+// StopSignal returns the signal that asks app to exit: the
+// jetpack/stop-signal annotation, or the stop.signal configuration
+// property.
+func (app *App) StopSignal() (syscall.Signal, error) {
+	v := Config().GetString("stop.signal", "TERM")
+	if rtapp := app.Pod.Manifest.Apps.Get(app.Name); rtapp != nil {
+		if av, ok := rtapp.Annotations.Get("jetpack/stop-signal"); ok {
+			v = av
+		}
+	}
+	sig, err := parseSignal(v)
+	return sig, errors.Trace(err)
+}
+
+// DefaultStopTimeout returns the stop.timeout configuration property
+func DefaultStopTimeout() time.Duration {
+	return time.Duration(Config().GetInt("stop.timeout", 10)) * time.Second
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Returns set of pids of processes running in pod's jail
+func (pod *Pod) jailPids() (map[int]bool, error) {
+	pids := make(map[int]bool)
+	jid := pod.Jid()
+	if jid == 0 {
+		return pids, nil
+	}
+	lines, err := run.Command("/bin/pgrep", "-j", strconv.Itoa(jid)).OutputLines()
+	if err != nil {
+		if ws, ok := waitStatus(err); ok && ws.ExitStatus() == 1 {
+			// No processes matched
+			return pids, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	for _, line := range lines {
+		if pid, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+			pids[pid] = true
+		}
+	}
+	return pids, nil
+}
+
+// Returns processes running in pod's jail, as a map of pid to parent
+// pid
+func (pod *Pod) jailProcesses() (map[int]int, error) {
+	procs := make(map[int]int)
+	jid := pod.Jid()
+	if jid == 0 {
+		return procs, nil
+	}
+	lines, err := run.Command("/bin/ps", "-ax", "-J", strconv.Itoa(jid), "-o", "pid=,ppid=").OutputLines()
+	if err != nil {
+		if ws, ok := waitStatus(err); ok && ws.ExitStatus() == 1 {
+			// No processes matched
+			return procs, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			procs[pid] = ppid
+		}
+	}
+	return procs, nil
+}
+
+// Assigns jail's processes (pid to parent pid) to apps whose main
+// processes (app to pid) they descend from. Returns pids of each
+// app's processes, main one first, and of processes that don't
+// belong to any app (e.g. daemons that have detached from their app).
+func appProcesses(procs map[int]int, mainPids map[types.ACName]int) (map[types.ACName][]int, []int) {
+	owners := make(map[int]types.ACName, len(mainPids))
+	byApp := make(map[types.ACName][]int, len(mainPids))
+	for name, pid := range mainPids {
+		if _, ok := procs[pid]; ok {
+			owners[pid] = name
+			byApp[name] = []int{pid}
+		}
+	}
+	pids := make([]int, 0, len(procs))
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	var others []int
+	for _, pid := range pids {
+		if _, ok := owners[pid]; ok {
+			continue
+		}
+		owner, found := types.ACName(""), false
+		// Walk up the tree while it's in the jail; parent pid loops
+		// end the walk too
+		seen := map[int]bool{}
+		for p, ok := procs[pid]; ok && !seen[p]; p, ok = procs[p] {
+			seen[p] = true
+			if owner, found = owners[p]; found {
+				break
+			}
+		}
+		if found {
+			byApp[owner] = append(byApp[owner], pid)
+		} else {
+			others = append(others, pid)
+		}
+	}
+	return byApp, others
+}
+
+func signalPids(pids []int, sig syscall.Signal) error {
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Sends sig to processes of apps that are running, and to other
+// processes in the jail. If sig is zero, sends each app's processes
+// its stop signal, other processes the default stop signal, and asks
+// apps' supervisors not to restart the apps.
+func (pod *Pod) signalApps(apps []*App, sig syscall.Signal) error {
+	procs, err := pod.jailProcesses()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	appSigs := make(map[types.ACName]syscall.Signal, len(apps))
+	mainPids := make(map[types.ACName]int, len(apps))
+	for _, app := range apps {
+		appSig := sig
+		if appSig == 0 {
+			if s, err := app.StopSignal(); err != nil {
+				return errors.Trace(err)
+			} else {
+				appSig = s
+			}
+			if err := pod.updateAppState(app.Name, func(st *AppState) {
+				st.StopRequested = true
+				st.StopSignal = appSig.String()
+			}); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		appSigs[app.Name] = appSig
+		st, err := app.State()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if st.Status == AppStatusRunning && st.Pid != 0 {
+			mainPids[app.Name] = st.Pid
+		}
+	}
+
+	// Only pids found in the jail are signalled, so that we don't
+	// signal an unrelated process that reused the pid.
+	byApp, others := appProcesses(procs, mainPids)
+	for _, app := range apps {
+		if pids := byApp[app.Name]; len(pids) > 0 {
+			pod.ui.Debugf("%v: sending %v to %v", app.Name, appSigs[app.Name], pids)
+			if err := signalPids(pids, appSigs[app.Name]); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if len(others) > 0 {
+		otherSig := sig
+		if otherSig == 0 {
+			if s, err := parseSignal(Config().GetString("stop.signal", "TERM")); err != nil {
+				return errors.Trace(err)
+			} else {
+				otherSig = s
+			}
+		}
+		pod.ui.Debugf("Sending %v to %v", otherSig, others)
+		if err := signalPids(others, otherSig); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Waits up to timeout until apps have finished, including their
+// post-stop handlers. Returns true if they have.
+func (pod *Pod) waitForApps(apps []*App, timeout time.Duration) (bool, error) {
+	spin := ui.NewSpinner("Waiting for apps to stop", ui.SuffixElapsed(), nil)
+	defer spin.Finish()
+	for deadline := time.Now().Add(timeout); ; {
+		pids, err := pod.jailPids()
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		running := 0
+		for _, app := range apps {
+			st, err := app.State()
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			switch {
+			case st.Supervisor != 0 && processAlive(st.Supervisor):
+				// Supervisor will run post-stop handlers and record the
+				// final state.
+				running++
+			case st.Status == AppStatusRunning && pids[st.Pid]:
+				// App has outlived its supervisor
+				running++
+			case st.Status == AppStatusRunning:
+				// App without supervisor has exited
+				if err := app.orphanExited(); err != nil {
+					return false, errors.Trace(err)
+				}
+			}
+		}
+		if running == 0 {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		spin.Step()
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// Runs post-stop handlers and records final state of an app whose
+// supervisor is gone.
+func (app *App) orphanExited() error {
+	for _, eh := range app.app.EventHandlers {
+		if eh.Name == "post-stop" {
+			if err := app.Stage2(nil, os.Stdout, os.Stderr, "0", "0", "", eh.Exec...); err != nil {
+				app.Pod.ui.Printf("%v: post-stop: %v", app.Name, err)
+			}
+		}
+	}
+	return errors.Trace(app.Pod.updateAppState(app.Name, func(st *AppState) {
+		// Exit status has been lost with the supervisor
+		st.finish(nil, false)
+	}))
+}
+
+// Stop stops the pod gracefully. Each running app is sent its stop
+// signal, and has up to timeout to exit and run its post-stop
+// handlers. Apps that are still running after that are killed with
+// SIGKILL, and get another timeout to run post-stop handlers. Finally,
+// the jail is removed.
+func (pod *Pod) Stop(timeout time.Duration) error {
+	if pod.Jid() == 0 {
+		return nil
+	}
+	pod.ui.Println("Stopping")
+	apps := pod.Apps()
+	if err := pod.signalApps(apps, 0); err != nil {
+		return errors.Trace(err)
+	}
+	if stopped, err := pod.waitForApps(apps, timeout); err != nil {
+		return errors.Trace(err)
+	} else if !stopped {
+		pod.ui.Printf("Apps still running after %v, killing", timeout)
+		if err := pod.signalApps(apps, syscall.SIGKILL); err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := pod.waitForApps(apps, timeout); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(pod.Kill())
+}
@@ -0,0 +1,102 @@
+package jetpack
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestParseSignal(t *testing.T) {
+	for v, expected := range map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"SIGTERM": syscall.SIGTERM,
+		"sigquit": syscall.SIGQUIT,
+		"INT":     syscall.SIGINT,
+		"9":       syscall.SIGKILL,
+	} {
+		if sig, err := parseSignal(v); err != nil {
+			t.Errorf("%#v: unexpected error: %v", v, err)
+		} else if sig != expected {
+			t.Errorf("%#v: expected %v, got %v", v, expected, sig)
+		}
+	}
+
+	for _, v := range []string{"", "BOGUS", "-1", "0"} {
+		if _, err := parseSignal(v); err == nil {
+			t.Errorf("%#v: expected error", v)
+		}
+	}
+}
+
+func TestAppStopSignal(t *testing.T) {
+	pod := &Pod{Manifest: *schema.BlankPodManifest()}
+	rtapp := schema.RuntimeApp{Name: "db"}
+	rtapp.Annotations.Set("jetpack/stop-signal", "SIGINT")
+	pod.Manifest.Apps = append(pod.Manifest.Apps, rtapp, schema.RuntimeApp{Name: "web"})
+
+	if sig, err := (&App{Name: "db", Pod: pod}).StopSignal(); err != nil || sig != syscall.SIGINT {
+		t.Errorf("Expected SIGINT, got %v, %v", sig, err)
+	}
+	if sig, err := (&App{Name: "web", Pod: pod}).StopSignal(); err != nil || sig != syscall.SIGTERM {
+		t.Errorf("Expected default SIGTERM, got %v, %v", sig, err)
+	}
+}
+
+func TestAppStateStopping(t *testing.T) {
+	st := &AppState{Status: AppStatusRunning, Pid: 42, StopRequested: true}
+	if st.String() != "stopping" {
+		t.Errorf("Expected stopping, got %v", st)
+	}
+	st.finish(nil, false)
+	if st.Pid != 0 {
+		t.Error("Pid not cleared by finish")
+	}
+}
+
+func TestAppStateStopped(t *testing.T) {
+	term := syscall.SIGTERM.String()
+	st := &AppState{Status: AppStatusExited, Signal: term, StopRequested: true, StopSignal: term}
+	if !st.Stopped() || st.Failed() || st.ExitStatus() != 0 {
+		t.Errorf("App that exited on stop signal: stopped=%v failed=%v status=%d", st.Stopped(), st.Failed(), st.ExitStatus())
+	}
+
+	st.StopSignal = syscall.SIGINT.String()
+	if st.Stopped() || !st.Failed() || st.ExitStatus() != 128+int(syscall.SIGTERM) {
+		t.Errorf("App that exited on other signal: stopped=%v failed=%v status=%d", st.Stopped(), st.Failed(), st.ExitStatus())
+	}
+
+	st = &AppState{Status: AppStatusExited, Signal: term}
+	if st.Stopped() || !st.Failed() {
+		t.Error("App that exited on signal without stop counted as stopped")
+	}
+}
+
+func TestAppProcesses(t *testing.T) {
+	procs := map[int]int{
+		10: 5,  // db's main process, started by supervisor
+		11: 10, // db worker
+		12: 11, // db worker's child
+		20: 6,  // web's main process
+		21: 20,
+		30: 1,  // daemon detached from its app
+		31: 30, // its child
+	}
+	byApp, others := appProcesses(procs, map[types.ACName]int{
+		"db":   10,
+		"web":  20,
+		"gone": 40, // pid not in the jail (anymore)
+	})
+	expected := map[types.ACName][]int{
+		"db":  {10, 11, 12},
+		"web": {20, 21},
+	}
+	if !reflect.DeepEqual(byApp, expected) {
+		t.Errorf("Expected %v, got %v", expected, byApp)
+	}
+	if !reflect.DeepEqual(others, []int{30, 31}) {
+		t.Errorf("Expected other processes [30 31], got %v", others)
+	}
+}
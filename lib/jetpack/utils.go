@@ -10,6 +10,7 @@ import "io"
 import "net"
 
 import "github.com/appc/spec/aci"
+import "github.com/appc/spec/schema"
 import "github.com/appc/spec/schema/types"
 import "github.com/juju/errors"
 
@@ -20,6 +21,86 @@ func ConsoleApp(username string) *types.App {
 	}
 }
 
+// Returns app that runtime app will run. App in a pod manifest
+// replaces image's app; an override built from command line flags is
+// merged on top of it, and stored as runtime app's app.
+func reifyApp(imgApp *types.App, rtapp *schema.RuntimeApp, override *types.App) (*types.App, error) {
+	base := imgApp
+	if rtapp.App != nil {
+		base = rtapp.App
+	}
+	if override == nil {
+		return base, nil
+	}
+	if base == nil {
+		if len(override.Exec) == 0 {
+			return nil, errors.New("Image has no app, -exec is required")
+		}
+		base = &types.App{User: "0", Group: "0"}
+	}
+	rtapp.App = mergeApp(base, override)
+	return rtapp.App, nil
+}
+
+// Returns app override merged on top of image's app. Fields that are
+// not set in override are taken from base; environment variables and
+// event handlers are merged by name.
+func mergeApp(base, override *types.App) *types.App {
+	merged := *base
+	if len(override.Exec) > 0 {
+		merged.Exec = override.Exec
+	}
+	if override.User != "" {
+		merged.User = override.User
+	}
+	if override.Group != "" {
+		merged.Group = override.Group
+	}
+	if len(override.SupplementaryGIDs) > 0 {
+		merged.SupplementaryGIDs = override.SupplementaryGIDs
+	}
+	if override.WorkingDirectory != "" {
+		merged.WorkingDirectory = override.WorkingDirectory
+	}
+	if len(override.MountPoints) > 0 {
+		merged.MountPoints = override.MountPoints
+	}
+	if len(override.Ports) > 0 {
+		merged.Ports = override.Ports
+	}
+	if len(override.Isolators) > 0 {
+		merged.Isolators = override.Isolators
+	}
+
+	merged.Environment = append(types.Environment(nil), base.Environment...)
+	for _, ev := range override.Environment {
+		merged.Environment.Set(ev.Name, ev.Value)
+	}
+
+	merged.EventHandlers = nil
+	for _, eh := range base.EventHandlers {
+		keep := true
+		for _, oeh := range override.EventHandlers {
+			if oeh.Name == eh.Name {
+				keep = false
+			}
+		}
+		if keep {
+			merged.EventHandlers = append(merged.EventHandlers, eh)
+		}
+	}
+	merged.EventHandlers = append(merged.EventHandlers, override.EventHandlers...)
+
+	// App is not valid without user and group
+	if merged.User == "" {
+		merged.User = "0"
+	}
+	if merged.Group == "" {
+		merged.Group = "0"
+	}
+	return &merged
+}
+
 func nextIP(ip net.IP) net.IP {
 	for i := len(ip) - 1; i >= 0; i-- {
 		ip[i] += 1
@@ -30,6 +111,17 @@ func nextIP(ip net.IP) net.IP {
 	return nil
 }
 
+// Removes annotation from anns, if it is set
+func unsetAnnotation(anns *types.Annotations, name types.ACIdentifier) {
+	kept := (*anns)[:0]
+	for _, ann := range *anns {
+		if ann.Name != name {
+			kept = append(kept, ann)
+		}
+	}
+	*anns = kept
+}
+
 // FIXME: mostly copy/paste from github.com/appc/spec/actool/validate.go
 func DecompressingReader(rd io.Reader) (io.Reader, error) {
 	brd := bufio.NewReaderSize(rd, 1024)
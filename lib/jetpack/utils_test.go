@@ -0,0 +1,108 @@
+package jetpack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestMergeApp(t *testing.T) {
+	base := &types.App{
+		Exec:  types.Exec{"/usr/local/bin/server"},
+		User:  "www",
+		Group: "www",
+		EventHandlers: []types.EventHandler{
+			{Name: "pre-start", Exec: types.Exec{"/bin/setup"}},
+			{Name: "post-stop", Exec: types.Exec{"/bin/cleanup"}},
+		},
+		Environment: types.Environment{{Name: "PORT", Value: "80"}, {Name: "DEBUG", Value: "0"}},
+		Ports:       []types.Port{{Name: "http", Protocol: "tcp", Port: 80}},
+	}
+	override := &types.App{
+		Exec:          types.Exec{"/usr/local/bin/server", "-v"},
+		EventHandlers: []types.EventHandler{{Name: "pre-start", Exec: types.Exec{"/bin/migrate"}}},
+		Environment:   types.Environment{{Name: "DEBUG", Value: "1"}, {Name: "EXTRA", Value: "x"}},
+	}
+	expected := &types.App{
+		Exec:  types.Exec{"/usr/local/bin/server", "-v"},
+		User:  "www",
+		Group: "www",
+		EventHandlers: []types.EventHandler{
+			{Name: "post-stop", Exec: types.Exec{"/bin/cleanup"}},
+			{Name: "pre-start", Exec: types.Exec{"/bin/migrate"}},
+		},
+		Environment: types.Environment{{Name: "PORT", Value: "80"}, {Name: "DEBUG", Value: "1"}, {Name: "EXTRA", Value: "x"}},
+		Ports:       []types.Port{{Name: "http", Protocol: "tcp", Port: 80}},
+	}
+	if merged := mergeApp(base, override); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, merged)
+	}
+	if len(base.Environment) != 2 || base.Environment[1].Value != "0" {
+		t.Errorf("Base app has been modified: %#v", base.Environment)
+	}
+
+	merged := mergeApp(ConsoleApp("root"), &types.App{WorkingDirectory: "/tmp"})
+	if merged.User != "root" || merged.Group != "0" || merged.WorkingDirectory != "/tmp" {
+		t.Errorf("Unexpected app merged onto console: %#v", merged)
+	}
+}
+
+func TestReifyApp(t *testing.T) {
+	imgApp := &types.App{
+		Exec:        types.Exec{"/usr/local/bin/server"},
+		User:        "www",
+		Group:       "www",
+		Environment: types.Environment{{Name: "PORT", Value: "80"}},
+	}
+
+	// App in a pod manifest replaces image's app, whatever its
+	// annotations say
+	manifestApp := &types.App{Exec: types.Exec{"/bin/true"}, User: "0", Group: "0"}
+	rtapp := &schema.RuntimeApp{App: manifestApp}
+	rtapp.Annotations.Set("jetpack/app-override", "true")
+	if app, err := reifyApp(imgApp, rtapp, nil); err != nil {
+		t.Error(err)
+	} else if app != manifestApp {
+		t.Errorf("Expected manifest's app, got %#v", app)
+	}
+
+	if app, err := reifyApp(imgApp, &schema.RuntimeApp{}, nil); err != nil {
+		t.Error(err)
+	} else if app != imgApp {
+		t.Errorf("Expected image's app, got %#v", app)
+	}
+
+	// Override is merged
+	rtapp = &schema.RuntimeApp{}
+	if app, err := reifyApp(imgApp, rtapp, &types.App{Environment: types.Environment{{Name: "FOO", Value: "bar"}}}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(app.Exec, imgApp.Exec) || len(app.Environment) != 2 {
+		t.Errorf("Override not merged onto image's app: %#v", app)
+	} else if rtapp.App != app {
+		t.Errorf("Runtime app not updated: %#v", rtapp.App)
+	} else if len(imgApp.Environment) != 1 {
+		t.Errorf("Image's app has been modified: %#v", imgApp)
+	}
+
+	// Image without an app needs -exec
+	if _, err := reifyApp(nil, &schema.RuntimeApp{}, &types.App{Environment: types.Environment{{Name: "FOO", Value: "bar"}}}); err == nil {
+		t.Error("Expected error for image without app and override without exec")
+	}
+	if app, err := reifyApp(nil, &schema.RuntimeApp{}, &types.App{Exec: types.Exec{"/bin/date"}}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(app.Exec, types.Exec{"/bin/date"}) || app.User != "0" || app.Group != "0" {
+		t.Errorf("Unexpected app: %#v", app)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := mergeEnv(
+		[]string{"PATH=/bin", "FOO=bar", "FOOBAR=baz"},
+		[]string{"FOO=quux", "NEW=1", "EMPTY="},
+	)
+	if expected := []string{"PATH=/bin", "FOO=quux", "FOOBAR=baz", "NEW=1", "EMPTY="}; !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+}
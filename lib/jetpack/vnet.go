@@ -0,0 +1,215 @@
+package jetpack
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/run"
+)
+
+// vnet describes networking of a pod running in a VNET jail. The pod
+// gets an epair(4) interface: host side is a member of a bridge(4)
+// with host's address, and jail side has pod's addresses, with the
+// host as the default gateway.
+type vnet struct {
+	// Name prefix of the epair: host side is Name+"a", jail side is
+	// Name+"b". Interface names are limited to 15 characters.
+	Name string
+	// Description of the epair's host side: pod's UUID, to tell
+	// pod's epair from another one with the same name.
+	Description string
+	Bridge      string
+	// Pod's addresses, with the host network's prefix length
+	Addrs []*net.IPNet
+	// Default gateways (host's addresses)
+	Gateways []net.IP
+}
+
+func (v *vnet) HostSide() string { return v.Name + "a" }
+func (v *vnet) JailSide() string { return v.Name + "b" }
+
+// Returns commands that rename a newly created epair, whose "a" side
+// is named epair, and add its host side to the bridge.
+func (v *vnet) createCommands(epair string) [][]string {
+	epairB := strings.TrimSuffix(epair, "a") + "b"
+	return [][]string{
+		{"/sbin/ifconfig", epair, "name", v.HostSide()},
+		{"/sbin/ifconfig", epairB, "name", v.JailSide()},
+		{"/sbin/ifconfig", v.HostSide(), "description", v.Description},
+		{"/sbin/ifconfig", v.Bridge, "addm", v.HostSide()},
+		{"/sbin/ifconfig", v.HostSide(), "up"},
+	}
+}
+
+// Returns commands that configure loopback, pod's addresses, and
+// default routes in the jail with the given jid. The commands are run
+// on the host: jail's root has only the apps, not the base system.
+func (v *vnet) jailCommands(jid int) [][]string {
+	j := strconv.Itoa(jid)
+	cmds := [][]string{{"/sbin/ifconfig", "-j", j, "lo0", "inet", "127.0.0.1/8", "up"}}
+	for _, addr := range v.Addrs {
+		family := "inet"
+		if addr.IP.To4() == nil {
+			family = "inet6"
+		}
+		ones, _ := addr.Mask.Size()
+		cmd := []string{"/sbin/ifconfig", "-j", j, v.JailSide(), family, addr.IP.String() + "/" + strconv.Itoa(ones)}
+		if family == "inet6" {
+			cmd = append(cmd, "-ifdisabled")
+		}
+		cmds = append(cmds, append(cmd, "up"))
+	}
+	for _, gw := range v.Gateways {
+		if gw.To4() == nil {
+			cmds = append(cmds, []string{"/sbin/route", "-j", j, "-q", "add", "-inet6", "default", gw.String()})
+		} else {
+			cmds = append(cmds, []string{"/sbin/route", "-j", j, "-q", "add", "-inet", "default", gw.String()})
+		}
+	}
+	return cmds
+}
+
+// Returns commands that remove the epair. Destroying one side
+// destroys both.
+func (v *vnet) destroyCommands() [][]string {
+	return [][]string{{"/sbin/ifconfig", v.HostSide(), "destroy"}}
+}
+
+func runCommands(cmds [][]string) error {
+	for _, cmd := range cmds {
+		if err := run.Command(cmd[0], cmd[1:]...).Run(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Returns name of pod's epair: 12 hex digits of pod's UUID, as
+// interface names are limited to 15 characters.
+func vnetName(id uuid.UUID) string {
+	return "jp" + strings.Replace(id.String(), "-", "", -1)[:12]
+}
+
+// Returns vnet.bridge setting, which is required for VNET pods
+func vnetBridge() (string, error) {
+	if bridge := Config().GetString("vnet.bridge", ""); bridge != "" {
+		return bridge, nil
+	}
+	return "", errors.New("vnet.bridge is not set")
+}
+
+// Checks that vnet.bridge is a bridge(4) interface
+func checkVnetBridge() error {
+	bridge, err := vnetBridge()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bridges, err := run.Command("/sbin/ifconfig", "-g", "bridge").OutputLines()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, b := range bridges {
+		if strings.TrimSpace(b) == bridge {
+			return nil
+		}
+	}
+	return errors.Errorf("vnet.bridge %v is not a bridge interface", bridge)
+}
+
+// Returns interface's description from ifconfig's output
+func ifconfigDescription(lines []string) string {
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "description: ") {
+			return strings.TrimPrefix(line, "description: ")
+		}
+	}
+	return ""
+}
+
+// Returns pod's VNET networking, or nil if pod doesn't use VNET
+func (pod *Pod) vnet() (*vnet, error) {
+	if mode, _ := pod.Manifest.Annotations.Get(NetworkModeAnnotation); mode != NetworkModeVNET {
+		return nil, nil
+	}
+	bridge, err := vnetBridge()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	v := &vnet{
+		Name:        vnetName(pod.UUID),
+		Description: pod.UUID.String(),
+		Bridge:      bridge,
+	}
+	for _, fam := range []struct {
+		annotation string
+		v6         bool
+	}{
+		{IP4AddressAnnotation, false},
+		{IP6AddressAnnotation, true},
+	} {
+		ipStr, ok := pod.Manifest.Annotations.Get(fam.annotation)
+		if !ok {
+			continue
+		}
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, errors.Errorf("Invalid pod address %#v", ipStr)
+		}
+		hostip, ipnet, err := pod.Host.interfaceAddr(fam.v6)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		v.Addrs = append(v.Addrs, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+		v.Gateways = append(v.Gateways, hostip)
+	}
+	return v, nil
+}
+
+// Creates pod's epair before the jail is created
+func (pod *Pod) createVnet(v *vnet) error {
+	if _, err := net.InterfaceByName(v.HostSide()); err == nil {
+		// Epair left over from pod's crashed run can be removed, but
+		// not another pod's.
+		lines, err := run.Command("/sbin/ifconfig", v.HostSide()).OutputLines()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if desc := ifconfigDescription(lines); desc != v.Description {
+			return errors.Errorf("Interface %v already exists (%v)", v.HostSide(), desc)
+		}
+		if err := pod.destroyVnet(v); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	pod.ui.Debugf("Creating epair %v on %v", v.Name, v.Bridge)
+	epair, err := run.Command("/sbin/ifconfig", "epair", "create").OutputString()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := runCommands(v.createCommands(strings.TrimSpace(epair))); err != nil {
+		run.Command("/sbin/ifconfig", strings.TrimSpace(epair), "destroy").Run()
+		pod.destroyVnet(v)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Configures network inside a freshly created jail
+func (pod *Pod) configureVnet(v *vnet, jid int) error {
+	pod.ui.Debug("Configuring network in jail", jid)
+	return errors.Trace(runCommands(v.jailCommands(jid)))
+}
+
+// Removes pod's epair after the jail is gone. It's fine if it's
+// already gone.
+func (pod *Pod) destroyVnet(v *vnet) error {
+	if _, err := net.InterfaceByName(v.HostSide()); err != nil {
+		return nil
+	}
+	pod.ui.Debug("Destroying epair", v.Name)
+	return errors.Trace(runCommands(v.destroyCommands()))
+}
@@ -0,0 +1,116 @@
+package jetpack
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/pborman/uuid"
+)
+
+func testVnet() *vnet {
+	return &vnet{
+		Name:        "jp0123abcd",
+		Description: "0123abcd-0000-4000-8000-000000000000",
+		Bridge:      "bridge0",
+		Addrs: []*net.IPNet{
+			{IP: net.ParseIP("172.23.0.2"), Mask: net.CIDRMask(16, 32)},
+			{IP: net.ParseIP("fd00:23::2"), Mask: net.CIDRMask(64, 128)},
+		},
+		Gateways: []net.IP{net.ParseIP("172.23.0.1"), net.ParseIP("fd00:23::1")},
+	}
+}
+
+func TestVnetCommands(t *testing.T) {
+	v := testVnet()
+
+	expected := [][]string{
+		{"/sbin/ifconfig", "epair3a", "name", "jp0123abcda"},
+		{"/sbin/ifconfig", "epair3b", "name", "jp0123abcdb"},
+		{"/sbin/ifconfig", "jp0123abcda", "description", "0123abcd-0000-4000-8000-000000000000"},
+		{"/sbin/ifconfig", "bridge0", "addm", "jp0123abcda"},
+		{"/sbin/ifconfig", "jp0123abcda", "up"},
+	}
+	if actual := v.createCommands("epair3a"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	expected = [][]string{
+		{"/sbin/ifconfig", "-j", "42", "lo0", "inet", "127.0.0.1/8", "up"},
+		{"/sbin/ifconfig", "-j", "42", "jp0123abcdb", "inet", "172.23.0.2/16", "up"},
+		{"/sbin/ifconfig", "-j", "42", "jp0123abcdb", "inet6", "fd00:23::2/64", "-ifdisabled", "up"},
+		{"/sbin/route", "-j", "42", "-q", "add", "-inet", "default", "172.23.0.1"},
+		{"/sbin/route", "-j", "42", "-q", "add", "-inet6", "default", "fd00:23::1"},
+	}
+	if actual := v.jailCommands(42); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	expected = [][]string{{"/sbin/ifconfig", "jp0123abcda", "destroy"}}
+	if actual := v.destroyCommands(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestPodNetworkMode(t *testing.T) {
+	pm := schema.BlankPodManifest()
+	pm.Annotations.Set(NetworkModeAnnotation, NetworkModeVNET)
+	if mode, err := podNetworkMode(pm); err != nil || mode != NetworkModeVNET {
+		t.Errorf("Expected %v, got %v (%v)", NetworkModeVNET, mode, err)
+	}
+	pm.Annotations.Set(NetworkModeAnnotation, "bridge")
+	if _, err := podNetworkMode(pm); err == nil {
+		t.Error("Expected error for invalid network mode")
+	}
+}
+
+// Jail's root has no base system, so commands configuring the jail's
+// network need to run on the host, targeting the jail by its jid.
+func TestVnetJailCommandsRunOnHost(t *testing.T) {
+	for _, cmd := range testVnet().jailCommands(42) {
+		if cmd[0] != "/sbin/ifconfig" && cmd[0] != "/sbin/route" {
+			t.Errorf("Command not run by host's ifconfig or route: %v", cmd)
+		}
+		if len(cmd) < 3 || cmd[1] != "-j" || cmd[2] != "42" {
+			t.Errorf("Command doesn't target jail 42: %v", cmd)
+		}
+	}
+}
+
+func TestVnetName(t *testing.T) {
+	id := uuid.Parse("0123abcd-ef01-4567-89ab-cdef01234567")
+	if name := vnetName(id); name != "jp0123abcdef01" {
+		t.Errorf("Unexpected epair name %v", name)
+	}
+	if len(vnetName(id)+"a") > 15 {
+		t.Error("Epair name too long")
+	}
+	// Pods whose UUIDs share first 8 hex digits get different epairs
+	if vnetName(id) == vnetName(uuid.Parse("0123abcd-ef02-4567-89ab-cdef01234567")) {
+		t.Error("Epair names collide")
+	}
+}
+
+func TestVnetBridgeRequired(t *testing.T) {
+	Config().Delete("vnet.bridge")
+	pod := &Pod{UUID: uuid.NewRandom(), Manifest: *schema.BlankPodManifest()}
+	pod.Manifest.Annotations.Set(NetworkModeAnnotation, NetworkModeVNET)
+	if _, err := pod.vnet(); err == nil {
+		t.Error("Expected error when vnet.bridge is not set")
+	}
+}
+
+func TestIfconfigDescription(t *testing.T) {
+	lines := []string{
+		"jp0123abcda: flags=8843<UP,BROADCAST,RUNNING,SIMPLEX,MULTICAST> metric 0 mtu 1500",
+		"\tdescription: 0123abcd-0000-4000-8000-000000000000",
+		"\toptions=8<VLAN_MTU>",
+	}
+	if desc := ifconfigDescription(lines); desc != "0123abcd-0000-4000-8000-000000000000" {
+		t.Errorf("Unexpected description %#v", desc)
+	}
+	if desc := ifconfigDescription(lines[:1]); desc != "" {
+		t.Errorf("Unexpected description %#v", desc)
+	}
+}
@@ -0,0 +1,234 @@
+package jetpack
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+// Prefix of a host volume's source that refers to a named volume,
+// e.g. "@data". It is replaced with named volume's path when the pod
+// manifest is reified.
+const NamedVolumePrefix = "@"
+
+// Prefix of pod annotations that record which named volume a pod
+// volume refers to, e.g. "jetpack/volume/data" = "blog-db".
+const NamedVolumeAnnotationPrefix = "jetpack/volume/"
+
+// ZFS properties shown by `jetpack volume inspect`
+var VolumeProperties = []string{"creation", "used", "available", "quota", "reservation", "compression", "recordsize"}
+
+// Volume is a named, persistent ZFS dataset that pods can mount. The
+// appc spec allows only "empty" and "host" volume kinds (pod manifests
+// with other kinds don't validate), so pods refer to a named volume
+// with a "host" volume whose source is the named volume's path, and
+// record volume's name in an annotation.
+type Volume struct {
+	Name    types.ACName
+	Host    *Host
+	Dataset *zfs.Dataset
+}
+
+// Returns the dataset holding named volumes, creating it if needed
+func (h *Host) volumesDataset() (*zfs.Dataset, error) {
+	if ds, err := h.Dataset.GetDataset("volumes"); err == nil {
+		return ds, nil
+	} else if err != zfs.ErrNotFound {
+		return nil, errors.Trace(err)
+	}
+	dsOptions := h.zfsOptions("volumes.zfs.")
+	h.ui.Printf("Creating ZFS dataset %v %v", h.Dataset.ChildName("volumes"), dsOptions)
+	ds, err := h.Dataset.CreateDataset("volumes", dsOptions...)
+	return ds, errors.Trace(err)
+}
+
+// CreateVolume creates a new named volume, with given ZFS properties
+// (e.g. quota or compression) set.
+func (h *Host) CreateVolume(name types.ACName, props map[string]string) (*Volume, error) {
+	if _, err := h.GetVolume(name); err == nil {
+		return nil, errors.Errorf("Volume %v already exists", name)
+	} else if err != ErrNotFound {
+		return nil, errors.Trace(err)
+	}
+	vds, err := h.volumesDataset()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var args []string
+	for k, v := range props {
+		args = append(args, "-o", k+"="+v)
+	}
+	ds, err := vds.CreateDataset(name.String(), args...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Volume{Name: name, Host: h, Dataset: ds}, nil
+}
+
+func (h *Host) GetVolume(name types.ACName) (*Volume, error) {
+	ds, err := h.Dataset.GetDataset(filepath.Join("volumes", name.String()))
+	if err == zfs.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Volume{Name: name, Host: h, Dataset: ds}, nil
+}
+
+func (h *Host) Volumes() ([]*Volume, error) {
+	vds, err := h.Dataset.GetDataset("volumes")
+	if err == zfs.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	children, err := vds.Children(1, "-t", "filesystem")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	vols := make([]*Volume, 0, len(children))
+	for _, ds := range children {
+		name, err := types.NewACName(filepath.Base(ds.Name))
+		if err != nil {
+			h.ui.Printf("WARNING: %v: %v", ds.Name, err)
+			continue
+		}
+		vols = append(vols, &Volume{Name: *name, Host: h, Dataset: ds})
+	}
+	return vols, nil
+}
+
+// Returns named volume that a pod volume refers to, or nil if pod
+// volume is not a named volume.
+func (h *Host) namedVolume(vol types.Volume) (*Volume, error) {
+	if vol.Kind != "host" {
+		return nil, nil
+	}
+	vds, err := h.Dataset.GetDataset("volumes")
+	if err == zfs.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dir, base := filepath.Split(filepath.Clean(vol.Source))
+	if filepath.Clean(dir) != vds.Mountpoint {
+		return nil, nil
+	}
+	name, err := types.NewACName(base)
+	if err != nil {
+		return nil, errors.Annotate(err, vol.Source)
+	}
+	v, err := h.GetVolume(*name)
+	if err == ErrNotFound {
+		return nil, errors.Errorf("Named volume %v not found", name)
+	}
+	return v, errors.Trace(err)
+}
+
+// Returns named volume that a "@NAME" volume source refers to, or nil
+// if the source is not a named volume reference.
+func namedVolumeRef(vol types.Volume) (*types.ACName, error) {
+	if vol.Kind != "host" || !strings.HasPrefix(vol.Source, NamedVolumePrefix) {
+		return nil, nil
+	}
+	return types.NewACName(vol.Source[len(NamedVolumePrefix):])
+}
+
+// Returns named volume that a pod volume refers to, either with a
+// "@NAME" reference or with the volume's path, or nil.
+func (h *Host) lookupNamedVolume(vol types.Volume) (*Volume, error) {
+	name, err := namedVolumeRef(vol)
+	if err != nil {
+		return nil, errors.Trace(err)
+	} else if name == nil {
+		return h.namedVolume(vol)
+	}
+	v, err := h.GetVolume(*name)
+	if err == ErrNotFound {
+		return nil, errors.Errorf("Named volume %v not found", name)
+	}
+	return v, errors.Trace(err)
+}
+
+func (h *Host) reifyNamedVolumes(pm *schema.PodManifest) error {
+	return reifyNamedVolumes(pm, h.lookupNamedVolume)
+}
+
+// Replaces named volume references (host volumes with source "@NAME")
+// with named volumes' paths, and records names of named volumes that
+// pod uses in pod's annotations.
+func reifyNamedVolumes(pm *schema.PodManifest, lookup func(types.Volume) (*Volume, error)) error {
+	for i, vol := range pm.Volumes {
+		v, err := lookup(vol)
+		if err != nil {
+			return errors.Annotatef(err, "Volume %v", vol.Name)
+		} else if v == nil {
+			continue
+		}
+		pm.Volumes[i].Source = v.Path()
+		pm.Annotations.Set(namedVolumeAnnotation(vol.Name), v.Name.String())
+	}
+	return nil
+}
+
+func namedVolumeAnnotation(name types.ACName) types.ACIdentifier {
+	return types.ACIdentifier(NamedVolumeAnnotationPrefix + name.String())
+}
+
+// Returns true if pod manifest records use of named volume
+func usesNamedVolume(pm *schema.PodManifest, name types.ACName) bool {
+	for _, ann := range pm.Annotations {
+		if strings.HasPrefix(ann.Name.String(), NamedVolumeAnnotationPrefix) && ann.Value == name.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Volume) Path() string {
+	return v.Dataset.Mountpoint
+}
+
+// Properties returns human-readable values of VolumeProperties
+func (v *Volume) Properties() (map[string]string, error) {
+	lines, err := v.Dataset.ZfsFields("get", "-o", "property,value", strings.Join(VolumeProperties, ","))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	props := make(map[string]string, len(lines))
+	for _, fields := range lines {
+		if len(fields) == 2 {
+			props[fields[0]] = fields[1]
+		}
+	}
+	return props, nil
+}
+
+// Pods returns pods that mount the volume
+func (v *Volume) Pods() []*Pod {
+	var pods []*Pod
+	for _, pod := range v.Host.Pods() {
+		if usesNamedVolume(&pod.Manifest, v.Name) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// Destroy destroys the volume with all its data. A volume that is
+// used by any pod can't be destroyed.
+func (v *Volume) Destroy() error {
+	if pods := v.Pods(); len(pods) > 0 {
+		ids := make([]string, len(pods))
+		for i, pod := range pods {
+			ids[i] = pod.ID()
+		}
+		return errors.Errorf("Volume %v is used by pods: %v", v.Name, strings.Join(ids, ", "))
+	}
+	return errors.Trace(v.Dataset.Destroy("-r"))
+}
@@ -0,0 +1,106 @@
+package jetpack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/juju/errors"
+	"github.com/pborman/uuid"
+
+	"github.com/3ofcoins/jetpack/lib/zfs"
+)
+
+func TestReifyNamedVolumes(t *testing.T) {
+	pm := schema.BlankPodManifest()
+	pm.Volumes = []types.Volume{
+		{Name: *types.MustACName("data"), Kind: "host", Source: "@blog-db"},
+		{Name: *types.MustACName("logs"), Kind: "host", Source: "/var/jetpack/volumes/blog-logs"},
+		{Name: *types.MustACName("src"), Kind: "host", Source: "/usr/src"},
+	}
+	lookup := func(vol types.Volume) (*Volume, error) {
+		if name, err := namedVolumeRef(vol); err != nil {
+			return nil, err
+		} else if name != nil {
+			return &Volume{Name: *name, Dataset: &zfs.Dataset{Mountpoint: "/var/jetpack/volumes/" + name.String()}}, nil
+		} else if strings.HasPrefix(vol.Source, "/var/jetpack/volumes/") {
+			return &Volume{Name: *types.MustACName("blog-logs"), Dataset: &zfs.Dataset{Mountpoint: vol.Source}}, nil
+		}
+		return nil, nil
+	}
+	if err := reifyNamedVolumes(pm, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"/var/jetpack/volumes/blog-db", "/var/jetpack/volumes/blog-logs", "/usr/src"} {
+		if pm.Volumes[i].Source != expected {
+			t.Errorf("Volume %v: expected source %v, got %v", pm.Volumes[i].Name, expected, pm.Volumes[i].Source)
+		}
+	}
+	for name, expected := range map[string]string{
+		"jetpack/volume/data": "blog-db",
+		"jetpack/volume/logs": "blog-logs",
+	} {
+		if v, _ := pm.Annotations.Get(name); v != expected {
+			t.Errorf("Expected %v=%v, got %#v", name, expected, v)
+		}
+	}
+	if _, ok := pm.Annotations.Get("jetpack/volume/src"); ok {
+		t.Error("Host volume recorded as named volume")
+	}
+	if !usesNamedVolume(pm, *types.MustACName("blog-db")) || usesNamedVolume(pm, *types.MustACName("other")) {
+		t.Errorf("Unexpected named volumes use: %v", pm.Annotations)
+	}
+
+	pm.Volumes = []types.Volume{{Name: *types.MustACName("data"), Kind: "host", Source: "@Not_A_Name"}}
+	if err := reifyNamedVolumes(pm, lookup); err == nil {
+		t.Error("Expected error for invalid named volume reference")
+	}
+
+	pm.Volumes = []types.Volume{{Name: *types.MustACName("data"), Kind: "host", Source: "@missing"}}
+	err := reifyNamedVolumes(pm, func(types.Volume) (*Volume, error) { return nil, errors.New("Named volume missing not found") })
+	if err == nil {
+		t.Error("Expected error for missing named volume")
+	}
+}
+
+func TestVolumeDestroyInUse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "jetpack-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := &Host{Dataset: &zfs.Dataset{Mountpoint: tmp}}
+	id := uuid.NewRandom()
+	pm := schema.BlankPodManifest()
+	pm.Apps = schema.AppList{{
+		Name:  *types.MustACName("app"),
+		Image: schema.RuntimeImage{ID: *types.NewHashSHA512([]byte("image"))},
+	}}
+	pm.Annotations.Set("jetpack/volume/data", "blog-db")
+	if err := os.MkdirAll(h.Path("pods", id.String()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if manifestJSON, err := json.Marshal(pm); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(h.Path("pods", id.String(), "manifest"), manifestJSON, 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Volume{Name: *types.MustACName("blog-db"), Host: h}
+	if pods := v.Pods(); len(pods) != 1 || !uuid.Equal(pods[0].UUID, id) {
+		t.Errorf("Expected pod %v, got %v", id, pods)
+	}
+	if err := v.Destroy(); err == nil || !strings.Contains(err.Error(), id.String()) {
+		t.Errorf("Expected in-use error, got %v", err)
+	}
+
+	if pods := (&Volume{Name: *types.MustACName("other"), Host: h}).Pods(); len(pods) != 0 {
+		t.Errorf("Expected no pods, got %v", pods)
+	}
+}
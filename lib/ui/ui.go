@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
@@ -51,6 +52,27 @@ func Init() {
 	}, "")
 }
 
+// SinkFunc receives UI messages instead of the standard error
+type SinkFunc func(ts time.Time, kind, id, text string)
+
+var sink SinkFunc
+var sinkMx sync.RWMutex
+
+// SetSink makes messages be passed to fn instead of being printed to
+// the standard error. If fn is nil, messages are printed again.
+func SetSink(fn SinkFunc) {
+	sinkMx.Lock()
+	defer sinkMx.Unlock()
+	sink = fn
+}
+
+// CurrentSink returns the function set with SetSink
+func CurrentSink() SinkFunc {
+	sinkMx.RLock()
+	defer sinkMx.RUnlock()
+	return sink
+}
+
 type UI struct {
 	kind, id, format string
 }
@@ -76,6 +98,10 @@ func (ui *UI) utter(what string) {
 	if what[len(what)-1] == '\n' {
 		what = what[:len(what)-1]
 	}
+	if sink := CurrentSink(); sink != nil {
+		sink(time.Now(), ui.kind, ui.id, what)
+		return
+	}
 	ts := time.Now().Format(time.RFC3339)
 	ln := fmt.Sprintf(ui.format, ts, what)
 	if Debug {